@@ -2,18 +2,24 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/mxcd/go-config/config"
-	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"os"
 	"os/signal"
 	"rss-telegram/internal/bot"
 	"rss-telegram/internal/chats"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/fetcher"
 	"rss-telegram/internal/reader"
+	"rss-telegram/internal/settings"
+	"rss-telegram/internal/storage"
 	"rss-telegram/internal/subscription"
 	"rss-telegram/internal/utils"
+	"strconv"
 	"time"
+	_ "time/tzdata"
 )
 
 func main() {
@@ -31,20 +37,44 @@ func main() {
 
 	zerolog.SetGlobalLevel(logLevel)
 
-	log.Info().Msg("Starting redis client...")
+	log.Info().Msgf("Starting %s storage...", config.Get().String("STORAGE_BACKEND"))
 
-	redisDb, err := setupRedis()
+	store, err := setupStore()
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed pinging redis server")
+		log.Fatal().Err(err).Msg("Failed starting storage")
 	}
+	defer store.Close()
 
 	subscriptionHandler := subscription.NewSubscriptionHandler(&subscription.SubscriptionHandlerOptions{
-		RedisDb: redisDb,
+		Store: store,
+	})
+
+	err = subscriptionHandler.MigrateCanonicalURLs()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed migrating subscription urls")
+	}
+
+	settingsHandler := settings.NewSettingsHandler(&settings.SettingsHandlerOptions{
+		Store: store,
+	})
+
+	feedFetcher := fetcher.NewFetcher(&fetcher.FetcherOptions{
+		Store:        store,
+		WaitTimeout:  time.Duration(config.Get().Int("RSS_429_TIMEOUT")) * time.Second,
+		BackoffBase:  time.Duration(config.Get().Int("RSS_BACKOFF_BASE")) * time.Second,
+		BackoffMax:   time.Duration(config.Get().Int("RSS_BACKOFF_MAX")) * time.Second,
+		DeadAfter:    time.Duration(config.Get().Int("RSS_DEAD_AFTER")) * time.Second,
+		SuspendAfter: time.Duration(config.Get().Int("RSS_SUSPEND_AFTER")) * time.Second,
+		Concurrency:  config.Get().Int("RSS_WORKERS"),
+		HostSpacing:  time.Duration(config.Get().Int("RSS_HOST_SPACING")) * time.Second,
 	})
 
 	chatHandler := chats.NewChatHandler(&chats.ChatHandlerOptions{
-		RedisDb:             redisDb,
+		Store:               store,
 		SubscriptionHandler: subscriptionHandler,
+		SettingsHandler:     settingsHandler,
+		Fetcher:             feedFetcher,
+		ContextTTL:          time.Duration(config.Get().Int("CHAT_CONTEXT_TTL")) * time.Second,
 	})
 
 	log.Info().Msg("Starting bot...")
@@ -53,22 +83,39 @@ func main() {
 	defer botCancel()
 
 	botHandler, err := bot.NewBotHandler(&bot.BotHandlerOptions{
-		RedisDb:             redisDb,
+		Store:               store,
 		BotToken:            config.Get().String("BOT_TOKEN"),
 		ChatHandler:         chatHandler,
 		SubscriptionHandler: subscriptionHandler,
 		Context:             botCtx,
+		Dispatcher: &dispatcher.DispatcherOptions{
+			GlobalRate: config.Get().Int("BOT_GLOBAL_RATE"),
+			GroupRate:  config.Get().Int("BOT_GROUP_RATE"),
+			ChatRate:   config.Get().Int("BOT_CHAT_RATE"),
+			MaxRetries: config.Get().Int("BOT_RETRIES"),
+		},
+		Queue: &dispatcher.QueueOptions{
+			MaxAttempts: config.Get().Int("BOT_QUEUE_ATTEMPTS"),
+			Interval:    time.Duration(config.Get().Int("BOT_QUEUE_INTERVAL")) * time.Second,
+		},
+		AdminChatIds: adminChatIds(),
 	})
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed starting bot")
 	}
 
 	readerHandler := reader.NewReaderHandler(&reader.ReaderHandlerOptions{
-		RedisDb:             redisDb,
+		Store:               store,
 		BotHandler:          botHandler,
 		SubscriptionHandler: subscriptionHandler,
+		SettingsHandler:     settingsHandler,
 		Interval:            time.Duration(config.Get().Int("RSS_INTERVAL")) * time.Second,
-		WaitTimeout:         time.Duration(config.Get().Int("RSS_429_TIMEOUT")) * time.Second,
+		Workers:             config.Get().Int("RSS_WORKERS"),
+		ItemRetention:       config.Get().Int("RSS_ITEM_RETENTION"),
+		ItemMaxAge:          time.Duration(config.Get().Int("RSS_ITEM_MAX_AGE")) * time.Second,
+		MaxItems:            config.Get().Int("RSS_MAX_ITEMS"),
+		ChatHourlyLimit:     config.Get().Int("RSS_CHAT_HOURLY_LIMIT"),
+		Fetcher:             feedFetcher,
 	})
 
 	err = readerHandler.AddSubscriptions()
@@ -81,17 +128,35 @@ func main() {
 	botHandler.Bot.Start(botCtx)
 }
 
-func setupRedis() (*redis.Client, error) {
-	redisDb := redis.NewClient(&redis.Options{
-		Addr:     config.Get().String("REDIS_HOST"),
-		Password: config.Get().String("REDIS_PASSWORD"),
-		DB:       config.Get().Int("REDIS_DB"),
-	})
+func setupStore() (storage.Store, error) {
+	switch backend := config.Get().String("STORAGE_BACKEND"); backend {
+	case "redis":
+		return storage.NewRedisStore(&storage.RedisStoreOptions{
+			Addr:     config.Get().String("REDIS_HOST"),
+			Password: config.Get().String("REDIS_PASSWORD"),
+			DB:       config.Get().Int("REDIS_DB"),
+		})
+	case "bolt":
+		return storage.NewBoltStore(&storage.BoltStoreOptions{
+			Path: config.Get().String("STORAGE_PATH"),
+		})
+	case "memory":
+		return storage.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %s", backend)
+	}
+}
 
-	err := redisDb.Ping(context.Background()).Err()
-	if err != nil {
-		return nil, err
+func adminChatIds() []int64 {
+	var output []int64
+	for _, value := range config.Get().StringArray("ADMIN_CHAT_IDS") {
+		chatId, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Failed parsing admin chat id %s", value)
+		}
+
+		output = append(output, chatId)
 	}
 
-	return redisDb, nil
+	return output
 }
@@ -9,6 +9,8 @@ require (
 	github.com/mxcd/go-config v1.5.1
 	github.com/redis/go-redis/v9 v9.7.0
 	github.com/rs/zerolog v1.33.0
+	go.etcd.io/bbolt v1.4.0
+	golang.org/x/net v0.4.0
 )
 
 require (
@@ -26,8 +28,7 @@ require (
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/net v0.4.0 // indirect
-	golang.org/x/sys v0.12.0 // indirect
+	github.com/spf13/pflag v1.0.6 // indirect
+	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/text v0.5.0 // indirect
 )
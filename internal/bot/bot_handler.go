@@ -4,22 +4,31 @@ import (
 	"context"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
-	"github.com/redis/go-redis/v9"
 	"rss-telegram/internal/chats"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/storage"
 	"rss-telegram/internal/subscription"
 )
 
 type BotHandlerOptions struct {
 	BotToken            string
-	RedisDb             *redis.Client
+	Store               storage.Store
 	ChatHandler         *chats.ChatHandler
 	SubscriptionHandler *subscription.SubscriptionHandler
 	Context             context.Context
+	Dispatcher          *dispatcher.DispatcherOptions
+	Queue               *dispatcher.QueueOptions
+	// AdminChatIds are the chats allowed to use the admin commands
+	AdminChatIds []int64
 }
 
 type BotHandler struct {
 	Options *BotHandlerOptions
 	Bot     *bot.Bot
+	// Dispatcher sends the messages of the reader and the long replies within the rate limits
+	Dispatcher *dispatcher.Dispatcher
+	// Queue keeps the item messages until they were sent
+	Queue *dispatcher.Queue
 }
 
 func NewBotHandler(options *BotHandlerOptions) (*BotHandler, error) {
@@ -38,6 +47,22 @@ func NewBotHandler(options *BotHandlerOptions) (*BotHandler, error) {
 
 	botHandler.Bot = b
 
+	if options.Dispatcher == nil {
+		options.Dispatcher = &dispatcher.DispatcherOptions{}
+	}
+
+	botHandler.Dispatcher = dispatcher.NewDispatcher(b, options.Dispatcher)
+	options.ChatHandler.Options.Dispatcher = botHandler.Dispatcher
+
+	if options.Queue == nil {
+		options.Queue = &dispatcher.QueueOptions{}
+	}
+
+	options.Queue.Store = options.Store
+
+	botHandler.Queue = dispatcher.NewQueue(botHandler.Dispatcher, options.Queue)
+	options.ChatHandler.Options.Queue = botHandler.Queue
+
 	botHandler.registerCommands()
 
 	return botHandler, nil
@@ -7,6 +7,10 @@ import (
 	"github.com/go-telegram/bot/models"
 	"github.com/rs/zerolog/log"
 	"rss-telegram/internal/chats"
+	"rss-telegram/internal/utils"
+	"slices"
+	"strings"
+	"time"
 )
 
 func (botHandler *BotHandler) registerCommands() {
@@ -17,8 +21,19 @@ func (botHandler *BotHandler) registerCommands() {
 	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/cancel", bot.MatchTypeExact, botHandler.cancelHandler, botHandler.contextMiddleware)
 
 	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/subscriptions", bot.MatchTypeExact, botHandler.subscriptionHandler, botHandler.contextMiddleware)
+	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/status", bot.MatchTypeExact, botHandler.statusHandler, botHandler.contextMiddleware)
 	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/subscribe", bot.MatchTypeExact, botHandler.subscribeHandler, botHandler.contextMiddleware)
 	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/unsubscribe", bot.MatchTypeExact, botHandler.unsubscribeHandler, botHandler.contextMiddleware)
+	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/edit", bot.MatchTypeExact, botHandler.editHandler, botHandler.contextMiddleware)
+	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/pause", bot.MatchTypeExact, botHandler.pauseHandler, botHandler.contextMiddleware)
+	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/resume", bot.MatchTypeExact, botHandler.resumeHandler, botHandler.contextMiddleware)
+	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/snooze", bot.MatchTypePrefix, botHandler.snoozeHandler, botHandler.contextMiddleware)
+	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/settings", bot.MatchTypeExact, botHandler.settingsHandler, botHandler.contextMiddleware)
+
+	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/export", bot.MatchTypeExact, botHandler.exportHandler, botHandler.contextMiddleware)
+	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/import", bot.MatchTypeExact, botHandler.importHandler, botHandler.contextMiddleware)
+
+	botHandler.Bot.RegisterHandler(bot.HandlerTypeMessageText, "/deadletters", bot.MatchTypePrefix, botHandler.deadLettersHandler, botHandler.contextMiddleware)
 }
 
 func (botHandler *BotHandler) startHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
@@ -28,7 +43,7 @@ func (botHandler *BotHandler) startHandler(ctx context.Context, b *bot.Bot, upda
 
 	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
-		Text:   fmt.Sprintf("Hello %s\n\n/subscribe = Subscribe to a new feed\n/unsubscribe = Unsubscribe from an feed\n/subscriptions = Get active subscriptions", update.Message.Chat.Username),
+		Text:   fmt.Sprintf("Hello %s\n\n/subscribe = Subscribe to a new feed\n/unsubscribe = Unsubscribe from an feed\n/edit = Edit a subscription\n/pause = Pause a subscription\n/resume = Resume a paused subscription\n/snooze <duration> = Pause a subscription for a while (e.g. /snooze 2d)\n/settings = Set the time zone and quiet hours of the chat\n/subscriptions = Get active subscriptions\n/status = Get the health of your feeds\n/export = Export subscriptions as OPML\n/import = Import subscriptions from OPML", update.Message.Chat.Username),
 	})
 }
 
@@ -43,6 +58,10 @@ func (botHandler *BotHandler) subscriptionHandler(ctx context.Context, b *bot.Bo
 	botHandler.Options.ChatHandler.HandleSubscriptionAction(ctx, b, update)
 }
 
+func (botHandler *BotHandler) statusHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	botHandler.Options.ChatHandler.HandleStatusAction(ctx, b, update)
+}
+
 func (botHandler *BotHandler) subscribeHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	chatContext := ctx.Value("chatContext").(*chats.ChatContext)
 
@@ -56,3 +75,66 @@ func (botHandler *BotHandler) unsubscribeHandler(ctx context.Context, b *bot.Bot
 	botHandler.Options.ChatHandler.SwitchToUnsubscribeAction(chatContext)
 	botHandler.Options.ChatHandler.HandleUnsubscribeActionStart(ctx, b, update)
 }
+
+func (botHandler *BotHandler) editHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*chats.ChatContext)
+
+	botHandler.Options.ChatHandler.SwitchToEditAction(chatContext)
+	botHandler.Options.ChatHandler.HandleEditActionStart(ctx, b, update)
+}
+
+func (botHandler *BotHandler) pauseHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*chats.ChatContext)
+
+	botHandler.Options.ChatHandler.SwitchToPauseAction(chatContext, nil)
+	botHandler.Options.ChatHandler.HandlePauseActionStart(ctx, b, update)
+}
+
+func (botHandler *BotHandler) resumeHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*chats.ChatContext)
+
+	botHandler.Options.ChatHandler.SwitchToResumeAction(chatContext)
+	botHandler.Options.ChatHandler.HandleResumeActionStart(ctx, b, update)
+}
+
+func (botHandler *BotHandler) snoozeHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*chats.ChatContext)
+
+	duration, err := utils.ParseDuration(strings.TrimPrefix(update.Message.Text, "/snooze"))
+	if err != nil || duration <= 0 {
+		_ = sendMessage(b, ctx, update.Message.Chat.ID, "Usage: /snooze <duration>, e.g. /snooze 90m, /snooze 12h or /snooze 2d")
+		return
+	}
+
+	until := time.Now().Add(duration)
+
+	botHandler.Options.ChatHandler.SwitchToPauseAction(chatContext, &until)
+	botHandler.Options.ChatHandler.HandlePauseActionStart(ctx, b, update)
+}
+
+func (botHandler *BotHandler) settingsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*chats.ChatContext)
+
+	botHandler.Options.ChatHandler.SwitchToSettingsAction(chatContext)
+	botHandler.Options.ChatHandler.HandleSettingsActionStart(ctx, b, update)
+}
+
+func (botHandler *BotHandler) exportHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	botHandler.Options.ChatHandler.HandleExportAction(ctx, b, update)
+}
+
+func (botHandler *BotHandler) importHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*chats.ChatContext)
+
+	botHandler.Options.ChatHandler.SwitchToImportAction(chatContext)
+	botHandler.Options.ChatHandler.HandleImportActionStart(ctx, b, update)
+}
+
+func (botHandler *BotHandler) deadLettersHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !slices.Contains(botHandler.Options.AdminChatIds, update.Message.Chat.ID) {
+		_ = sendMessage(b, ctx, update.Message.Chat.ID, "This command is only available to admins.")
+		return
+	}
+
+	botHandler.Options.ChatHandler.HandleDeadLettersAction(ctx, b, update)
+}
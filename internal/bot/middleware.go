@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog/log"
 )
 
 func (botHandler *BotHandler) contextMiddleware(next bot.HandlerFunc) bot.HandlerFunc {
@@ -17,5 +18,10 @@ func (botHandler *BotHandler) contextMiddleware(next bot.HandlerFunc) bot.Handle
 		ctxWithChat := context.WithValue(ctx, "chatContext", chatContext)
 
 		next(ctxWithChat, b, update)
+
+		err = botHandler.Options.ChatHandler.SaveChatContext(chatContext)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed saving chat context of %d", chatContext.Chat.ID)
+		}
 	}
 }
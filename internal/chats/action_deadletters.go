@@ -0,0 +1,68 @@
+package chats
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"rss-telegram/internal/utils"
+	"strings"
+)
+
+// maxListedDeadLetters limits the dead letters shown by the admin command
+const maxListedDeadLetters = 20
+
+func (chatHandler *ChatHandler) HandleDeadLettersAction(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/deadletters")) == "replay" {
+		replayed, err := chatHandler.Options.Queue.Replay()
+		if err != nil {
+			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   fmt.Sprintf("Replayed %d dead letters before an error occurred: %s", replayed, err),
+			})
+			return
+		}
+
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Replayed %d dead letters.", replayed),
+		})
+		return
+	}
+
+	messages, total, err := chatHandler.Options.Queue.DeadLetters(maxListedDeadLetters)
+	if err != nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Dead letters could not be loaded.",
+		})
+		return
+	}
+
+	if total == 0 {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("There are no dead letters, %d messages are waiting in the delivery queue.", chatHandler.Options.Queue.Len()),
+		})
+		return
+	}
+
+	output := fmt.Sprintf("%d dead letters, %d messages are waiting in the delivery queue:\n", total, chatHandler.Options.Queue.Len())
+
+	for _, message := range messages {
+		text := []rune(strings.Join(strings.Fields(message.Text), " "))
+		if len(text) > 100 {
+			text = append(text[:100], '…')
+		}
+
+		output += fmt.Sprintf("\nChat %d, queued %s, %d attempts: %s\n%s\n", message.ChatId, message.QueuedAt.Format("01-02-2006 15:04:05"), message.Attempts, message.Error, string(text))
+	}
+
+	if total > int64(len(messages)) {
+		output += fmt.Sprintf("\n…and %d more", total-int64(len(messages)))
+	}
+
+	output += "\nSend them again with /deadletters replay"
+
+	utils.SendChunkedMessage(output, ctx, chatHandler.Options.Dispatcher, update.Message.Chat.ID, 4000, nil)
+}
@@ -0,0 +1,384 @@
+package chats
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/storage"
+	"rss-telegram/internal/subscription"
+	"rss-telegram/internal/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type EditActionStep int
+
+const (
+	SelectSubscription EditActionStep = iota
+	SelectSetting
+	EnterValue
+)
+
+type EditSetting string
+
+const (
+	EditSearchPattern EditSetting = "Search pattern"
+	EditInterval      EditSetting = "Polling interval"
+	EditMaxItems      EditSetting = "Items per check"
+	EditDelivery      EditSetting = "Delivery"
+	EditDigestTime    EditSetting = "Digest time"
+	EditIdentity      EditSetting = "Item identity"
+	EditUpdates       EditSetting = "Updated items"
+)
+
+var editSettings = []EditSetting{EditSearchPattern, EditInterval, EditMaxItems, EditDelivery, EditDigestTime, EditIdentity, EditUpdates}
+
+type EditAction struct {
+	Step           EditActionStep `json:"step"`
+	Options        []string       `json:"options"`
+	SubscriptionId string         `json:"subscriptionId"`
+	Setting        EditSetting    `json:"setting"`
+}
+
+func (chatHandler *ChatHandler) SwitchToEditAction(chatContext *ChatContext) {
+	log.Debug().Msgf("Chat %d is switching to edit action", chatContext.Chat.ID)
+
+	chatContext.CurrentAction = Edit
+	chatContext.ActionData = &EditAction{
+		Step: SelectSubscription,
+	}
+}
+
+func (chatHandler *ChatHandler) HandleEditActionStart(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*EditAction)
+
+	subscriptions, _ := chatHandler.Options.SubscriptionHandler.GetSubscriptionsFromChat(chatContext.Chat.ID)
+
+	if len(subscriptions) == 0 {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "You have not added any subscription. Subscribe with /subscribe",
+		})
+
+		chatHandler.SwitchToCancelAction(chatContext)
+		return
+	}
+
+	actionData.Options = make([]string, len(subscriptions))
+
+	output := "Enter or select the number you want to edit:\n"
+
+	for i, sub := range subscriptions {
+		actionData.Options[i] = sub.Id.String()
+		output += fmt.Sprintf("\n%d - %s", i, sub.String())
+	}
+
+	utils.SendChunkedMessage(output, ctx, chatHandler.Options.Dispatcher, update.Message.Chat.ID, 4000, getReplyMarkup(subscriptions))
+}
+
+func (chatHandler *ChatHandler) HandleEditActionMessage(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*EditAction)
+
+	switch actionData.Step {
+	case SelectSubscription:
+		chatHandler.HandleSelectSubscription(ctx, b, update)
+	case SelectSetting:
+		chatHandler.HandleSelectSetting(ctx, b, update)
+	case EnterValue:
+		chatHandler.HandleEnterValue(ctx, b, update)
+	}
+}
+
+func (chatHandler *ChatHandler) HandleSelectSubscription(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*EditAction)
+
+	i, err := strconv.Atoi(update.Message.Text)
+	if err != nil || i < 0 || i >= len(actionData.Options) {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Please enter a valid option",
+		})
+		return
+	}
+
+	actionData.SubscriptionId = actionData.Options[i]
+	actionData.Step = SelectSetting
+
+	var keyboard [][]models.KeyboardButton
+	for _, setting := range editSettings {
+		keyboard = append(keyboard, []models.KeyboardButton{{Text: string(setting)}})
+	}
+
+	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   "What do you want to change?",
+		ReplyMarkup: &models.ReplyKeyboardMarkup{
+			Keyboard:        keyboard,
+			OneTimeKeyboard: true,
+		},
+	})
+}
+
+func (chatHandler *ChatHandler) HandleSelectSetting(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*EditAction)
+
+	sub := chatHandler.getEditedSubscription(ctx, b, update)
+	if sub == nil {
+		return
+	}
+
+	switch EditSetting(update.Message.Text) {
+	case EditSearchPattern:
+		current := "no pattern"
+		if sub.SearchPattern != "" {
+			current = fmt.Sprintf("'%s'", sub.SearchPattern)
+		}
+
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("The subscription currently uses %s.\n\nEnter the new pattern (multiple words separated by a comma) or '-' to remove the pattern.", current),
+		})
+	case EditInterval:
+		current := "the default interval"
+		if sub.Interval > 0 {
+			current = fmt.Sprintf("an interval of %s", sub.Interval)
+		}
+
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      update.Message.Chat.ID,
+			Text:        fmt.Sprintf("The subscription currently uses %s.\n\nEnter the new interval (e.g. 5m, 1h, 1d) or use the default interval.", current),
+			ReplyMarkup: getIntervalReplyMarkup(),
+		})
+	case EditMaxItems:
+		current := "the default number of items"
+		if sub.MaxItems > 0 {
+			current = fmt.Sprintf("at most %d items", sub.MaxItems)
+		}
+
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("The subscription currently sends %s per check, further items are summarized in one message.\n\nEnter the new number of items or '-' to use the default.", current),
+		})
+	case EditDelivery:
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      update.Message.Chat.ID,
+			Text:        fmt.Sprintf("Items are currently delivered as: %s.\n\nDigests collect the items into one list of titles and links. Hourly digests are sent at every full hour, daily digests at the digest time and weekly digests on mondays at the digest time.", sub.Delivery),
+			ReplyMarkup: getDeliveryReplyMarkup(),
+		})
+	case EditDigestTime:
+		current := sub.DigestTime
+		if current == "" {
+			current = subscription.DefaultDigestTime
+		}
+
+		location := chatHandler.getChatSettings(chatContext.Chat.ID).Location()
+
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Daily and weekly digests are currently sent at %s (%s, change the time zone with /settings).\n\nEnter the new time, e.g. 07:30 or 18:00.", current, location),
+		})
+	case EditIdentity:
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      update.Message.Chat.ID,
+			Text:        fmt.Sprintf("Items are currently identified by: %s.\n\nAutomatic uses the guid, the link or a hash of title, date and content. Content hash recognizes items by their content, use it for feeds that change their guids on every update.", sub.Identity),
+			ReplyMarkup: getIdentityReplyMarkup(),
+		})
+	case EditUpdates:
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      update.Message.Chat.ID,
+			Text:        fmt.Sprintf("Updated items are currently handled by: %s.\n\nEdit message changes the message of an item when its title or content changes. Reply sends the changed item as reply to the original message.", sub.Updates),
+			ReplyMarkup: getUpdatesReplyMarkup(),
+		})
+	default:
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Please select a valid setting",
+		})
+		return
+	}
+
+	actionData.Setting = EditSetting(update.Message.Text)
+	actionData.Step = EnterValue
+}
+
+func (chatHandler *ChatHandler) HandleEnterValue(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*EditAction)
+
+	sub := chatHandler.getEditedSubscription(ctx, b, update)
+	if sub == nil {
+		return
+	}
+
+	edited := *sub
+
+	switch actionData.Setting {
+	case EditSearchPattern:
+		edited.SearchPattern = strings.TrimSpace(update.Message.Text)
+		if edited.SearchPattern == "-" {
+			edited.SearchPattern = ""
+		}
+	case EditInterval:
+		interval, err := parseInterval(update.Message.Text)
+		if err != nil {
+			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:      update.Message.Chat.ID,
+				Text:        fmt.Sprintf("Please enter a valid duration of at least %s", minInterval),
+				ReplyMarkup: getIntervalReplyMarkup(),
+			})
+			return
+		}
+
+		edited.Interval = interval
+	case EditMaxItems:
+		value := strings.TrimSpace(update.Message.Text)
+		if value == "-" {
+			edited.MaxItems = 0
+			break
+		}
+
+		maxItems, err := strconv.Atoi(value)
+		if err != nil || maxItems < 1 {
+			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Please enter a number of at least 1 or '-'",
+			})
+			return
+		}
+
+		edited.MaxItems = maxItems
+	case EditDelivery:
+		mode, err := subscription.ParseDeliveryMode(update.Message.Text)
+		if err != nil {
+			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:      update.Message.Chat.ID,
+				Text:        "Please select a valid option",
+				ReplyMarkup: getDeliveryReplyMarkup(),
+			})
+			return
+		}
+
+		edited.Delivery = mode
+	case EditDigestTime:
+		digestTime, err := subscription.ParseDigestTime(strings.TrimSpace(update.Message.Text))
+		if err != nil {
+			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Please enter a valid time like 07:30",
+			})
+			return
+		}
+
+		edited.DigestTime = digestTime
+	case EditIdentity:
+		identity, err := subscription.ParseItemIdentity(update.Message.Text)
+		if err != nil {
+			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:      update.Message.Chat.ID,
+				Text:        "Please select a valid option",
+				ReplyMarkup: getIdentityReplyMarkup(),
+			})
+			return
+		}
+
+		edited.Identity = identity
+	case EditUpdates:
+		mode, err := subscription.ParseUpdateMode(update.Message.Text)
+		if err != nil {
+			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:      update.Message.Chat.ID,
+				Text:        "Please select a valid option",
+				ReplyMarkup: getUpdatesReplyMarkup(),
+			})
+			return
+		}
+
+		edited.Updates = mode
+	}
+
+	err := chatHandler.Options.SubscriptionHandler.UpdateSubscription(chatContext.Chat.ID, &edited)
+	if err != nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Subscription could not be updated.",
+		})
+		return
+	}
+
+	if actionData.Setting == EditDelivery || actionData.Setting == EditDigestTime {
+		location := chatHandler.getChatSettings(chatContext.Chat.ID).Location()
+
+		err = chatHandler.Options.SubscriptionHandler.ScheduleDigest(&edited, time.Now().In(location))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed scheduling digest of %s for %d", edited.URL.String(), chatContext.Chat.ID)
+		}
+	}
+
+	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("Updated %s", edited.String()),
+	})
+
+	chatHandler.SwitchToCancelAction(chatContext)
+}
+
+func (chatHandler *ChatHandler) getEditedSubscription(ctx context.Context, b *bot.Bot, update *models.Update) *subscription.Subscription {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*EditAction)
+
+	sub, err := chatHandler.Options.SubscriptionHandler.GetSubscription(storage.SubscriptionKey{ChatId: chatContext.Chat.ID, Id: actionData.SubscriptionId})
+	if err != nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "The subscription does not exist anymore.",
+		})
+
+		chatHandler.SwitchToCancelAction(chatContext)
+		return nil
+	}
+
+	return sub
+}
+
+func getIdentityReplyMarkup() *models.ReplyKeyboardMarkup {
+	var row []models.KeyboardButton
+	for _, identity := range subscription.ItemIdentities {
+		row = append(row, models.KeyboardButton{Text: identity.String()})
+	}
+
+	return &models.ReplyKeyboardMarkup{
+		Keyboard:        [][]models.KeyboardButton{row},
+		OneTimeKeyboard: true,
+	}
+}
+
+func getUpdatesReplyMarkup() *models.ReplyKeyboardMarkup {
+	var row []models.KeyboardButton
+	for _, mode := range subscription.UpdateModes {
+		row = append(row, models.KeyboardButton{Text: mode.String()})
+	}
+
+	return &models.ReplyKeyboardMarkup{
+		Keyboard:        [][]models.KeyboardButton{row},
+		OneTimeKeyboard: true,
+	}
+}
+
+func getDeliveryReplyMarkup() *models.ReplyKeyboardMarkup {
+	var keyboard [][]models.KeyboardButton
+	for _, mode := range subscription.DeliveryModes {
+		keyboard = append(keyboard, []models.KeyboardButton{{Text: mode.String()}})
+	}
+
+	return &models.ReplyKeyboardMarkup{
+		Keyboard:        keyboard,
+		OneTimeKeyboard: true,
+	}
+}
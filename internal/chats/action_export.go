@@ -0,0 +1,53 @@
+package chats
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/opml"
+)
+
+func (chatHandler *ChatHandler) HandleExportAction(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+
+	subscriptions, _ := chatHandler.Options.SubscriptionHandler.GetSubscriptionsFromChat(chatContext.Chat.ID)
+
+	if len(subscriptions) == 0 {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "You have not added any subscription. Subscribe with /subscribe",
+		})
+		return
+	}
+
+	document := opml.New("rss-telegram subscriptions")
+
+	for _, sub := range subscriptions {
+		document.Body.Outlines = append(document.Body.Outlines, &opml.Outline{
+			Text:          sub.URL.String(),
+			Type:          "rss",
+			XMLURL:        sub.URL.String(),
+			SearchPattern: sub.SearchPattern,
+		})
+	}
+
+	data, err := document.Marshal()
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed exporting subscriptions of %d", chatContext.Chat.ID)
+
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Subscriptions could not be exported.",
+		})
+		return
+	}
+
+	_, _ = b.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:   update.Message.Chat.ID,
+		Document: &models.InputFileUpload{Filename: "subscriptions.opml", Data: bytes.NewReader(data)},
+		Caption:  fmt.Sprintf("Exported %d subscriptions", len(subscriptions)),
+	})
+}
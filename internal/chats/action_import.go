@@ -0,0 +1,156 @@
+package chats
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"net/url"
+	"rss-telegram/internal/opml"
+	"rss-telegram/internal/utils"
+)
+
+const maxImportSize = 1 << 20
+
+type ImportAction struct{}
+
+func (chatHandler *ChatHandler) SwitchToImportAction(chatContext *ChatContext) {
+	log.Debug().Msgf("Chat %d is switching to import action", chatContext.Chat.ID)
+
+	chatContext.CurrentAction = Import
+	chatContext.ActionData = &ImportAction{}
+}
+
+func (chatHandler *ChatHandler) HandleImportActionStart(ctx context.Context, b *bot.Bot, update *models.Update) {
+	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   "Send the OPML file you want to import",
+	})
+}
+
+func (chatHandler *ChatHandler) HandleImportActionMessage(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+
+	if update.Message.Document == nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Please send an OPML file or cancel with /cancel",
+		})
+		return
+	}
+
+	data, err := downloadDocument(ctx, b, update.Message.Document)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed downloading import of %d", chatContext.Chat.ID)
+
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "The file could not be downloaded, please try again",
+		})
+		return
+	}
+
+	document, err := opml.Parse(data)
+	if err != nil || len(document.Feeds()) == 0 {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "The file is not a valid OPML document or contains no feeds, please send another file",
+		})
+		return
+	}
+
+	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("Importing %d feeds, this may take a while...", len(document.Feeds())),
+	})
+
+	subscriptions, _ := chatHandler.Options.SubscriptionHandler.GetSubscriptionsFromChat(chatContext.Chat.ID)
+
+	existing := make(map[string]bool)
+	for _, sub := range subscriptions {
+		existing[utils.CanonicalURL(sub.URL).String()] = true
+	}
+
+	imported := 0
+	output := ""
+
+	for _, outline := range document.Feeds() {
+		result, ok := chatHandler.importOutline(ctx, chatContext.Chat.ID, outline, existing)
+		if ok {
+			imported++
+		}
+
+		output += fmt.Sprintf("\n%s", result)
+	}
+
+	output = fmt.Sprintf("Imported %d of %d feeds:\n%s", imported, len(document.Feeds()), output)
+
+	utils.SendChunkedMessage(output, ctx, chatHandler.Options.Dispatcher, update.Message.Chat.ID, 4000, nil)
+
+	chatHandler.SwitchToCancelAction(chatContext)
+}
+
+func (chatHandler *ChatHandler) importOutline(ctx context.Context, chatId int64, outline *opml.Outline, existing map[string]bool) (string, bool) {
+	parsedUrl, err := url.ParseRequestURI(outline.XMLURL)
+	if err != nil {
+		return fmt.Sprintf("✗ %s - invalid url", outline.XMLURL), false
+	}
+
+	parsedUrl = utils.CanonicalURL(parsedUrl)
+
+	if existing[parsedUrl.String()] {
+		return fmt.Sprintf("✗ %s - already subscribed", parsedUrl.String()), false
+	}
+
+	feed, finalUrl, err := chatHandler.Options.Fetcher.Probe(ctx, parsedUrl.String())
+	if err != nil {
+		return fmt.Sprintf("✗ %s - could not receive data from feed", parsedUrl.String()), false
+	}
+
+	parsedUrl = utils.UpgradeScheme(parsedUrl, finalUrl)
+	if existing[parsedUrl.String()] {
+		return fmt.Sprintf("✗ %s - already subscribed", parsedUrl.String()), false
+	}
+
+	subscription := chatHandler.Options.SubscriptionHandler.NewSubscription(parsedUrl, chatId, outline.SearchPattern, 0)
+
+	_, err = chatHandler.Options.SubscriptionHandler.AddSubscription(chatId, subscription)
+	if err != nil {
+		return fmt.Sprintf("✗ %s - subscription could not be added", parsedUrl.String()), false
+	}
+
+	existing[parsedUrl.String()] = true
+
+	return fmt.Sprintf("✓ %s (%s)", parsedUrl.String(), feed.Title), true
+}
+
+func downloadDocument(ctx context.Context, b *bot.Bot, document *models.Document) ([]byte, error) {
+	if document.FileSize > maxImportSize {
+		return nil, fmt.Errorf("file size %d exceeds %d bytes", document.FileSize, maxImportSize)
+	}
+
+	file, err := b.GetFile(ctx, &bot.GetFileParams{FileID: document.FileID})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.FileDownloadLink(file), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxImportSize))
+}
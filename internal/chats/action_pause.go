@@ -0,0 +1,128 @@
+package chats
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/storage"
+	"rss-telegram/internal/subscription"
+	"rss-telegram/internal/utils"
+	"strconv"
+	"time"
+)
+
+type PauseAction struct {
+	Options []string   `json:"options"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// SwitchToPauseAction pauses the selected subscription until it is resumed or,
+// if until is set, snoozes it until then
+func (chatHandler *ChatHandler) SwitchToPauseAction(chatContext *ChatContext, until *time.Time) {
+	log.Debug().Msgf("Chat %d is switching to pause action", chatContext.Chat.ID)
+
+	chatContext.CurrentAction = Pause
+	chatContext.ActionData = &PauseAction{
+		Until: until,
+	}
+}
+
+func (chatHandler *ChatHandler) HandlePauseActionStart(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*PauseAction)
+
+	subscriptions, _ := chatHandler.Options.SubscriptionHandler.GetSubscriptionsFromChat(chatContext.Chat.ID)
+
+	header := "Enter or select the number you want to pause:\n"
+	if actionData.Until != nil {
+		header = fmt.Sprintf("Enter or select the number you want to snooze until %s:\n", actionData.Until.Format("01-02-2006 15:04:05"))
+	}
+
+	actionData.Options = chatHandler.sendSubscriptionOptions(ctx, b, update, header, subscriptions)
+}
+
+func (chatHandler *ChatHandler) HandlePauseActionMessage(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*PauseAction)
+
+	sub := chatHandler.getSelectedSubscription(ctx, b, update, actionData.Options)
+	if sub == nil {
+		return
+	}
+
+	err := chatHandler.Options.SubscriptionHandler.PauseSubscription(chatContext.Chat.ID, sub, actionData.Until)
+	if err != nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Subscription could not be paused.",
+		})
+		return
+	}
+
+	text := fmt.Sprintf("Paused %s, resume with /resume", sub.URL.String())
+	if actionData.Until != nil {
+		text = fmt.Sprintf("Snoozed %s until %s", sub.URL.String(), actionData.Until.Format("01-02-2006 15:04:05"))
+	}
+
+	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+
+	chatHandler.SwitchToCancelAction(chatContext)
+}
+
+// sendSubscriptionOptions lists the subscriptions as numbered options and returns their ids
+func (chatHandler *ChatHandler) sendSubscriptionOptions(ctx context.Context, b *bot.Bot, update *models.Update, header string, subscriptions []*subscription.Subscription) []string {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+
+	if len(subscriptions) == 0 {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "There is no matching subscription.",
+		})
+
+		chatHandler.SwitchToCancelAction(chatContext)
+		return nil
+	}
+
+	options := make([]string, len(subscriptions))
+	output := header
+
+	for i, sub := range subscriptions {
+		options[i] = sub.Id.String()
+		output += fmt.Sprintf("\n%d - %s", i, sub.String())
+	}
+
+	utils.SendChunkedMessage(output, ctx, chatHandler.Options.Dispatcher, update.Message.Chat.ID, 4000, getReplyMarkup(subscriptions))
+
+	return options
+}
+
+func (chatHandler *ChatHandler) getSelectedSubscription(ctx context.Context, b *bot.Bot, update *models.Update, options []string) *subscription.Subscription {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+
+	i, err := strconv.Atoi(update.Message.Text)
+	if err != nil || i < 0 || i >= len(options) {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Please enter a valid option",
+		})
+		return nil
+	}
+
+	sub, err := chatHandler.Options.SubscriptionHandler.GetSubscription(storage.SubscriptionKey{ChatId: chatContext.Chat.ID, Id: options[i]})
+	if err != nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "The subscription does not exist anymore.",
+		})
+
+		chatHandler.SwitchToCancelAction(chatContext)
+		return nil
+	}
+
+	return sub
+}
@@ -0,0 +1,151 @@
+package chats
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/storage"
+	"rss-telegram/internal/subscription"
+	"time"
+)
+
+type ResumeActionStep int
+
+const (
+	SelectPausedSubscription ResumeActionStep = iota
+	AskCatchUp
+)
+
+const (
+	skipMissedItems = "Skip missed items"
+	sendCatchUp     = "Send catch-up summary"
+)
+
+type ResumeAction struct {
+	Step           ResumeActionStep `json:"step"`
+	Options        []string         `json:"options"`
+	SubscriptionId string           `json:"subscriptionId"`
+}
+
+func (chatHandler *ChatHandler) SwitchToResumeAction(chatContext *ChatContext) {
+	log.Debug().Msgf("Chat %d is switching to resume action", chatContext.Chat.ID)
+
+	chatContext.CurrentAction = Resume
+	chatContext.ActionData = &ResumeAction{
+		Step: SelectPausedSubscription,
+	}
+}
+
+func (chatHandler *ChatHandler) HandleResumeActionStart(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*ResumeAction)
+
+	subscriptions, _ := chatHandler.Options.SubscriptionHandler.GetSubscriptionsFromChat(chatContext.Chat.ID)
+
+	var paused []*subscription.Subscription
+	for _, sub := range subscriptions {
+		if sub.IsPaused(time.Now()) {
+			paused = append(paused, sub)
+		}
+	}
+
+	actionData.Options = chatHandler.sendSubscriptionOptions(ctx, b, update, "Enter or select the number you want to resume:\n", paused)
+}
+
+func (chatHandler *ChatHandler) HandleResumeActionMessage(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*ResumeAction)
+
+	switch actionData.Step {
+	case SelectPausedSubscription:
+		sub := chatHandler.getSelectedSubscription(ctx, b, update, actionData.Options)
+		if sub == nil {
+			return
+		}
+
+		actionData.SubscriptionId = sub.Id.String()
+		actionData.Step = AskCatchUp
+
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "What should happen with the items published while the subscription was paused?",
+			ReplyMarkup: &models.ReplyKeyboardMarkup{
+				Keyboard: [][]models.KeyboardButton{
+					{
+						{Text: skipMissedItems},
+						{Text: sendCatchUp},
+					},
+				},
+				OneTimeKeyboard: true,
+			},
+		})
+	case AskCatchUp:
+		chatHandler.HandleAskCatchUp(ctx, b, update)
+	}
+}
+
+func (chatHandler *ChatHandler) HandleAskCatchUp(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*ResumeAction)
+
+	message := update.Message.Text
+	if message != skipMissedItems && message != sendCatchUp {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Please select a valid option",
+		})
+		return
+	}
+
+	sub, err := chatHandler.Options.SubscriptionHandler.GetSubscription(storage.SubscriptionKey{ChatId: chatContext.Chat.ID, Id: actionData.SubscriptionId})
+	if err != nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "The subscription does not exist anymore.",
+		})
+
+		chatHandler.SwitchToCancelAction(chatContext)
+		return
+	}
+
+	// the missed items are only dropped once they are skipped or queued
+	items, err := chatHandler.Options.SubscriptionHandler.PeekMissedItems(sub)
+	if err == nil && message == sendCatchUp {
+		text := fmt.Sprintf("No items were missed from %s", sub.URL.String())
+		if len(items) > 0 {
+			text = subscription.RenderItemList(fmt.Sprintf("You missed %d items from %s:", len(items), sub.URL.String()), items)
+		}
+
+		key := sub.Key()
+
+		err = chatHandler.Options.Queue.Enqueue([]*dispatcher.QueuedMessage{{
+			ChatId:       chatContext.Chat.ID,
+			Subscription: &key,
+			Text:         text,
+			Chunked:      true,
+		}})
+	}
+	if err == nil {
+		err = chatHandler.Options.SubscriptionHandler.DropMissedItems(sub, len(items))
+	}
+	if err == nil {
+		err = chatHandler.Options.SubscriptionHandler.ResumeSubscription(chatContext.Chat.ID, sub)
+	}
+	if err != nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Subscription could not be resumed.",
+		})
+		return
+	}
+
+	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("Resumed %s", sub.URL.String()),
+	})
+
+	chatHandler.SwitchToCancelAction(chatContext)
+}
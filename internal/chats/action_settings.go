@@ -0,0 +1,232 @@
+package chats
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/settings"
+	"strings"
+	"time"
+)
+
+type SettingsActionStep int
+
+const (
+	SelectChatSetting SettingsActionStep = iota
+	EnterChatSetting
+)
+
+type ChatSetting string
+
+const (
+	SettingTimeZone   ChatSetting = "Time zone"
+	SettingQuietHours ChatSetting = "Quiet hours"
+	SettingQuietMode  ChatSetting = "Quiet mode"
+)
+
+var chatSettings = []ChatSetting{SettingTimeZone, SettingQuietHours, SettingQuietMode}
+
+type SettingsAction struct {
+	Step    SettingsActionStep `json:"step"`
+	Setting ChatSetting        `json:"setting"`
+}
+
+func (chatHandler *ChatHandler) SwitchToSettingsAction(chatContext *ChatContext) {
+	log.Debug().Msgf("Chat %d is switching to settings action", chatContext.Chat.ID)
+
+	chatContext.CurrentAction = Settings
+	chatContext.ActionData = &SettingsAction{
+		Step: SelectChatSetting,
+	}
+}
+
+func (chatHandler *ChatHandler) HandleSettingsActionStart(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+
+	var keyboard [][]models.KeyboardButton
+	for _, setting := range chatSettings {
+		keyboard = append(keyboard, []models.KeyboardButton{{Text: string(setting)}})
+	}
+
+	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("This chat uses %s.\n\nWhat do you want to change?", chatHandler.getChatSettings(chatContext.Chat.ID)),
+		ReplyMarkup: &models.ReplyKeyboardMarkup{
+			Keyboard:        keyboard,
+			OneTimeKeyboard: true,
+		},
+	})
+}
+
+func (chatHandler *ChatHandler) HandleSettingsActionMessage(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*SettingsAction)
+
+	switch actionData.Step {
+	case SelectChatSetting:
+		chatHandler.HandleSelectChatSetting(ctx, b, update)
+	case EnterChatSetting:
+		chatHandler.HandleEnterChatSetting(ctx, b, update)
+	}
+}
+
+func (chatHandler *ChatHandler) HandleSelectChatSetting(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*SettingsAction)
+
+	current := chatHandler.getChatSettings(chatContext.Chat.ID)
+
+	switch ChatSetting(update.Message.Text) {
+	case SettingTimeZone:
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("The chat currently uses the time zone %s, it is now %s there.\n\nEnter the new time zone, e.g. Europe/Berlin or America/New_York.", current.Location(), time.Now().In(current.Location()).Format("15:04")),
+		})
+	case SettingQuietHours:
+		hours := "no quiet hours"
+		if current.HasQuietHours() {
+			hours = fmt.Sprintf("quiet hours from %s to %s", current.QuietStart, current.QuietEnd)
+		}
+
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("The chat currently has %s.\n\nEnter the new quiet hours in the time zone of the chat, e.g. 22:00-07:00, or '-' to disable them.", hours),
+		})
+	case SettingQuietMode:
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      update.Message.Chat.ID,
+			Text:        fmt.Sprintf("Items during quiet hours are currently handled by: %s.\n\nHold until the end collects the items and sends them in one message when the quiet hours end. Send silently delivers them at once without a notification sound.", current.QuietMode),
+			ReplyMarkup: getQuietModeReplyMarkup(),
+		})
+	default:
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Please select a valid setting",
+		})
+		return
+	}
+
+	actionData.Setting = ChatSetting(update.Message.Text)
+	actionData.Step = EnterChatSetting
+}
+
+func (chatHandler *ChatHandler) HandleEnterChatSetting(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*SettingsAction)
+
+	edited := *chatHandler.getChatSettings(chatContext.Chat.ID)
+	value := strings.TrimSpace(update.Message.Text)
+
+	switch actionData.Setting {
+	case SettingTimeZone:
+		timeZone, err := settings.ParseTimeZone(value)
+		if err != nil {
+			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Please enter a valid time zone like Europe/Berlin",
+			})
+			return
+		}
+
+		edited.TimeZone = timeZone
+	case SettingQuietHours:
+		if value == "-" {
+			edited.QuietStart = ""
+			edited.QuietEnd = ""
+			break
+		}
+
+		start, end, err := settings.ParseQuietHours(value)
+		if err != nil {
+			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Please enter a valid range like 22:00-07:00 or '-'",
+			})
+			return
+		}
+
+		edited.QuietStart = start
+		edited.QuietEnd = end
+	case SettingQuietMode:
+		mode, err := settings.ParseQuietMode(value)
+		if err != nil {
+			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID:      update.Message.Chat.ID,
+				Text:        "Please select a valid option",
+				ReplyMarkup: getQuietModeReplyMarkup(),
+			})
+			return
+		}
+
+		edited.QuietMode = mode
+	}
+
+	err := chatHandler.Options.SettingsHandler.SaveChatSettings(chatContext.Chat.ID, &edited)
+	if err != nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Settings could not be updated.",
+		})
+		return
+	}
+
+	if actionData.Setting == SettingTimeZone {
+		chatHandler.rescheduleDigests(chatContext.Chat.ID, edited.Location())
+	}
+
+	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("This chat now uses %s", edited.String()),
+	})
+
+	chatHandler.SwitchToCancelAction(chatContext)
+}
+
+// getChatSettings returns the settings of the chat or the defaults if they can not be read
+func (chatHandler *ChatHandler) getChatSettings(chatId int64) *settings.ChatSettings {
+	if chatHandler.Options.SettingsHandler == nil {
+		return &settings.ChatSettings{}
+	}
+
+	current, err := chatHandler.Options.SettingsHandler.GetChatSettings(chatId)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed reading settings of %d", chatId)
+		return &settings.ChatSettings{}
+	}
+
+	return current
+}
+
+// rescheduleDigests moves the next digests of the chat to the digest time in the new time zone
+func (chatHandler *ChatHandler) rescheduleDigests(chatId int64, location *time.Location) {
+	subscriptions, err := chatHandler.Options.SubscriptionHandler.GetSubscriptionsFromChat(chatId)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed loading subscriptions of %d for rescheduling digests", chatId)
+		return
+	}
+
+	for _, sub := range subscriptions {
+		if !sub.IsDigest() {
+			continue
+		}
+
+		err = chatHandler.Options.SubscriptionHandler.ScheduleDigest(sub, time.Now().In(location))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed scheduling digest of %s for %d", sub.URL.String(), chatId)
+		}
+	}
+}
+
+func getQuietModeReplyMarkup() *models.ReplyKeyboardMarkup {
+	var keyboard [][]models.KeyboardButton
+	for _, mode := range settings.QuietModes {
+		keyboard = append(keyboard, []models.KeyboardButton{{Text: mode.String()}})
+	}
+
+	return &models.ReplyKeyboardMarkup{
+		Keyboard:        keyboard,
+		OneTimeKeyboard: true,
+	}
+}
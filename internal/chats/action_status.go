@@ -0,0 +1,51 @@
+package chats
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"rss-telegram/internal/utils"
+)
+
+func (chatHandler *ChatHandler) HandleStatusAction(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+
+	subscriptions, _ := chatHandler.Options.SubscriptionHandler.GetSubscriptionsFromChat(chatContext.Chat.ID)
+
+	if len(subscriptions) == 0 {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "You have not added any subscription. Subscribe with /subscribe",
+		})
+		return
+	}
+
+	output := "Status of your subscriptions:\n"
+
+	for _, sub := range subscriptions {
+		state, err := chatHandler.Options.Fetcher.GetState(utils.CanonicalURL(sub.URL).String())
+		if err != nil {
+			output += fmt.Sprintf("\n%s - status unavailable\n", sub.URL.String())
+			continue
+		}
+
+		lastSuccess := "never"
+		if !state.LastSuccess.IsZero() {
+			lastSuccess = state.LastSuccess.Format("01-02-2006 15:04:05")
+		}
+
+		output += fmt.Sprintf("\n%s - %s, last successful fetch %s", sub.URL.String(), state.Health, lastSuccess)
+
+		if state.LastError != "" {
+			output += fmt.Sprintf("\nLast error (%d failures in a row): %s", state.ConsecutiveFailures, state.LastError)
+		}
+
+		output += "\n"
+	}
+
+	output += fmt.Sprintf("\nFetch limits: %s", chatHandler.Options.Fetcher.Limits())
+	output += fmt.Sprintf("\nMessages: %s", chatHandler.Options.Dispatcher.Metrics())
+
+	utils.SendChunkedMessage(output, ctx, chatHandler.Options.Dispatcher, update.Message.Chat.ID, 4000, nil)
+}
@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
-	"github.com/mmcdole/gofeed"
 	"github.com/rs/zerolog/log"
 	"math"
 	"net/url"
+	"rss-telegram/internal/fetcher"
 	"rss-telegram/internal/utils"
 	"slices"
 	"strconv"
+	"time"
 )
 
 type SubscribeActionStep int
@@ -20,15 +21,25 @@ const (
 	AskURL SubscribeActionStep = iota
 	AskAddPattern
 	EnterPattern
+	AskInterval
+	SelectFeed
+)
+
+const (
+	defaultInterval = "Default"
+	minInterval     = 10 * time.Second
 )
 
 type SubscribeAction struct {
-	step               SubscribeActionStep
-	url                *url.URL
-	addPattern         bool
-	patternSuggestions []string
-	pattern            string
-	feed               *gofeed.Feed
+	Step               SubscribeActionStep `json:"step"`
+	URL                *url.URL            `json:"url"`
+	AddPattern         bool                `json:"addPattern"`
+	PatternSuggestions []string            `json:"patternSuggestions"`
+	Pattern            string              `json:"pattern"`
+	FeedTitle          string              `json:"feedTitle"`
+	Interval           time.Duration       `json:"interval"`
+
+	DiscoveredFeeds []*fetcher.DiscoveredFeed `json:"discoveredFeeds,omitempty"`
 }
 
 func (chatHandler *ChatHandler) SwitchToSubscribeAction(chatContext *ChatContext) {
@@ -36,7 +47,7 @@ func (chatHandler *ChatHandler) SwitchToSubscribeAction(chatContext *ChatContext
 
 	chatContext.CurrentAction = Subscribe
 	chatContext.ActionData = &SubscribeAction{
-		step: AskURL,
+		Step: AskURL,
 	}
 }
 
@@ -51,13 +62,17 @@ func (chatHandler *ChatHandler) HandleSubscribeActionMessage(ctx context.Context
 	chatContext := ctx.Value("chatContext").(*ChatContext)
 	actionData := chatContext.ActionData.(*SubscribeAction)
 
-	switch actionData.step {
+	switch actionData.Step {
 	case AskURL:
 		chatHandler.HandleAskUrl(ctx, b, update)
+	case SelectFeed:
+		chatHandler.HandleSelectFeed(ctx, b, update)
 	case AskAddPattern:
 		chatHandler.HandleAskAddPattern(ctx, b, update)
 	case EnterPattern:
 		chatHandler.HandleEnterPattern(ctx, b, update)
+	case AskInterval:
+		chatHandler.HandleAskInterval(ctx, b, update)
 	}
 }
 
@@ -77,19 +92,68 @@ func (chatHandler *ChatHandler) HandleAskUrl(ctx context.Context, b *bot.Bot, up
 		return
 	}
 
-	fp := gofeed.NewParser()
-	actionData.feed, err = fp.ParseURL(parsedUrl.String())
-	if err != nil {
+	parsedUrl = utils.CanonicalURL(parsedUrl)
+
+	feed, finalUrl, err := chatHandler.Options.Fetcher.Probe(ctx, parsedUrl.String())
+	if err == nil {
+		chatHandler.AskAddPattern(ctx, b, update, utils.UpgradeScheme(parsedUrl, finalUrl), feed.Title)
+		return
+	}
+
+	// the url is no feed, look for feeds of the website instead
+	feeds, err := chatHandler.Options.Fetcher.Discover(ctx, parsedUrl.String())
+	if err != nil || len(feeds) == 0 {
 		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
-			Text:   "Could not receive data from feed, please enter a valid url",
+			Text:   "Could not receive data from feed or find a feed on the website, please enter a valid url",
 		})
 		return
 	}
 
-	actionData.url = parsedUrl
+	if len(feeds) == 1 {
+		chatHandler.AskAddPattern(ctx, b, update, feeds[0].URL, feeds[0].Title)
+		return
+	}
+
+	actionData.DiscoveredFeeds = feeds
+	actionData.Step = SelectFeed
+
+	output := "The website offers multiple feeds, enter or select the number you want to subscribe to:\n"
+	for i, discovered := range feeds {
+		output += fmt.Sprintf("\n%d - %s (%s)", i, discovered.Title, discovered.URL.String())
+	}
 
-	actionData.step = AskAddPattern
+	utils.SendChunkedMessage(output, ctx, chatHandler.Options.Dispatcher, update.Message.Chat.ID, 4000, getNumberedReplyMarkup(len(feeds)))
+}
+
+func (chatHandler *ChatHandler) HandleSelectFeed(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*SubscribeAction)
+
+	i, err := strconv.Atoi(update.Message.Text)
+	if err != nil || i < 0 || i >= len(actionData.DiscoveredFeeds) {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      update.Message.Chat.ID,
+			Text:        "Please enter a valid option",
+			ReplyMarkup: getNumberedReplyMarkup(len(actionData.DiscoveredFeeds)),
+		})
+		return
+	}
+
+	selected := actionData.DiscoveredFeeds[i]
+	actionData.DiscoveredFeeds = nil
+
+	chatHandler.AskAddPattern(ctx, b, update, selected.URL, selected.Title)
+}
+
+func (chatHandler *ChatHandler) AskAddPattern(ctx context.Context, b *bot.Bot, update *models.Update, feedUrl *url.URL, feedTitle string) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*SubscribeAction)
+
+	actionData.URL = feedUrl
+	actionData.FeedTitle = feedTitle
+
+	actionData.Step = AskAddPattern
 
 	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
@@ -112,9 +176,9 @@ func (chatHandler *ChatHandler) HandleAskAddPattern(ctx context.Context, b *bot.
 
 	message := update.Message.Text
 
-	actionData.addPattern = message == "Yes"
+	actionData.AddPattern = message == "Yes"
 
-	if actionData.addPattern {
+	if actionData.AddPattern {
 		subscriptions, _ := chatHandler.Options.SubscriptionHandler.GetSubscriptionsFromChat(chatContext.Chat.ID)
 
 		var existingPattern []string
@@ -137,7 +201,7 @@ func (chatHandler *ChatHandler) HandleAskAddPattern(ctx context.Context, b *bot.
 			hasSuggestions = true
 		}
 
-		actionData.patternSuggestions = existingPattern
+		actionData.PatternSuggestions = existingPattern
 
 		var options = make([][]models.KeyboardButton, int(math.Ceil(float64(len(existingPattern))/3)))
 
@@ -161,7 +225,7 @@ func (chatHandler *ChatHandler) HandleAskAddPattern(ctx context.Context, b *bot.
 		if hasSuggestions {
 			text := fmt.Sprintf("Enter the pattern (e. g. 'polls' to only receive items with title, url or description containing 'polls')\n\nYou can add multiple words separated by a comma.\n\n%s", optionsText)
 
-			utils.SendChunkedMessage(text, ctx, b, update.Message.Chat.ID, 4000, &models.ReplyKeyboardMarkup{Keyboard: options, OneTimeKeyboard: true})
+			utils.SendChunkedMessage(text, ctx, chatHandler.Options.Dispatcher, update.Message.Chat.ID, 4000, &models.ReplyKeyboardMarkup{Keyboard: options, OneTimeKeyboard: true})
 		} else {
 			text := "Enter the pattern (e. g. 'polls' to only receive items with title, url or description containing 'polls')\n\nYou can add multiple words separated by a comma."
 
@@ -171,9 +235,9 @@ func (chatHandler *ChatHandler) HandleAskAddPattern(ctx context.Context, b *bot.
 			})
 		}
 
-		actionData.step = EnterPattern
+		actionData.Step = EnterPattern
 	} else {
-		chatHandler.AddSubscription(ctx, b, update)
+		chatHandler.AskInterval(ctx, b, update)
 	}
 }
 
@@ -185,12 +249,44 @@ func (chatHandler *ChatHandler) HandleEnterPattern(ctx context.Context, b *bot.B
 
 	i, err := strconv.Atoi(message)
 	if err == nil {
-		if i >= 0 && i < len(actionData.patternSuggestions) {
-			message = actionData.patternSuggestions[i]
+		if i >= 0 && i < len(actionData.PatternSuggestions) {
+			message = actionData.PatternSuggestions[i]
 		}
 	}
 
-	actionData.pattern = message
+	actionData.Pattern = message
+
+	chatHandler.AskInterval(ctx, b, update)
+}
+
+func (chatHandler *ChatHandler) AskInterval(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*SubscribeAction)
+
+	actionData.Step = AskInterval
+
+	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      update.Message.Chat.ID,
+		Text:        "How often should the feed be checked? Enter a duration (e.g. 5m, 1h, 1d) or use the default interval.",
+		ReplyMarkup: getIntervalReplyMarkup(),
+	})
+}
+
+func (chatHandler *ChatHandler) HandleAskInterval(ctx context.Context, b *bot.Bot, update *models.Update) {
+	chatContext := ctx.Value("chatContext").(*ChatContext)
+	actionData := chatContext.ActionData.(*SubscribeAction)
+
+	interval, err := parseInterval(update.Message.Text)
+	if err != nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      update.Message.Chat.ID,
+			Text:        fmt.Sprintf("Please enter a valid duration of at least %s", minInterval),
+			ReplyMarkup: getIntervalReplyMarkup(),
+		})
+		return
+	}
+
+	actionData.Interval = interval
 
 	chatHandler.AddSubscription(ctx, b, update)
 }
@@ -199,7 +295,7 @@ func (chatHandler *ChatHandler) AddSubscription(ctx context.Context, b *bot.Bot,
 	chatContext := ctx.Value("chatContext").(*ChatContext)
 	actionData := chatContext.ActionData.(*SubscribeAction)
 
-	subscription := chatHandler.Options.SubscriptionHandler.NewSubscription(actionData.url, chatContext.Chat.ID, actionData.pattern)
+	subscription := chatHandler.Options.SubscriptionHandler.NewSubscription(actionData.URL, chatContext.Chat.ID, actionData.Pattern, actionData.Interval)
 
 	_, err := chatHandler.Options.SubscriptionHandler.AddSubscription(chatContext.Chat.ID, subscription)
 	if err != nil {
@@ -212,8 +308,40 @@ func (chatHandler *ChatHandler) AddSubscription(ctx context.Context, b *bot.Bot,
 
 	_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
-		Text:   fmt.Sprintf("Subscribed to %s (%s)", actionData.url.String(), actionData.feed.Title),
+		Text:   fmt.Sprintf("Subscribed to %s (%s)", actionData.URL.String(), actionData.FeedTitle),
 	})
 
 	chatHandler.SwitchToCancelAction(chatContext)
 }
+
+// parseInterval returns zero for the default interval
+func parseInterval(message string) (time.Duration, error) {
+	if message == defaultInterval {
+		return 0, nil
+	}
+
+	interval, err := utils.ParseDuration(message)
+	if err != nil {
+		return 0, err
+	}
+
+	if interval < minInterval {
+		return 0, fmt.Errorf("interval %s is shorter than %s", interval, minInterval)
+	}
+
+	return interval, nil
+}
+
+func getIntervalReplyMarkup() *models.ReplyKeyboardMarkup {
+	return &models.ReplyKeyboardMarkup{
+		Keyboard: [][]models.KeyboardButton{
+			{
+				{Text: defaultInterval},
+				{Text: "5m"},
+				{Text: "1h"},
+				{Text: "1d"},
+			},
+		},
+		OneTimeKeyboard: true,
+	}
+}
@@ -25,6 +25,6 @@ func (chatHandler *ChatHandler) HandleSubscriptionAction(ctx context.Context, b
 			output += fmt.Sprintf("\n%s", subscription.String())
 		}
 
-		utils.SendChunkedMessage(output, ctx, b, update.Message.Chat.ID, 4000, nil)
+		utils.SendChunkedMessage(output, ctx, chatHandler.Options.Dispatcher, update.Message.Chat.ID, 4000, nil)
 	}
 }
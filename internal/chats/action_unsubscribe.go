@@ -7,13 +7,14 @@ import (
 	"github.com/go-telegram/bot/models"
 	"github.com/rs/zerolog/log"
 	"math"
+	"rss-telegram/internal/storage"
 	"rss-telegram/internal/subscription"
 	"rss-telegram/internal/utils"
 	"strconv"
 )
 
 type UnsubscribeAction struct {
-	options []*subscription.Subscription
+	Options []string `json:"options"`
 }
 
 func (chatHandler *ChatHandler) SwitchToUnsubscribeAction(chatContext *ChatContext) {
@@ -37,15 +38,16 @@ func (chatHandler *ChatHandler) HandleUnsubscribeActionStart(ctx context.Context
 
 		chatHandler.SwitchToCancelAction(chatContext)
 	} else {
-		actionData.options = subscriptions
+		actionData.Options = make([]string, len(subscriptions))
 
 		output := "Enter or select the number you want to unsubscribe:\n"
 
 		for i, sub := range subscriptions {
+			actionData.Options[i] = sub.Id.String()
 			output += fmt.Sprintf("\n%d - %s", i, sub.URL.String())
 		}
 
-		utils.SendChunkedMessage(output, ctx, b, update.Message.Chat.ID, 4000, getReplyMarkup(actionData.options))
+		utils.SendChunkedMessage(output, ctx, chatHandler.Options.Dispatcher, update.Message.Chat.ID, 4000, getReplyMarkup(subscriptions))
 
 	}
 }
@@ -54,26 +56,33 @@ func (chatHandler *ChatHandler) HandleUnsubscribeActionMessage(ctx context.Conte
 	chatContext := ctx.Value("chatContext").(*ChatContext)
 	actionData := chatContext.ActionData.(*UnsubscribeAction)
 
-	message := update.Message.Text
+	i, err := strconv.Atoi(update.Message.Text)
+	if err != nil || i < 0 || i >= len(actionData.Options) {
+		output := "Please enter a valid option:\n"
 
-	var foundSubscription *subscription.Subscription = nil
+		for i, id := range actionData.Options {
+			sub, err := chatHandler.Options.SubscriptionHandler.GetSubscription(storage.SubscriptionKey{ChatId: chatContext.Chat.ID, Id: id})
+			if err != nil {
+				output += fmt.Sprintf("\n%d - (removed)", i)
+				continue
+			}
 
-	for i, sub := range actionData.options {
-		if strconv.Itoa(i) == message {
-			foundSubscription = sub
-			break
+			output += fmt.Sprintf("\n%d - %s", i, sub.URL.String())
 		}
-	}
 
-	if foundSubscription == nil {
-		output := "Please enter a valid option:\n"
+		utils.SendChunkedMessage(output, ctx, chatHandler.Options.Dispatcher, update.Message.Chat.ID, 4000, getNumberedReplyMarkup(len(actionData.Options)))
 
-		for i, sub := range actionData.options {
-			output += fmt.Sprintf("\n%d - %s", i, sub.URL.String())
-		}
+		return
+	}
 
-		utils.SendChunkedMessage(output, ctx, b, update.Message.Chat.ID, 4000, getReplyMarkup(actionData.options))
+	foundSubscription, err := chatHandler.Options.SubscriptionHandler.GetSubscription(storage.SubscriptionKey{ChatId: chatContext.Chat.ID, Id: actionData.Options[i]})
+	if err != nil {
+		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "The subscription does not exist anymore.",
+		})
 
+		chatHandler.SwitchToCancelAction(chatContext)
 		return
 	}
 
@@ -86,9 +95,14 @@ func (chatHandler *ChatHandler) HandleUnsubscribeActionMessage(ctx context.Conte
 }
 
 func getReplyMarkup(subscriptions []*subscription.Subscription) *models.ReplyKeyboardMarkup {
-	var options = make([][]models.KeyboardButton, int(math.Ceil(float64(len(subscriptions))/3)))
+	return getNumberedReplyMarkup(len(subscriptions))
+}
+
+// getNumberedReplyMarkup offers the option numbers from 0 to count-1 in rows of three
+func getNumberedReplyMarkup(count int) *models.ReplyKeyboardMarkup {
+	var options = make([][]models.KeyboardButton, int(math.Ceil(float64(count)/3)))
 
-	for i, _ := range subscriptions {
+	for i := 0; i < count; i++ {
 		row := i / 3
 		position := i % 3
 
@@ -12,8 +12,34 @@ const (
 	None CurrentAction = iota
 	Subscribe
 	Unsubscribe
+	Import
+	Edit
+	Pause
+	Resume
+	Settings
 )
 
+func newActionData(action CurrentAction) interface{} {
+	switch action {
+	case Subscribe:
+		return &SubscribeAction{}
+	case Unsubscribe:
+		return &UnsubscribeAction{}
+	case Import:
+		return &ImportAction{}
+	case Edit:
+		return &EditAction{}
+	case Pause:
+		return &PauseAction{}
+	case Resume:
+		return &ResumeAction{}
+	case Settings:
+		return &SettingsAction{}
+	default:
+		return nil
+	}
+}
+
 func (chatHandler *ChatHandler) PassMessageHandlerToAction(ctx context.Context, b *bot.Bot, update *models.Update) {
 	chatContext := ctx.Value("chatContext").(*ChatContext)
 
@@ -22,6 +48,16 @@ func (chatHandler *ChatHandler) PassMessageHandlerToAction(ctx context.Context,
 		chatHandler.HandleSubscribeActionMessage(ctx, b, update)
 	case Unsubscribe:
 		chatHandler.HandleUnsubscribeActionMessage(ctx, b, update)
+	case Import:
+		chatHandler.HandleImportActionMessage(ctx, b, update)
+	case Edit:
+		chatHandler.HandleEditActionMessage(ctx, b, update)
+	case Pause:
+		chatHandler.HandlePauseActionMessage(ctx, b, update)
+	case Resume:
+		chatHandler.HandleResumeActionMessage(ctx, b, update)
+	case Settings:
+		chatHandler.HandleSettingsActionMessage(ctx, b, update)
 	default:
 		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
@@ -2,28 +2,34 @@ package chats
 
 import (
 	"context"
-	"github.com/redis/go-redis/v9"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/fetcher"
+	"rss-telegram/internal/settings"
+	"rss-telegram/internal/storage"
 	"rss-telegram/internal/subscription"
-	"sync"
+	"time"
 )
 
 type ChatHandlerOptions struct {
-	RedisDb             *redis.Client
+	Store               storage.Store
 	SubscriptionHandler *subscription.SubscriptionHandler
+	SettingsHandler     *settings.SettingsHandler
+	Fetcher             *fetcher.Fetcher
+	ContextTTL          time.Duration
+	// Dispatcher and Queue are set by the bot handler
+	Dispatcher *dispatcher.Dispatcher
+	Queue      *dispatcher.Queue
 }
 
 type ChatHandler struct {
-	Options          *ChatHandlerOptions
-	Context          context.Context
-	chatContextCache map[int64]*ChatContext
-	lock             sync.Mutex
+	Options *ChatHandlerOptions
+	Context context.Context
 }
 
 func NewChatHandler(options *ChatHandlerOptions) *ChatHandler {
 	chatHandler := &ChatHandler{
-		Options:          options,
-		Context:          context.Background(),
-		chatContextCache: make(map[int64]*ChatContext),
+		Options: options,
+		Context: context.Background(),
 	}
 
 	return chatHandler
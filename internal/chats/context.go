@@ -1,7 +1,11 @@
 package chats
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/storage"
 )
 
 type ChatContext struct {
@@ -10,24 +14,80 @@ type ChatContext struct {
 	ActionData    interface{}
 }
 
+type storedChatContext struct {
+	Chat          *models.Chat    `json:"chat"`
+	CurrentAction CurrentAction   `json:"currentAction"`
+	ActionData    json.RawMessage `json:"actionData"`
+}
+
 func (chatHandler *ChatHandler) UpsertChatContext(chat *models.Chat) (*ChatContext, error) {
-	chatContext, ok := chatHandler.chatContextCache[chat.ID]
-	if ok {
-		return chatContext, nil
+	data, err := chatHandler.Options.Store.GetChatContext(chat.ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return chatHandler.newChatContext(chat), nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	chatContext = chatHandler.newChatContext(chat)
+	chatContext := &ChatContext{}
+	err = json.Unmarshal(data, chatContext)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Discarding unreadable chat context of %d", chat.ID)
+		return chatHandler.newChatContext(chat), nil
+	}
 
-	chatHandler.lock.Lock()
-	chatHandler.chatContextCache[chat.ID] = chatContext
-	chatHandler.lock.Unlock()
+	chatContext.Chat = chat
 
 	return chatContext, nil
 }
 
+func (chatHandler *ChatHandler) SaveChatContext(chatContext *ChatContext) error {
+	if chatContext.CurrentAction == None {
+		return chatHandler.Options.Store.DeleteChatContext(chatContext.Chat.ID)
+	}
+
+	data, err := json.Marshal(chatContext)
+	if err != nil {
+		return err
+	}
+
+	return chatHandler.Options.Store.SaveChatContext(chatContext.Chat.ID, data, chatHandler.Options.ContextTTL)
+}
+
 func (chatHandler *ChatHandler) newChatContext(chat *models.Chat) *ChatContext {
 	return &ChatContext{
 		Chat:          chat,
 		CurrentAction: None,
 	}
 }
+
+func (chatContext *ChatContext) MarshalJSON() ([]byte, error) {
+	actionData, err := json.Marshal(chatContext.ActionData)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&storedChatContext{
+		Chat:          chatContext.Chat,
+		CurrentAction: chatContext.CurrentAction,
+		ActionData:    actionData,
+	})
+}
+
+func (chatContext *ChatContext) UnmarshalJSON(data []byte) error {
+	var stored storedChatContext
+	err := json.Unmarshal(data, &stored)
+	if err != nil {
+		return err
+	}
+
+	chatContext.Chat = stored.Chat
+	chatContext.CurrentAction = stored.CurrentAction
+	chatContext.ActionData = newActionData(stored.CurrentAction)
+
+	if chatContext.ActionData == nil || len(stored.ActionData) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(stored.ActionData, chatContext.ActionData)
+}
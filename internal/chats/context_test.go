@@ -0,0 +1,241 @@
+package chats
+
+import (
+	"context"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rss-telegram/internal/storage"
+	"rss-telegram/internal/subscription"
+	"testing"
+	"time"
+)
+
+func TestChatContext(t *testing.T) {
+	chatHandler := NewChatHandler(&ChatHandlerOptions{
+		Store:      storage.NewMemoryStore(),
+		ContextTTL: time.Minute,
+	})
+
+	chat := &models.Chat{ID: 42}
+
+	t.Run("Test chat context survives a restart", func(t *testing.T) {
+		chatContext, err := chatHandler.UpsertChatContext(chat)
+		if err != nil {
+			t.Fatalf("Could not create chat context: %v", err)
+		}
+
+		feedUrl, _ := url.Parse("https://example.com/feed")
+
+		chatContext.CurrentAction = Subscribe
+		chatContext.ActionData = &SubscribeAction{
+			Step:               EnterPattern,
+			URL:                feedUrl,
+			PatternSuggestions: []string{"news"},
+		}
+
+		err = chatHandler.SaveChatContext(chatContext)
+		if err != nil {
+			t.Fatalf("Could not save chat context: %v", err)
+		}
+
+		restarted := NewChatHandler(chatHandler.Options)
+
+		loaded, err := restarted.UpsertChatContext(chat)
+		if err != nil {
+			t.Fatalf("Could not load chat context: %v", err)
+		}
+
+		actionData, ok := loaded.ActionData.(*SubscribeAction)
+		if loaded.CurrentAction != Subscribe || !ok {
+			t.Fatalf("Loaded action is incorrect, got: %d (%T)", loaded.CurrentAction, loaded.ActionData)
+		}
+
+		if actionData.Step != EnterPattern || actionData.URL.String() != feedUrl.String() || len(actionData.PatternSuggestions) != 1 {
+			t.Errorf("Loaded action data is incorrect, got: %+v", actionData)
+		}
+	})
+
+	t.Run("Test unsubscribe options are kept as ids", func(t *testing.T) {
+		chatContext, _ := chatHandler.UpsertChatContext(chat)
+
+		chatContext.CurrentAction = Unsubscribe
+		chatContext.ActionData = &UnsubscribeAction{Options: []string{"first", "second"}}
+
+		err := chatHandler.SaveChatContext(chatContext)
+		if err != nil {
+			t.Fatalf("Could not save chat context: %v", err)
+		}
+
+		loaded, _ := NewChatHandler(chatHandler.Options).UpsertChatContext(chat)
+
+		actionData, ok := loaded.ActionData.(*UnsubscribeAction)
+		if !ok || len(actionData.Options) != 2 || actionData.Options[1] != "second" {
+			t.Errorf("Loaded action data is incorrect, got: %+v", loaded.ActionData)
+		}
+	})
+
+	t.Run("Test finished action is removed", func(t *testing.T) {
+		chatContext, _ := chatHandler.UpsertChatContext(chat)
+		chatHandler.SwitchToCancelAction(chatContext)
+
+		err := chatHandler.SaveChatContext(chatContext)
+		if err != nil {
+			t.Fatalf("Could not save chat context: %v", err)
+		}
+
+		loaded, _ := chatHandler.UpsertChatContext(chat)
+		if loaded.CurrentAction != None || loaded.ActionData != nil {
+			t.Errorf("Canceled action is still present, got: %d", loaded.CurrentAction)
+		}
+	})
+}
+
+func TestEditAction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	}))
+	defer server.Close()
+
+	b, err := bot.New("token", bot.WithServerURL(server.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemoryStore()
+	subscriptionHandler := subscription.NewSubscriptionHandler(&subscription.SubscriptionHandlerOptions{Store: store})
+
+	chatHandler := NewChatHandler(&ChatHandlerOptions{
+		Store:               store,
+		SubscriptionHandler: subscriptionHandler,
+		ContextTTL:          time.Minute,
+	})
+
+	chat := &models.Chat{ID: 42}
+	feedUrl, _ := url.Parse("https://example.com/feed")
+
+	sub := &subscription.Subscription{Id: uuid.New(), ChatId: chat.ID, URL: feedUrl, SearchPattern: "news"}
+
+	_, err = subscriptionHandler.AddSubscription(chat.ID, sub)
+	if err != nil {
+		t.Fatalf("Could not add subscription: %v", err)
+	}
+
+	// enterValue answers the value step of an edit of the given setting
+	enterValue := func(setting EditSetting, text string) {
+		chatContext, _ := chatHandler.UpsertChatContext(chat)
+		chatContext.CurrentAction = Edit
+		chatContext.ActionData = &EditAction{Step: EnterValue, SubscriptionId: sub.Id.String(), Setting: setting}
+
+		ctx := context.WithValue(context.Background(), "chatContext", chatContext)
+		chatHandler.HandleEditActionMessage(ctx, b, &models.Update{Message: &models.Message{Chat: *chat, Text: text}})
+	}
+
+	edited := func() *subscription.Subscription {
+		stored, err := subscriptionHandler.GetSubscription(sub.Key())
+		if err != nil {
+			t.Fatalf("Could not get subscription: %v", err)
+		}
+
+		return stored
+	}
+
+	t.Run("Test pattern is changed in place", func(t *testing.T) {
+		_ = store.SetCursor(sub.Key(), 42)
+		_ = store.SaveDelivery(sub.Key(), "item", []byte("delivery"))
+
+		enterValue(EditSearchPattern, " sports, weather ")
+
+		stored := edited()
+		if stored.SearchPattern != "sports, weather" || stored.Id != sub.Id || stored.URL.String() != feedUrl.String() {
+			t.Errorf("Edited subscription is incorrect, got: %+v", stored)
+		}
+
+		keys, _ := store.GetSubscriptionKeys(chat.ID)
+		if len(keys) != 1 {
+			t.Errorf("Expected the subscription to be kept, got %d subscriptions", len(keys))
+		}
+
+		cursor, _ := store.GetCursor(sub.Key())
+		if cursor != 42 {
+			t.Errorf("Expected the cursor to be kept, got: %d", cursor)
+		}
+
+		deliveries, _ := store.GetDeliveries(sub.Key(), []string{"item"})
+		if string(deliveries["item"]) != "delivery" {
+			t.Errorf("Expected the deliveries to be kept, got: %v", deliveries)
+		}
+	})
+
+	t.Run("Test pattern is removed with a dash", func(t *testing.T) {
+		enterValue(EditSearchPattern, "-")
+
+		if stored := edited(); stored.SearchPattern != "" {
+			t.Errorf("Expected no pattern, got: %s", stored.SearchPattern)
+		}
+	})
+
+	t.Run("Test interval is changed and reset", func(t *testing.T) {
+		enterValue(EditInterval, "1d")
+
+		if stored := edited(); stored.Interval != 24*time.Hour {
+			t.Errorf("Expected an interval of a day, got: %s", stored.Interval)
+		}
+
+		enterValue(EditInterval, "5s")
+
+		if stored := edited(); stored.Interval != 24*time.Hour {
+			t.Errorf("Expected a too short interval to be rejected, got: %s", stored.Interval)
+		}
+
+		enterValue(EditInterval, defaultInterval)
+
+		if stored := edited(); stored.Interval != 0 {
+			t.Errorf("Expected the default interval, got: %s", stored.Interval)
+		}
+	})
+
+	t.Run("Test removed subscription ends the edit", func(t *testing.T) {
+		chatContext, _ := chatHandler.UpsertChatContext(chat)
+		chatContext.CurrentAction = Edit
+		chatContext.ActionData = &EditAction{Step: EnterValue, SubscriptionId: uuid.New().String(), Setting: EditSearchPattern}
+
+		ctx := context.WithValue(context.Background(), "chatContext", chatContext)
+		chatHandler.HandleEditActionMessage(ctx, b, &models.Update{Message: &models.Message{Chat: *chat, Text: "other"}})
+
+		if chatContext.CurrentAction != None {
+			t.Errorf("Expected the edit to be canceled, got: %d", chatContext.CurrentAction)
+		}
+
+		if stored := edited(); stored.SearchPattern != "" {
+			t.Errorf("Expected the subscription to be unchanged, got: %s", stored.SearchPattern)
+		}
+	})
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := map[string]time.Duration{
+		defaultInterval: 0,
+		"10s":           10 * time.Second,
+		"5m":            5 * time.Minute,
+		" 1h ":          time.Hour,
+		"1.5d":          36 * time.Hour,
+		"1w":            7 * 24 * time.Hour,
+	}
+
+	for input, expected := range tests {
+		interval, err := parseInterval(input)
+		if err != nil || interval != expected {
+			t.Errorf("Interval of %q is incorrect, got: %s (%v), want: %s", input, interval, err, expected)
+		}
+	}
+
+	for _, input := range []string{"9s", "0", "-5m", "5", "daily", ""} {
+		if interval, err := parseInterval(input); err == nil {
+			t.Errorf("Expected %q to be rejected, got: %s", input, interval)
+		}
+	}
+}
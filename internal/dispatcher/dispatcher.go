@@ -0,0 +1,220 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/utils"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type DispatcherOptions struct {
+	// GlobalRate is the number of messages per second to all chats, GroupRate
+	// per minute to a group and ChatRate per second to a private chat
+	GlobalRate int
+	GroupRate  int
+	ChatRate   int
+	// MaxRetries is the number of retries of transient errors, RetryBase the
+	// first retry delay that is doubled per retry
+	MaxRetries int
+	RetryBase  time.Duration
+}
+
+type Stats struct {
+	Sent        atomic.Int64
+	Retried     atomic.Int64
+	RateLimited atomic.Int64
+	Dropped     atomic.Int64
+}
+
+// Dispatcher sends the outgoing messages within the rate limits of telegram,
+// waits for the retry time of 429 responses and retries transient errors
+type Dispatcher struct {
+	Options *DispatcherOptions
+	Bot     *bot.Bot
+	Stats   Stats
+
+	limits *rateLimiter
+}
+
+func NewDispatcher(b *bot.Bot, options *DispatcherOptions) *Dispatcher {
+	if options.GlobalRate <= 0 {
+		options.GlobalRate = 30
+	}
+
+	if options.GroupRate <= 0 {
+		options.GroupRate = 20
+	}
+
+	if options.ChatRate <= 0 {
+		options.ChatRate = 1
+	}
+
+	if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	}
+
+	if options.RetryBase <= 0 {
+		options.RetryBase = time.Second
+	}
+
+	return &Dispatcher{
+		Options: options,
+		Bot:     b,
+		limits:  newRateLimiter(time.Second/time.Duration(options.GlobalRate), time.Minute/time.Duration(options.GroupRate), time.Second/time.Duration(options.ChatRate)),
+	}
+}
+
+func (dispatcher *Dispatcher) SendMessage(ctx context.Context, params *bot.SendMessageParams) (*models.Message, error) {
+	var message *models.Message
+
+	err := dispatcher.do(ctx, params.ChatID, func(ctx context.Context) error {
+		var err error
+		message, err = dispatcher.Bot.SendMessage(ctx, params)
+
+		if isEntityError(err) && params.ParseMode == models.ParseModeHTML {
+			log.Warn().Err(err).Msgf("Sending message to chat %v as plain text", params.ChatID)
+
+			plain := *params
+			plain.Text = utils.HTMLToText(params.Text)
+			plain.ParseMode = ""
+
+			message, err = dispatcher.Bot.SendMessage(ctx, &plain)
+		}
+
+		return err
+	})
+
+	return message, err
+}
+
+func (dispatcher *Dispatcher) EditMessageText(ctx context.Context, params *bot.EditMessageTextParams) (*models.Message, error) {
+	var message *models.Message
+
+	err := dispatcher.do(ctx, params.ChatID, func(ctx context.Context) error {
+		var err error
+		message, err = dispatcher.Bot.EditMessageText(ctx, params)
+
+		if isEntityError(err) && params.ParseMode == models.ParseModeHTML {
+			log.Warn().Err(err).Msgf("Editing message in chat %v as plain text", params.ChatID)
+
+			plain := *params
+			plain.Text = utils.HTMLToText(params.Text)
+			plain.ParseMode = ""
+
+			message, err = dispatcher.Bot.EditMessageText(ctx, &plain)
+		}
+
+		return err
+	})
+
+	return message, err
+}
+
+// do waits for a slot of the chat and calls send until it succeeds, fails
+// permanently or runs out of retries. Messages that are not sent are dropped.
+func (dispatcher *Dispatcher) do(ctx context.Context, chatId any, send func(ctx context.Context) error) error {
+	chat, group := chatKey(chatId)
+
+	for attempt := 0; ; attempt++ {
+		err := sleep(ctx, time.Until(dispatcher.limits.reserve(chat, group, time.Now())))
+		if err == nil {
+			err = send(ctx)
+		}
+
+		if err == nil {
+			dispatcher.Stats.Sent.Add(1)
+			return nil
+		}
+
+		var tooManyRequests *bot.TooManyRequestsError
+		rateLimited := errors.As(err, &tooManyRequests)
+
+		if rateLimited {
+			dispatcher.Stats.RateLimited.Add(1)
+
+			retryAfter := time.Duration(tooManyRequests.RetryAfter) * time.Second
+			log.Warn().Msgf("Telegram rate limited chat %s, retrying after %s", chat, retryAfter)
+
+			dispatcher.limits.delay(chat, time.Now().Add(retryAfter))
+		}
+
+		if !rateLimited && !isTransient(err) || attempt >= dispatcher.Options.MaxRetries {
+			return dispatcher.drop(chat, attempt, err)
+		}
+
+		if !rateLimited {
+			err = sleep(ctx, dispatcher.Options.RetryBase<<attempt)
+			if err != nil {
+				return dispatcher.drop(chat, attempt, err)
+			}
+		}
+
+		dispatcher.Stats.Retried.Add(1)
+	}
+}
+
+func (dispatcher *Dispatcher) drop(chat string, attempt int, err error) error {
+	dispatcher.Stats.Dropped.Add(1)
+	log.Warn().Err(err).Msgf("Dropping message to chat %s after %d attempts", chat, attempt+1)
+
+	return err
+}
+
+// Metrics describes the sent, retried and dropped messages since the start
+func (dispatcher *Dispatcher) Metrics() string {
+	return fmt.Sprintf("%d messages sent, %d retried, %d rate limited by telegram, %d dropped",
+		dispatcher.Stats.Sent.Load(), dispatcher.Stats.Retried.Load(), dispatcher.Stats.RateLimited.Load(), dispatcher.Stats.Dropped.Load())
+}
+
+// isTransient reports whether a failed request may succeed if it is repeated
+func isTransient(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, bot.ErrorForbidden), errors.Is(err, bot.ErrorBadRequest), errors.Is(err, bot.ErrorUnauthorized),
+		errors.Is(err, bot.ErrorNotFound), errors.Is(err, bot.ErrorConflict), bot.IsMigrateError(err):
+		return false
+	default:
+		return true
+	}
+}
+
+// isEntityError reports whether telegram rejected the formatting of a message,
+// the message may still be sent as plain text
+func isEntityError(err error) bool {
+	return errors.Is(err, bot.ErrorBadRequest) && strings.Contains(err.Error(), "can't parse entities")
+}
+
+// chatKey returns the key of the chat limits, usernames and negative ids are groups or channels
+func chatKey(chatId any) (string, bool) {
+	switch id := chatId.(type) {
+	case int64:
+		return fmt.Sprint(id), id < 0
+	case int:
+		return fmt.Sprint(id), id < 0
+	default:
+		return fmt.Sprint(id), true
+	}
+}
+
+func sleep(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,150 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	limiter := newRateLimiter(100*time.Millisecond, 3*time.Second, time.Second)
+	now := time.Now()
+
+	if at := limiter.reserve("1", false, now); !at.Equal(now) {
+		t.Errorf("Expected the first message to be sent immediately, got %s", at.Sub(now))
+	}
+
+	if at := limiter.reserve("2", false, now); at.Sub(now) != 100*time.Millisecond {
+		t.Errorf("Expected the global spacing, got %s", at.Sub(now))
+	}
+
+	if at := limiter.reserve("1", false, now); at.Sub(now) != time.Second {
+		t.Errorf("Expected the spacing of private chats, got %s", at.Sub(now))
+	}
+
+	if at := limiter.reserve("-1", true, now); at.Sub(now) != 200*time.Millisecond {
+		t.Errorf("Expected a waiting chat not to delay other chats, got %s", at.Sub(now))
+	}
+
+	if at := limiter.reserve("-1", true, now); at.Sub(now) != 3200*time.Millisecond {
+		t.Errorf("Expected the spacing of groups, got %s", at.Sub(now))
+	}
+
+	limiter.delay("2", now.Add(time.Minute))
+	if at := limiter.reserve("2", false, now); at.Sub(now) != time.Minute {
+		t.Errorf("Expected the retry time of telegram, got %s", at.Sub(now))
+	}
+}
+
+func TestRetries(t *testing.T) {
+	newTestDispatcher := func() *Dispatcher {
+		return NewDispatcher(nil, &DispatcherOptions{GlobalRate: 1000, ChatRate: 1000, MaxRetries: 2, RetryBase: time.Millisecond})
+	}
+
+	t.Run("Test transient errors are retried", func(t *testing.T) {
+		dispatcher := newTestDispatcher()
+
+		attempts := 0
+		err := dispatcher.do(context.Background(), int64(1), func(ctx context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return fmt.Errorf("connection reset")
+			}
+
+			return nil
+		})
+
+		if err != nil || attempts != 3 || dispatcher.Stats.Retried.Load() != 2 || dispatcher.Stats.Sent.Load() != 1 {
+			t.Errorf("Expected the message to be sent on the third attempt, got %d attempts (%v)", attempts, err)
+		}
+	})
+
+	t.Run("Test rate limited messages wait for the retry time", func(t *testing.T) {
+		dispatcher := newTestDispatcher()
+
+		attempts := 0
+		err := dispatcher.do(context.Background(), int64(1), func(ctx context.Context) error {
+			attempts++
+			if attempts == 1 {
+				return &bot.TooManyRequestsError{Message: "too many requests", RetryAfter: 0}
+			}
+
+			return nil
+		})
+
+		if err != nil || attempts != 2 || dispatcher.Stats.RateLimited.Load() != 1 {
+			t.Errorf("Expected the message to be sent after the retry time, got %d attempts (%v)", attempts, err)
+		}
+	})
+
+	t.Run("Test permanent errors and exhausted retries are dropped", func(t *testing.T) {
+		dispatcher := newTestDispatcher()
+
+		attempts := 0
+		err := dispatcher.do(context.Background(), int64(1), func(ctx context.Context) error {
+			attempts++
+			return fmt.Errorf("%w, chat not found", bot.ErrorBadRequest)
+		})
+
+		if err == nil || attempts != 1 {
+			t.Errorf("Expected permanent errors not to be retried, got %d attempts", attempts)
+		}
+
+		attempts = 0
+		err = dispatcher.do(context.Background(), int64(1), func(ctx context.Context) error {
+			attempts++
+			return fmt.Errorf("bad gateway")
+		})
+
+		if err == nil || attempts != 3 {
+			t.Errorf("Expected 3 attempts, got %d", attempts)
+		}
+
+		if dispatcher.Stats.Dropped.Load() != 2 {
+			t.Errorf("Expected 2 dropped messages, got %d", dispatcher.Stats.Dropped.Load())
+		}
+	})
+}
+
+func TestPlainTextFallback(t *testing.T) {
+	var texts []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseMultipartForm(1 << 20)
+		texts = append(texts, r.FormValue("text"))
+
+		if r.FormValue("parse_mode") == string(models.ParseModeHTML) {
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities: Unsupported start tag \"img\" at byte offset 3"}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":7}}`))
+	}))
+	defer server.Close()
+
+	b, err := bot.New("token", bot.WithServerURL(server.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := NewDispatcher(b, &DispatcherOptions{GlobalRate: 1000, ChatRate: 1000})
+
+	message, err := dispatcher.SendMessage(context.Background(), &bot.SendMessageParams{
+		ChatID:    int64(1),
+		Text:      "<b>Title &amp; more</b>\n<img>",
+		ParseMode: models.ParseModeHTML,
+	})
+
+	if err != nil || message.ID != 7 {
+		t.Fatalf("Expected the message to be sent as plain text, got %v (%v)", message, err)
+	}
+
+	if len(texts) != 2 || texts[1] != "Title & more\n" {
+		t.Errorf("Expected a second request with the plain text, got %q", texts)
+	}
+}
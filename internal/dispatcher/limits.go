@@ -0,0 +1,90 @@
+package dispatcher
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// rateLimiter hands out send times that keep the global and per chat spacing,
+// messages are queued behind the reservations made before them. A chat that
+// has to wait does not delay the messages to other chats.
+type rateLimiter struct {
+	global  time.Duration
+	group   time.Duration
+	private time.Duration
+
+	// reserved holds the sorted send times of the global spacing
+	reserved []time.Time
+	chats    map[string]time.Time
+	lock     sync.Mutex
+}
+
+func newRateLimiter(global, group, private time.Duration) *rateLimiter {
+	return &rateLimiter{
+		global:  global,
+		group:   group,
+		private: private,
+		chats:   make(map[string]time.Time),
+	}
+}
+
+// reserve returns the time a message to the chat may be sent at and claims it
+func (limiter *rateLimiter) reserve(chat string, group bool, now time.Time) time.Time {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	at := now
+	if slot, ok := limiter.chats[chat]; ok && slot.After(at) {
+		at = slot
+	}
+
+	limiter.reserved = slices.DeleteFunc(limiter.reserved, func(reserved time.Time) bool {
+		return !reserved.Add(limiter.global).After(now)
+	})
+
+	// take the first gap of the global spacing at or after the slot of the chat
+	i := 0
+	for ; i < len(limiter.reserved); i++ {
+		reserved := limiter.reserved[i]
+		if !reserved.Add(limiter.global).After(at) {
+			continue
+		}
+
+		if !at.Add(limiter.global).After(reserved) {
+			break
+		}
+
+		at = reserved.Add(limiter.global)
+	}
+
+	limiter.reserved = slices.Insert(limiter.reserved, i, at)
+
+	spacing := limiter.private
+	if group {
+		spacing = limiter.group
+	}
+
+	limiter.chats[chat] = at.Add(spacing)
+
+	// forget chats whose slots have passed to keep the map small
+	if len(limiter.chats) > 1024 {
+		for key, value := range limiter.chats {
+			if !value.After(now) {
+				delete(limiter.chats, key)
+			}
+		}
+	}
+
+	return at
+}
+
+// delay moves the next slot of the chat behind the retry time requested by telegram
+func (limiter *rateLimiter) delay(chat string, until time.Time) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	if slot, ok := limiter.chats[chat]; !ok || until.After(slot) {
+		limiter.chats[chat] = until
+	}
+}
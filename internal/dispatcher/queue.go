@@ -0,0 +1,466 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/storage"
+	"rss-telegram/internal/utils"
+	"sync"
+	"time"
+)
+
+// queueBatch is the number of queued messages read at once, queueBuffer the
+// number of messages that are held by the chat workers at most
+const (
+	queueBatch  = 100
+	queueBuffer = 1000
+)
+
+// chunkSize is the length chunked messages are split at
+const chunkSize = 4000
+
+// QueuedMessage is kept in the storage until it was sent or moved to the dead letters
+type QueuedMessage struct {
+	Id           string                   `json:"-"`
+	ChatId       int64                    `json:"chatId"`
+	Subscription *storage.SubscriptionKey `json:"subscription,omitempty"`
+	Text         string                   `json:"text"`
+	ParseMode    models.ParseMode         `json:"parseMode,omitempty"`
+	// Chunked messages are split at line breaks if they are too long, every
+	// chunk is queued as a message of its own
+	Chunked bool `json:"chunked,omitempty"`
+	// DisableNotification sends the message without a sound, it is set during quiet hours
+	DisableNotification bool `json:"disableNotification,omitempty"`
+	// ItemId and Fingerprint are set for items whose delivery is remembered
+	ItemId      string `json:"itemId,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// EditMessageId replaces the text of a sent message instead of sending a
+	// new one, ReplyTo sends the message as a reply
+	EditMessageId int `json:"editMessageId,omitempty"`
+	ReplyTo       int `json:"replyTo,omitempty"`
+
+	QueuedAt time.Time `json:"queuedAt"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type QueueOptions struct {
+	Store storage.QueueStore
+	// MaxAttempts is the number of times a message is tried before it is moved
+	// to the dead letters, Interval the time between the attempts. The later
+	// messages to the chat wait until the failed message was sent or given up.
+	MaxAttempts int
+	Interval    time.Duration
+	// Accept is asked before a message is sent, rejected messages are discarded
+	Accept func(message *QueuedMessage) bool
+	// OnSent is called after a message was sent or edited, chunked messages
+	// have no sent message
+	OnSent func(message *QueuedMessage, sent *models.Message)
+}
+
+// Queue persists outgoing messages and sends them through the dispatcher,
+// messages that fail permanently are kept as dead letters until they are replayed
+type Queue struct {
+	Options    *QueueOptions
+	Dispatcher *Dispatcher
+
+	wake chan struct{}
+	once sync.Once
+
+	// cursor is the id of the last message handed to a worker, the messages
+	// stay in the queue until their worker sent them
+	cursor   string
+	chats    map[int64]*chatWorker
+	buffered int
+	lock     sync.Mutex
+	workers  sync.WaitGroup
+}
+
+// chatWorker sends the messages to one chat in order, a slow chat only
+// delays its own messages
+type chatWorker struct {
+	chatId   int64
+	messages []*QueuedMessage
+}
+
+func NewQueue(dispatcher *Dispatcher, options *QueueOptions) *Queue {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 5
+	}
+
+	if options.Interval <= 0 {
+		options.Interval = time.Minute
+	}
+
+	return &Queue{
+		Options:    options,
+		Dispatcher: dispatcher,
+		wake:       make(chan struct{}, 1),
+		chats:      make(map[int64]*chatWorker),
+	}
+}
+
+// Enqueue stores the messages, they are sent in order per chat
+func (queue *Queue) Enqueue(messages []*QueuedMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	messages = splitChunked(messages)
+
+	for _, message := range messages {
+		if message.QueuedAt.IsZero() {
+			message.QueuedAt = time.Now()
+		}
+	}
+
+	err := queue.store(storage.DeliveryQueue, messages)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Run starts sending the queued messages once, messages left from before a
+// restart are sent first
+func (queue *Queue) Run(ctx context.Context) {
+	queue.once.Do(func() {
+		go queue.loop(ctx)
+	})
+}
+
+func (queue *Queue) loop(ctx context.Context) {
+	ticker := time.NewTicker(queue.Options.Interval)
+	defer ticker.Stop()
+
+	for {
+		for queue.process(ctx) {
+		}
+
+		select {
+		case <-queue.wake:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// process hands the messages added since the last call to the workers of
+// their chats and reports whether more messages can be read immediately
+func (queue *Queue) process(ctx context.Context) bool {
+	queue.lock.Lock()
+	count := min(queueBatch, queueBuffer-queue.buffered)
+	cursor := queue.cursor
+	queue.lock.Unlock()
+
+	if count <= 0 {
+		return false
+	}
+
+	entries, err := queue.Options.Store.PeekQueue(storage.DeliveryQueue, cursor, count)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed reading the delivery queue")
+		return false
+	}
+
+	if len(entries) == 0 {
+		return false
+	}
+
+	messages := queue.decode(storage.DeliveryQueue, entries)
+
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	queue.cursor = entries[len(entries)-1].Id
+
+	for _, message := range messages {
+		worker, ok := queue.chats[message.ChatId]
+		if !ok {
+			worker = &chatWorker{chatId: message.ChatId}
+			queue.chats[message.ChatId] = worker
+
+			queue.workers.Add(1)
+			go queue.work(ctx, worker)
+		}
+
+		worker.messages = append(worker.messages, message)
+		queue.buffered++
+	}
+
+	return len(entries) == count && queue.buffered < queueBuffer && ctx.Err() == nil
+}
+
+// work sends the messages of a chat until none are left, a message that
+// failed is retried before the next message to the chat is sent
+func (queue *Queue) work(ctx context.Context, worker *chatWorker) {
+	defer queue.workers.Done()
+
+	for {
+		queue.lock.Lock()
+		if len(worker.messages) == 0 {
+			delete(queue.chats, worker.chatId)
+			queue.lock.Unlock()
+			return
+		}
+		message := worker.messages[0]
+		queue.lock.Unlock()
+
+		err := queue.send(ctx, message)
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			message.Attempts++
+			message.Error = err.Error()
+
+			if isTransient(err) && message.Attempts < queue.Options.MaxAttempts {
+				log.Debug().Err(err).Msgf("Retrying message to chat %d after attempt %d", message.ChatId, message.Attempts)
+
+				if sleep(ctx, queue.Options.Interval) != nil {
+					return
+				}
+
+				continue
+			}
+
+			log.Warn().Err(err).Msgf("Moving message to chat %d to the dead letters after %d attempts", message.ChatId, message.Attempts)
+
+			// the dead letter is stored before the message is removed, a crash
+			// in between repeats it instead of losing it
+			err = queue.store(storage.DeadLetterQueue, []*QueuedMessage{message})
+		}
+
+		if err == nil {
+			err = queue.Options.Store.Dequeue(storage.DeliveryQueue, []string{message.Id})
+		}
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed updating the delivery queue of chat %d", message.ChatId)
+
+			if sleep(ctx, queue.Options.Interval) != nil {
+				return
+			}
+
+			continue
+		}
+
+		queue.lock.Lock()
+		full := queue.buffered >= queueBuffer
+		worker.messages = worker.messages[1:]
+		queue.buffered--
+		queue.lock.Unlock()
+
+		// the queue stops reading while the workers hold too many messages
+		if full {
+			select {
+			case queue.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (queue *Queue) send(ctx context.Context, message *QueuedMessage) error {
+	if queue.Options.Accept != nil && !queue.Options.Accept(message) {
+		log.Debug().Msgf("Discarding queued message to chat %d", message.ChatId)
+		return nil
+	}
+
+	// chunked messages are split when they are queued, only messages queued
+	// before that are sent in chunks here
+	if message.Chunked {
+		var sender utils.MessageSender = queue.Dispatcher
+		if message.DisableNotification {
+			sender = &silentSender{queue.Dispatcher}
+		}
+
+		err := utils.SendChunkedMessage(message.Text, ctx, sender, message.ChatId, chunkSize, nil)
+		if err == nil && queue.Options.OnSent != nil {
+			queue.Options.OnSent(message, nil)
+		}
+
+		return err
+	}
+
+	var sent *models.Message
+	var err error
+
+	if message.EditMessageId != 0 {
+		sent, err = queue.Dispatcher.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:    message.ChatId,
+			MessageID: message.EditMessageId,
+			Text:      message.Text,
+			ParseMode: message.ParseMode,
+		})
+	} else {
+		params := &bot.SendMessageParams{
+			ChatID:              message.ChatId,
+			Text:                message.Text,
+			ParseMode:           message.ParseMode,
+			DisableNotification: message.DisableNotification,
+		}
+
+		if message.ReplyTo != 0 {
+			params.ReplyParameters = &models.ReplyParameters{MessageID: message.ReplyTo, AllowSendingWithoutReply: true}
+		}
+
+		sent, err = queue.Dispatcher.SendMessage(ctx, params)
+	}
+	if err == nil && queue.Options.OnSent != nil {
+		queue.Options.OnSent(message, sent)
+	}
+
+	return err
+}
+
+// splitChunked replaces every chunked message by a message per chunk, a
+// failed chunk is retried without sending the chunks before it again
+func splitChunked(messages []*QueuedMessage) []*QueuedMessage {
+	output := make([]*QueuedMessage, 0, len(messages))
+	for _, message := range messages {
+		if !message.Chunked {
+			output = append(output, message)
+			continue
+		}
+
+		for i, chunk := range utils.SplitMessage(message.Text, chunkSize) {
+			part := *message
+			part.Text = chunk
+			part.Chunked = false
+
+			// the delivery of an item is the first message
+			if i > 0 {
+				part.ItemId = ""
+				part.Fingerprint = ""
+			}
+
+			output = append(output, &part)
+		}
+	}
+
+	return output
+}
+
+// DeadLetters returns up to count dead letters and the number of all dead letters
+func (queue *Queue) DeadLetters(count int) ([]*QueuedMessage, int64, error) {
+	messages, err := queue.peek(storage.DeadLetterQueue, count)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	length, err := queue.Options.Store.QueueLength(storage.DeadLetterQueue)
+
+	return messages, length, err
+}
+
+// Replay moves every dead letter back to the delivery queue with reset attempts
+func (queue *Queue) Replay() (int, error) {
+	replayed := 0
+
+	for {
+		messages, err := queue.peek(storage.DeadLetterQueue, queueBatch)
+		if err != nil || len(messages) == 0 {
+			return replayed, err
+		}
+
+		ids := make([]string, len(messages))
+		for i, message := range messages {
+			ids[i] = message.Id
+			message.Attempts = 0
+			message.Error = ""
+		}
+
+		err = queue.Enqueue(messages)
+		if err == nil {
+			err = queue.Options.Store.Dequeue(storage.DeadLetterQueue, ids)
+		}
+		if err != nil {
+			return replayed, err
+		}
+
+		replayed += len(messages)
+	}
+}
+
+// Len returns the number of queued messages that were not sent yet
+func (queue *Queue) Len() int64 {
+	length, err := queue.Options.Store.QueueLength(storage.DeliveryQueue)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed reading the length of the delivery queue")
+	}
+
+	return length
+}
+
+func (queue *Queue) peek(name storage.Queue, count int) ([]*QueuedMessage, error) {
+	entries, err := queue.Options.Store.PeekQueue(name, "", count)
+	if err != nil {
+		return nil, err
+	}
+
+	return queue.decode(name, entries), nil
+}
+
+// decode reads the messages of the entries, unreadable entries are removed
+func (queue *Queue) decode(name storage.Queue, entries []*storage.QueuedEntry) []*QueuedMessage {
+	output := make([]*QueuedMessage, 0, len(entries))
+	for _, entry := range entries {
+		var message QueuedMessage
+		err := json.Unmarshal(entry.Data, &message)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Removing unreadable entry %s of the %s queue", entry.Id, name)
+
+			err = queue.Options.Store.Dequeue(name, []string{entry.Id})
+			if err != nil {
+				log.Warn().Err(err).Msgf("Failed removing entry %s of the %s queue", entry.Id, name)
+			}
+
+			continue
+		}
+
+		message.Id = entry.Id
+		output = append(output, &message)
+	}
+
+	return output
+}
+
+func (queue *Queue) store(name storage.Queue, messages []*QueuedMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	data := make([][]byte, len(messages))
+	for i, message := range messages {
+		messageBytes, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		data[i] = messageBytes
+	}
+
+	return queue.Options.Store.Enqueue(name, data)
+}
+
+// silentSender sends every chunk of a message without notification
+type silentSender struct {
+	sender utils.MessageSender
+}
+
+func (sender *silentSender) SendMessage(ctx context.Context, params *bot.SendMessageParams) (*models.Message, error) {
+	params.DisableNotification = true
+
+	return sender.sender.SendMessage(ctx, params)
+}
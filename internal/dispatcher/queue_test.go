@@ -0,0 +1,185 @@
+package dispatcher
+
+import (
+	"context"
+	"github.com/go-telegram/bot"
+	"net/http"
+	"net/http/httptest"
+	"rss-telegram/internal/storage"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	store := storage.NewMemoryStore()
+
+	queue := NewQueue(NewDispatcher(nil, &DispatcherOptions{}), &QueueOptions{
+		Store: store,
+		Accept: func(message *QueuedMessage) bool {
+			return false
+		},
+	})
+
+	t.Run("Test discarded messages are removed", func(t *testing.T) {
+		err := queue.Enqueue([]*QueuedMessage{{ChatId: 1, Text: "a"}, {ChatId: 2, Text: "b"}})
+		if err != nil {
+			t.Fatalf("Could not enqueue messages: %v", err)
+		}
+
+		if queue.Len() != 2 {
+			t.Fatalf("Expected 2 queued messages, got %d", queue.Len())
+		}
+
+		queue.process(context.Background())
+		queue.workers.Wait()
+
+		if queue.Len() != 0 {
+			t.Errorf("Expected the queue to be empty, got %d", queue.Len())
+		}
+	})
+
+	t.Run("Test dead letters are replayed", func(t *testing.T) {
+		err := queue.store(storage.DeadLetterQueue, []*QueuedMessage{{ChatId: 1, Text: "a", Attempts: 5, Error: "forbidden"}})
+		if err != nil {
+			t.Fatalf("Could not store dead letter: %v", err)
+		}
+
+		deadLetters, total, err := queue.DeadLetters(10)
+		if err != nil || total != 1 || len(deadLetters) != 1 || deadLetters[0].Error != "forbidden" {
+			t.Fatalf("Dead letters are incorrect, got: %v, %d (%v)", deadLetters, total, err)
+		}
+
+		replayed, err := queue.Replay()
+		if err != nil || replayed != 1 {
+			t.Fatalf("Expected 1 replayed message, got %d (%v)", replayed, err)
+		}
+
+		messages, _ := queue.peek(storage.DeliveryQueue, 10)
+		if len(messages) != 1 || messages[0].Attempts != 0 || messages[0].Error != "" {
+			t.Errorf("Expected the replayed message with reset attempts, got: %v", messages)
+		}
+
+		_, total, _ = queue.DeadLetters(10)
+		if total != 0 {
+			t.Errorf("Expected no dead letters after replay, got %d", total)
+		}
+	})
+}
+
+func TestQueueDelivery(t *testing.T) {
+	var lock sync.Mutex
+	var texts []string
+	failed := make(map[string]bool)
+	release := make(chan struct{})
+
+	// the first request of every text ending with "!" fails, texts ending with
+	// "?" wait until they are released
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseMultipartForm(1 << 22)
+		text := r.FormValue("text")
+
+		if strings.HasSuffix(text, "?") {
+			<-release
+		}
+
+		lock.Lock()
+		texts = append(texts, text)
+		fail := strings.HasSuffix(text, "!") && !failed[text]
+		failed[text] = true
+		lock.Unlock()
+
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":500,"description":"Internal Server Error"}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	}))
+	defer server.Close()
+
+	b, err := bot.New("token", bot.WithServerURL(server.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newQueue := func() *Queue {
+		dispatcher := NewDispatcher(b, &DispatcherOptions{GlobalRate: 1000, GroupRate: 1000, ChatRate: 1000})
+
+		return NewQueue(dispatcher, &QueueOptions{Store: storage.NewMemoryStore(), Interval: 10 * time.Millisecond})
+	}
+
+	sent := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+
+		output := slices.Clone(texts)
+		texts = nil
+
+		return output
+	}
+
+	t.Run("Test messages to a chat stay in order after a failure", func(t *testing.T) {
+		queue := newQueue()
+
+		_ = queue.Enqueue([]*QueuedMessage{{ChatId: 1, Text: "a!"}, {ChatId: 1, Text: "b"}, {ChatId: 1, Text: "c"}})
+
+		queue.process(context.Background())
+		queue.workers.Wait()
+
+		if output := sent(); !slices.Equal(output, []string{"a!", "a!", "b", "c"}) {
+			t.Errorf("Expected the failed message to be retried before the next messages, got %q", output)
+		}
+
+		if queue.Len() != 0 {
+			t.Errorf("Expected the queue to be empty, got %d", queue.Len())
+		}
+	})
+
+	t.Run("Test a slow chat does not block other chats", func(t *testing.T) {
+		queue := newQueue()
+
+		_ = queue.Enqueue([]*QueuedMessage{{ChatId: 1, Text: "slow?"}, {ChatId: 2, Text: "fast"}})
+
+		queue.process(context.Background())
+
+		deadline := time.Now().Add(time.Second)
+		for queue.Len() > 1 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		if output := sent(); !slices.Equal(output, []string{"fast"}) {
+			t.Errorf("Expected the message to the other chat to be sent, got %q", output)
+		}
+
+		close(release)
+		queue.workers.Wait()
+
+		if output := sent(); !slices.Equal(output, []string{"slow?"}) {
+			t.Errorf("Expected the slow message to be sent after it was released, got %q", output)
+		}
+	})
+
+	t.Run("Test sent chunks are not sent again", func(t *testing.T) {
+		queue := newQueue()
+
+		first := strings.Repeat("a", 3000)
+		second := strings.Repeat("b", 3000) + "!"
+
+		_ = queue.Enqueue([]*QueuedMessage{{ChatId: 1, Text: first + "\n" + second, Chunked: true}})
+
+		if queue.Len() != 2 {
+			t.Fatalf("Expected a queued message per chunk, got %d", queue.Len())
+		}
+
+		queue.process(context.Background())
+		queue.workers.Wait()
+
+		if output := sent(); !slices.Equal(output, []string{first, second, second}) {
+			t.Errorf("Expected only the failed chunk to be sent again, got %d messages", len(output))
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package fetcher
+
+import (
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusError is returned for non 2xx responses, it unwraps to gofeed.HTTPError
+type StatusError struct {
+	gofeed.HTTPError
+	RetryAfter string
+}
+
+func (statusError *StatusError) Error() string {
+	return fmt.Sprintf("http error: %s", statusError.Status)
+}
+
+func (statusError *StatusError) Unwrap() error {
+	return statusError.HTTPError
+}
+
+// backoffDelay doubles the base delay for every consecutive failure up to max
+// and randomizes the upper half to spread retries of feeds failing together
+func backoffDelay(base time.Duration, max time.Duration, failures int) time.Duration {
+	delay := max
+	if failures < 32 {
+		delay = min(max, base<<(failures-1))
+	}
+
+	if delay <= 0 {
+		delay = max
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// parseRetryAfter supports both formats of the Retry-After header, delay
+// seconds and http dates. The delay is capped at limit so a huge value can not
+// overflow or park the feed forever.
+func parseRetryAfter(value string, now time.Time, limit time.Duration) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		if seconds > int64(limit/time.Second) {
+			return limit, true
+		}
+
+		return max(0, time.Duration(seconds)*time.Second), true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return min(limit, max(0, date.Sub(now))), true
+}
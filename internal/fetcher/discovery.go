@@ -0,0 +1,221 @@
+package fetcher
+
+import (
+	"context"
+	"golang.org/x/net/html"
+	"io"
+	"net/http"
+	"net/url"
+	"rss-telegram/internal/utils"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDiscoveredFeeds limits the number of returned feeds, maxProbedFeeds the
+// number of candidates that are requested and discoveryTimeout the time the
+// page and all candidates may take together
+const (
+	maxDiscoveredFeeds = 10
+	maxProbedFeeds     = 20
+	discoveryTimeout   = 15 * time.Second
+)
+
+var feedTypes = []string{"application/rss+xml", "application/atom+xml", "application/feed+json"}
+
+// commonFeedPaths are tried if a page does not link any feed
+var commonFeedPaths = []string{"/feed", "/rss.xml", "/atom.xml"}
+
+type DiscoveredFeed struct {
+	URL   *url.URL `json:"url"`
+	Title string   `json:"title"`
+}
+
+// Discover finds the feeds of a website, either linked in the page with
+// <link rel="alternate"> or at one of the common feed paths. Every returned
+// feed was requested and could be parsed.
+func (fetcher *Fetcher) Discover(ctx context.Context, pageUrl string) ([]*DiscoveredFeed, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+
+	candidates, baseUrl, err := fetcher.findFeedLinks(ctx, pageUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		for _, path := range commonFeedPaths {
+			candidates = append(candidates, &DiscoveredFeed{URL: baseUrl.ResolveReference(&url.URL{Path: path})})
+		}
+	}
+
+	// pages may link hundreds of feeds, only the first distinct ones are requested
+	var distinct []*DiscoveredFeed
+	var seen []string
+
+	for _, candidate := range candidates {
+		canonical := utils.CanonicalURL(candidate.URL)
+		if slices.Contains(seen, canonical.String()) {
+			continue
+		}
+
+		seen = append(seen, canonical.String())
+		distinct = append(distinct, &DiscoveredFeed{URL: canonical, Title: candidate.Title})
+
+		if len(distinct) == maxProbedFeeds {
+			break
+		}
+	}
+
+	// the candidates are requested at once, the remaining requests are
+	// canceled as soon as enough feeds were found
+	probed := make([]*DiscoveredFeed, len(distinct))
+	found := 0
+
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+
+	for i, candidate := range distinct {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			feed, finalUrl, err := fetcher.Probe(ctx, candidate.URL.String())
+			if err != nil {
+				return
+			}
+
+			title := candidate.Title
+			if feed.Title != "" {
+				title = feed.Title
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if found == maxDiscoveredFeeds {
+				return
+			}
+
+			probed[i] = &DiscoveredFeed{
+				URL:   utils.UpgradeScheme(candidate.URL, finalUrl),
+				Title: title,
+			}
+
+			found++
+			if found == maxDiscoveredFeeds {
+				cancel()
+			}
+		}()
+	}
+
+	wait.Wait()
+
+	var output []*DiscoveredFeed
+	for _, feed := range probed {
+		if feed != nil {
+			output = append(output, feed)
+		}
+	}
+
+	return output, nil
+}
+
+// findFeedLinks requests the page and returns the linked feeds together with
+// the url the page was served from
+func (fetcher *Fetcher) findFeedLinks(ctx context.Context, pageUrl string) ([]*DiscoveredFeed, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageUrl, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Set("User-Agent", fetcher.Options.UserAgent)
+
+	err = fetcher.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fetcher.release()
+
+	resp, err := fetcher.Options.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	baseUrl := resp.Request.URL
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, baseUrl, nil
+	}
+
+	links, err := parseFeedLinks(io.LimitReader(resp.Body, 5<<20), baseUrl)
+
+	return links, baseUrl, err
+}
+
+// parseFeedLinks returns the alternate feed links of a html document, relative
+// links are resolved against the base url or the <base> element
+func parseFeedLinks(reader io.Reader, baseUrl *url.URL) ([]*DiscoveredFeed, error) {
+	tokenizer := html.NewTokenizer(reader)
+
+	var output []*DiscoveredFeed
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return output, nil
+			}
+
+			return output, tokenizer.Err()
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "head" {
+				return output, nil
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttributes := tokenizer.TagName()
+			if !hasAttributes {
+				continue
+			}
+
+			attributes := make(map[string]string)
+			for {
+				key, value, more := tokenizer.TagAttr()
+				attributes[strings.ToLower(string(key))] = string(value)
+
+				if !more {
+					break
+				}
+			}
+
+			switch string(name) {
+			case "base":
+				if href, err := url.Parse(attributes["href"]); err == nil && attributes["href"] != "" {
+					baseUrl = baseUrl.ResolveReference(href)
+				}
+			case "link":
+				if !slices.Contains(strings.Fields(strings.ToLower(attributes["rel"])), "alternate") {
+					continue
+				}
+
+				if !slices.Contains(feedTypes, strings.ToLower(strings.TrimSpace(attributes["type"]))) {
+					continue
+				}
+
+				href, err := url.Parse(strings.TrimSpace(attributes["href"]))
+				if err != nil || attributes["href"] == "" {
+					continue
+				}
+
+				output = append(output, &DiscoveredFeed{
+					URL:   baseUrl.ResolveReference(href),
+					Title: strings.TrimSpace(attributes["title"]),
+				})
+			}
+		}
+	}
+}
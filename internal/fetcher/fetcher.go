@@ -0,0 +1,290 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/mmcdole/gofeed"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"rss-telegram/internal/storage"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNotModified = errors.New("feed not modified")
+	ErrBackoff     = errors.New("feed fetch is backing off")
+	ErrSuspended   = errors.New("feed is suspended")
+)
+
+type FeedState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+
+	ConsecutiveFailures int       `json:"consecutiveFailures,omitempty"`
+	NextFetch           time.Time `json:"nextFetch,omitempty"`
+
+	Health       Health    `json:"health,omitempty"`
+	LastError    string    `json:"lastError,omitempty"`
+	LastSuccess  time.Time `json:"lastSuccess,omitempty"`
+	FailingSince time.Time `json:"failingSince,omitempty"`
+}
+
+type FetcherOptions struct {
+	Store     storage.FeedStore
+	Client    *http.Client
+	UserAgent string
+
+	// WaitTimeout is used on 429 and 503 responses without a valid Retry-After header
+	WaitTimeout time.Duration
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+
+	// DeadAfter is the time a failing feed needs to fail before it is dead,
+	// SuspendAfter the additional time until it is no longer fetched
+	DeadAfter    time.Duration
+	SuspendAfter time.Duration
+	// OnHealthChange is called once per transition, the transition is
+	// repeated by the next fetch if it returns an error
+	OnHealthChange func(feedUrl string, previous Health, state *FeedState) error
+	// OnRedirect is called with the new canonical url of a permanently moved feed
+	OnRedirect func(feedUrl string, movedUrl string)
+
+	// Concurrency limits the number of requests running at the same time,
+	// HostSpacing is the minimum time between two requests to the same host
+	Concurrency int
+	HostSpacing time.Duration
+}
+
+type Fetcher struct {
+	Options *FetcherOptions
+	Stats   Stats
+
+	slots chan struct{}
+	hosts *hostLimiter
+}
+
+type Stats struct {
+	Fetches     atomic.Int64
+	NotModified atomic.Int64
+	InFlight    atomic.Int64
+	Delayed     atomic.Int64
+}
+
+func NewFetcher(options *FetcherOptions) *Fetcher {
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	if options.UserAgent == "" {
+		options.UserAgent = "rss-telegram"
+	}
+
+	if options.BackoffBase <= 0 {
+		options.BackoffBase = time.Minute
+	}
+
+	if options.BackoffMax <= 0 {
+		options.BackoffMax = 6 * time.Hour
+	}
+
+	if options.DeadAfter <= 0 {
+		options.DeadAfter = 3 * 24 * time.Hour
+	}
+
+	if options.SuspendAfter <= 0 {
+		options.SuspendAfter = 14 * 24 * time.Hour
+	}
+
+	fetcher := &Fetcher{
+		Options: options,
+		hosts:   newHostLimiter(options.HostSpacing),
+	}
+
+	if options.Concurrency > 0 {
+		fetcher.slots = make(chan struct{}, options.Concurrency)
+	}
+
+	return fetcher
+}
+
+// Fetch requests the feed conditionally and returns ErrNotModified if the
+// server reports that the feed did not change since the last fetch. While a
+// failing feed is backing off no request is made and ErrBackoff is returned.
+func (fetcher *Fetcher) Fetch(ctx context.Context, feedUrl string) (*gofeed.Feed, error) {
+	state, err := fetcher.GetState(feedUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Health == Suspended {
+		return nil, ErrSuspended
+	}
+
+	if time.Now().Before(state.NextFetch) {
+		return nil, ErrBackoff
+	}
+
+	previousHealth := state.Health
+
+	feed, resp, fetchErr := fetcher.fetch(ctx, feedUrl, state)
+	if fetchErr != nil && !errors.Is(fetchErr, ErrNotModified) {
+		fetcher.backoff(feedUrl, state, fetchErr)
+		fetcher.recordFailure(state, fetchErr, time.Now())
+	} else {
+		fetcher.recordSuccess(state, time.Now())
+	}
+
+	if state.Health != previousHealth && fetcher.Options.OnHealthChange != nil {
+		err = fetcher.Options.OnHealthChange(feedUrl, previousHealth, state)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed announcing health of %s, keeping %s", feedUrl, previousHealth)
+
+			state.Health = previousHealth
+		}
+	}
+
+	err = fetcher.SaveState(feedUrl, state)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed saving fetch state of %s", feedUrl)
+	}
+
+	if resp != nil {
+		if moved := permanentRedirect(resp); moved != nil {
+			fetcher.handleRedirect(feedUrl, moved, state)
+		}
+	}
+
+	return feed, fetchErr
+}
+
+// fetch returns the parsed feed together with the closed response, which
+// holds the chain of redirected requests
+func (fetcher *Fetcher) fetch(ctx context.Context, feedUrl string, state *FeedState) (*gofeed.Feed, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedUrl, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Set("User-Agent", fetcher.Options.UserAgent)
+
+	if state.ETag != "" {
+		req.Header.Set("If-None-Match", state.ETag)
+	}
+
+	if state.LastModified != "" {
+		req.Header.Set("If-Modified-Since", state.LastModified)
+	}
+
+	err = fetcher.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fetcher.release()
+
+	fetcher.Stats.Fetches.Add(1)
+
+	resp, err := fetcher.Options.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		saved := fetcher.Stats.NotModified.Add(1)
+
+		log.Trace().Msgf("Feed %s was not modified, %d of %d fetches saved", feedUrl, saved, fetcher.Stats.Fetches.Load())
+
+		return nil, resp, ErrNotModified
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, &StatusError{
+			HTTPError: gofeed.HTTPError{
+				StatusCode: resp.StatusCode,
+				Status:     resp.Status,
+			},
+			RetryAfter: resp.Header.Get("Retry-After"),
+		}
+	}
+
+	feed, err := gofeed.NewParser().Parse(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state.ETag = resp.Header.Get("ETag")
+	state.LastModified = resp.Header.Get("Last-Modified")
+
+	return feed, resp, nil
+}
+
+// backoff delays the next fetch, either as requested by the server or exponentially with jitter
+func (fetcher *Fetcher) backoff(feedUrl string, state *FeedState, fetchErr error) {
+	delay := backoffDelay(fetcher.Options.BackoffBase, fetcher.Options.BackoffMax, state.ConsecutiveFailures+1)
+
+	var statusError *StatusError
+	if errors.As(fetchErr, &statusError) && (statusError.StatusCode == http.StatusTooManyRequests || statusError.StatusCode == http.StatusServiceUnavailable) {
+		// the delay the server asked for extends the backoff but never shortens
+		// it, the wait timeout takes its place if the header is missing
+		retryAfter, ok := parseRetryAfter(statusError.RetryAfter, time.Now(), fetcher.Options.BackoffMax)
+		if !ok {
+			retryAfter = fetcher.Options.WaitTimeout
+		}
+
+		delay = max(delay, retryAfter)
+
+		log.Warn().Msgf("%s responded with %d, retrying after %s", feedUrl, statusError.StatusCode, delay)
+	}
+
+	state.NextFetch = time.Now().Add(delay)
+}
+
+func (fetcher *Fetcher) GetState(feedUrl string) (*FeedState, error) {
+	data, err := fetcher.Options.Store.GetFeedState(feedUrl)
+	if errors.Is(err, storage.ErrNotFound) {
+		return &FeedState{Health: Healthy}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state FeedState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Discarding unreadable fetch state of %s", feedUrl)
+		return &FeedState{Health: Healthy}, nil
+	}
+
+	if state.Health == "" {
+		state.Health = Healthy
+	}
+
+	return &state, nil
+}
+
+func (fetcher *Fetcher) SaveState(feedUrl string, state *FeedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return fetcher.Options.Store.SaveFeedState(feedUrl, data)
+}
+
+func (fetcher *Fetcher) DeleteState(feedUrl string) error {
+	return fetcher.Options.Store.DeleteFeedState(feedUrl)
+}
+
+// Probe requests a feed that is not subscribed yet without conditional headers
+// or fetch state and returns the url the request ended at after redirects
+func (fetcher *Fetcher) Probe(ctx context.Context, feedUrl string) (*gofeed.Feed, *url.URL, error) {
+	feed, resp, err := fetcher.fetch(ctx, feedUrl, &FeedState{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return feed, resp.Request.URL, nil
+}
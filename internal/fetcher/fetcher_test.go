@@ -0,0 +1,429 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"net/http"
+	"net/http/httptest"
+	"rss-telegram/internal/storage"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testFeed = `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>Item</title><guid>1</guid></item>
+</channel></rss>`
+
+func TestFetcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher(&FetcherOptions{Store: storage.NewMemoryStore()})
+
+	t.Run("Test first fetch parses the feed", func(t *testing.T) {
+		feed, err := fetcher.Fetch(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("Could not fetch feed: %v", err)
+		}
+
+		if len(feed.Items) != 1 {
+			t.Errorf("Item count is incorrect, got: %d, want: %d.", len(feed.Items), 1)
+		}
+	})
+
+	t.Run("Test unchanged feed is skipped", func(t *testing.T) {
+		_, err := fetcher.Fetch(context.Background(), server.URL)
+		if !errors.Is(err, ErrNotModified) {
+			t.Fatalf("Expected not modified, got: %v", err)
+		}
+
+		if fetcher.Stats.NotModified.Load() != 1 || fetcher.Stats.Fetches.Load() != 2 {
+			t.Errorf("Stats are incorrect, got: %d of %d saved", fetcher.Stats.NotModified.Load(), fetcher.Stats.Fetches.Load())
+		}
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	store := storage.NewMemoryStore()
+	fetcher := NewFetcher(&FetcherOptions{Store: store})
+
+	t.Run("Test Retry-After is honored", func(t *testing.T) {
+		_, err := fetcher.Fetch(context.Background(), server.URL)
+
+		var httpError gofeed.HTTPError
+		if !errors.As(err, &httpError) || httpError.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("Expected http error 429, got: %v", err)
+		}
+
+		state, _ := fetcher.GetState(server.URL)
+		if wait := time.Until(state.NextFetch); wait < 110*time.Second || wait > 120*time.Second {
+			t.Errorf("Next fetch is incorrect, got: %s", wait)
+		}
+	})
+
+	t.Run("Test a short Retry-After does not shorten the backoff", func(t *testing.T) {
+		short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer short.Close()
+
+		slow := NewFetcher(&FetcherOptions{Store: storage.NewMemoryStore(), BackoffBase: time.Hour})
+
+		_, _ = slow.Fetch(context.Background(), short.URL)
+
+		state, _ := slow.GetState(short.URL)
+		if wait := time.Until(state.NextFetch); wait < 29*time.Minute {
+			t.Errorf("Expected at least the exponential backoff, got: %s", wait)
+		}
+	})
+
+	t.Run("Test backoff survives a restart", func(t *testing.T) {
+		restarted := NewFetcher(&FetcherOptions{Store: store})
+
+		_, err := restarted.Fetch(context.Background(), server.URL)
+		if !errors.Is(err, ErrBackoff) || requests != 1 {
+			t.Errorf("Expected backoff without request, got: %v after %d requests", err, requests)
+		}
+	})
+
+	t.Run("Test parse Retry-After", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		delay, ok := parseRetryAfter("Mon, 01 Jan 2024 12:05:00 GMT", now, time.Hour)
+		if !ok || delay != 5*time.Minute {
+			t.Errorf("Date delay is incorrect, got: %s", delay)
+		}
+
+		_, ok = parseRetryAfter("soon", now, time.Hour)
+		if ok {
+			t.Errorf("Invalid value was accepted")
+		}
+
+		for _, value := range []string{"9223372036854775807", "99999999999", "Fri, 01 Jan 2100 00:00:00 GMT"} {
+			delay, ok = parseRetryAfter(value, now, time.Hour)
+			if !ok || delay != time.Hour {
+				t.Errorf("Expected %s to be capped at an hour, got: %s", value, delay)
+			}
+		}
+	})
+
+	t.Run("Test backoff delay is capped", func(t *testing.T) {
+		for failures := 1; failures < 100; failures++ {
+			delay := backoffDelay(time.Minute, time.Hour, failures)
+			if delay > time.Hour || delay <= 0 {
+				t.Fatalf("Delay for %d failures is out of range, got: %s", failures, delay)
+			}
+		}
+	})
+}
+
+func TestHealth(t *testing.T) {
+	fetcher := NewFetcher(&FetcherOptions{DeadAfter: time.Hour, SuspendAfter: time.Hour})
+	state := &FeedState{Health: Healthy}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fetchErr := errors.New("connection refused")
+
+	expected := []struct {
+		at     time.Duration
+		health Health
+	}{
+		{0, Healthy},
+		{time.Minute, Degraded},
+		{2 * time.Minute, Degraded},
+		{3 * time.Minute, Degraded},
+		{4 * time.Minute, Failing},
+		{time.Hour, Dead},
+		{2 * time.Hour, Suspended},
+	}
+
+	for _, step := range expected {
+		fetcher.recordFailure(state, fetchErr, start.Add(step.at))
+
+		if state.Health != step.health {
+			t.Fatalf("Health after %s is incorrect, got: %s, want: %s.", step.at, state.Health, step.health)
+		}
+	}
+
+	if state.LastError != fetchErr.Error() {
+		t.Errorf("Last error is incorrect, got: %s", state.LastError)
+	}
+
+	fetcher.recordSuccess(state, start.Add(3*time.Hour))
+	if state.Health != Healthy || state.ConsecutiveFailures != 0 || !state.FailingSince.IsZero() {
+		t.Errorf("Feed did not recover, got: %+v", state)
+	}
+}
+
+func TestHealthChange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<rss version="2.0"><channel><title>Feed</title></channel></rss>`))
+	}))
+	defer server.Close()
+
+	var notices []Health
+	failNotice := true
+
+	fetcher := NewFetcher(&FetcherOptions{
+		Store: storage.NewMemoryStore(),
+		OnHealthChange: func(feedUrl string, previous Health, state *FeedState) error {
+			notices = append(notices, state.Health)
+
+			if failNotice {
+				failNotice = false
+				return errors.New("queue unavailable")
+			}
+
+			return nil
+		},
+	})
+
+	_ = fetcher.SaveState(server.URL, &FeedState{Health: Failing, ConsecutiveFailures: 5})
+
+	for i := 0; i < 3; i++ {
+		_, err := fetcher.Fetch(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("Could not fetch feed: %v", err)
+		}
+	}
+
+	if len(notices) != 2 || notices[1] != Healthy {
+		t.Errorf("Expected the transition to be repeated once after the failed notice, got %v", notices)
+	}
+
+	state, _ := fetcher.GetState(server.URL)
+	if state.Health != Healthy {
+		t.Errorf("Expected the recovery to be recorded, got %s", state.Health)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	fetcher := NewFetcher(&FetcherOptions{
+		Store:       storage.NewMemoryStore(),
+		Concurrency: 2,
+		HostSpacing: time.Minute,
+	})
+
+	t.Run("Test requests to the same host are spaced", func(t *testing.T) {
+		if wait := fetcher.Reserve("https://example.com/a.xml"); wait != 0 {
+			t.Fatalf("Expected first request to be allowed, got wait %s", wait)
+		}
+
+		if wait := fetcher.Reserve("https://example.com/b.xml"); wait <= 0 || wait > time.Minute {
+			t.Errorf("Expected second request to wait for the host, got %s", wait)
+		}
+
+		if wait := fetcher.Reserve("https://example.org/a.xml"); wait != 0 {
+			t.Errorf("Expected other hosts to be allowed, got wait %s", wait)
+		}
+	})
+
+	t.Run("Test concurrency is limited", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if err := fetcher.acquire(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if err := fetcher.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected third fetch to wait, got: %v", err)
+		}
+
+		fetcher.release()
+		fetcher.release()
+
+		if inFlight := fetcher.Stats.InFlight.Load(); inFlight != 0 {
+			t.Errorf("Expected no running fetches, got %d", inFlight)
+		}
+	})
+}
+
+func TestRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/moved", http.StatusMovedPermanently))
+	mux.Handle("/moved", http.RedirectHandler("/new", http.StatusPermanentRedirect))
+	mux.Handle("/temporary", http.RedirectHandler("/new", http.StatusFound))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(testFeed))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var moves []string
+
+	store := storage.NewMemoryStore()
+	fetcher := NewFetcher(&FetcherOptions{
+		Store: store,
+		OnRedirect: func(feedUrl string, movedUrl string) {
+			moves = append(moves, feedUrl+" "+movedUrl)
+		},
+	})
+
+	t.Run("Test permanent redirects move the feed", func(t *testing.T) {
+		_, err := fetcher.Fetch(context.Background(), server.URL+"/old")
+		if err != nil {
+			t.Fatalf("Could not fetch feed: %v", err)
+		}
+
+		if len(moves) != 1 || moves[0] != server.URL+"/old "+server.URL+"/new" {
+			t.Fatalf("Redirect was not reported correctly, got: %v", moves)
+		}
+
+		state, _ := fetcher.GetState(server.URL + "/new")
+		if state.ETag != `"v1"` {
+			t.Errorf("Fetch state was not moved, got etag: %s", state.ETag)
+		}
+
+		if _, err := store.GetFeedState(server.URL + "/old"); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("Expected old fetch state to be deleted, got: %v", err)
+		}
+	})
+
+	t.Run("Test temporary redirects are ignored", func(t *testing.T) {
+		moves = nil
+
+		_, err := fetcher.Fetch(context.Background(), server.URL+"/temporary")
+		if err != nil {
+			t.Fatalf("Could not fetch feed: %v", err)
+		}
+
+		if len(moves) != 0 {
+			t.Errorf("Expected no redirect, got: %v", moves)
+		}
+	})
+}
+
+func TestDiscover(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><head>
+<link rel="stylesheet" href="/style.css">
+<link rel="alternate" type="application/rss+xml" title="Posts" href="/posts.xml">
+<link rel="Alternate" type="application/atom+xml" href="comments.xml">
+<link rel="alternate" type="text/html" hreflang="de" href="/de">
+</head><body><link rel="alternate" type="application/rss+xml" href="/ignored.xml"></body></html>`))
+	})
+	mux.HandleFunc("/posts.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testFeed))
+	})
+	mux.HandleFunc("/comments.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testFeed))
+	})
+	mux.HandleFunc("/blog", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><head><title>No feed links</title></head></html>`))
+	})
+	mux.HandleFunc("/rss.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testFeed))
+	})
+
+	var probes atomic.Int64
+	mux.HandleFunc("/many", func(w http.ResponseWriter, r *http.Request) {
+		page := "<html><head>"
+		for i := 0; i < 200; i++ {
+			page += fmt.Sprintf(`<link rel="alternate" type="application/rss+xml" href="/missing/%d.xml">`, i)
+		}
+
+		_, _ = w.Write([]byte(page + "</head></html>"))
+	})
+	mux.HandleFunc("/missing/", func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		http.NotFound(w, r)
+	})
+
+	mux.HandleFunc("/found", func(w http.ResponseWriter, r *http.Request) {
+		page := "<html><head>"
+		for i := 0; i < maxProbedFeeds; i++ {
+			page += fmt.Sprintf(`<link rel="alternate" type="application/rss+xml" href="/slow/%d.xml">`, i)
+		}
+
+		_, _ = w.Write([]byte(page + "</head></html>"))
+	})
+	mux.HandleFunc("/slow/", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(testFeed))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := NewFetcher(&FetcherOptions{Store: storage.NewMemoryStore()})
+
+	t.Run("Test linked feeds are discovered", func(t *testing.T) {
+		feeds, err := fetcher.Discover(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("Could not discover feeds: %v", err)
+		}
+
+		if len(feeds) != 2 {
+			t.Fatalf("Feed count is incorrect, got: %d, want: %d.", len(feeds), 2)
+		}
+
+		if feeds[0].URL.String() != server.URL+"/posts.xml" || feeds[1].URL.String() != server.URL+"/comments.xml" {
+			t.Errorf("Feed urls are incorrect, got: %s and %s", feeds[0].URL, feeds[1].URL)
+		}
+	})
+
+	t.Run("Test common paths are tried without links", func(t *testing.T) {
+		feeds, err := fetcher.Discover(context.Background(), server.URL+"/blog")
+		if err != nil {
+			t.Fatalf("Could not discover feeds: %v", err)
+		}
+
+		if len(feeds) != 1 || feeds[0].URL.String() != server.URL+"/rss.xml" {
+			t.Errorf("Expected the common rss path, got: %v", feeds)
+		}
+	})
+
+	t.Run("Test the number of requested candidates is limited", func(t *testing.T) {
+		feeds, err := fetcher.Discover(context.Background(), server.URL+"/many")
+		if err != nil {
+			t.Fatalf("Could not discover feeds: %v", err)
+		}
+
+		if len(feeds) != 0 || probes.Load() != maxProbedFeeds {
+			t.Errorf("Expected %d requested candidates, got %d and %d feeds", maxProbedFeeds, probes.Load(), len(feeds))
+		}
+	})
+
+	t.Run("Test candidates are requested at once", func(t *testing.T) {
+		start := time.Now()
+
+		feeds, err := fetcher.Discover(context.Background(), server.URL+"/found")
+		if err != nil {
+			t.Fatalf("Could not discover feeds: %v", err)
+		}
+
+		if len(feeds) != maxDiscoveredFeeds {
+			t.Errorf("Expected %d feeds, got %d", maxDiscoveredFeeds, len(feeds))
+		}
+
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the candidates to be requested concurrently, took %s", elapsed)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package fetcher
+
+import (
+	"time"
+)
+
+type Health string
+
+const (
+	Healthy   Health = "healthy"
+	Degraded  Health = "degraded"
+	Failing   Health = "failing"
+	Dead      Health = "dead"
+	Suspended Health = "suspended"
+)
+
+const (
+	degradedFailures = 2
+	failingFailures  = 5
+)
+
+func (fetcher *Fetcher) recordSuccess(state *FeedState, now time.Time) {
+	state.ConsecutiveFailures = 0
+	state.NextFetch = time.Time{}
+	state.Health = Healthy
+	state.LastError = ""
+	state.LastSuccess = now
+	state.FailingSince = time.Time{}
+}
+
+// recordFailure moves a feed from healthy over degraded and failing to dead
+// depending on the consecutive failures and the time it has been failing
+func (fetcher *Fetcher) recordFailure(state *FeedState, err error, now time.Time) {
+	state.ConsecutiveFailures++
+	state.LastError = err.Error()
+
+	if state.FailingSince.IsZero() {
+		state.FailingSince = now
+	}
+
+	failingFor := now.Sub(state.FailingSince)
+
+	switch {
+	case state.ConsecutiveFailures >= failingFailures && failingFor >= fetcher.Options.DeadAfter+fetcher.Options.SuspendAfter:
+		state.Health = Suspended
+	case state.ConsecutiveFailures >= failingFailures && failingFor >= fetcher.Options.DeadAfter:
+		state.Health = Dead
+	case state.ConsecutiveFailures >= failingFailures:
+		state.Health = Failing
+	case state.ConsecutiveFailures >= degradedFailures:
+		state.Health = Degraded
+	default:
+		state.Health = Healthy
+	}
+}
+
+// Unsuspend lets a suspended feed be fetched again, the feed keeps its
+// failure history until the next successful fetch
+func (fetcher *Fetcher) Unsuspend(feedUrl string) error {
+	state, err := fetcher.GetState(feedUrl)
+	if err != nil {
+		return err
+	}
+
+	if state.Health != Suspended {
+		return nil
+	}
+
+	state.Health = Dead
+	state.NextFetch = time.Time{}
+	state.FailingSince = time.Now().Add(-fetcher.Options.DeadAfter)
+
+	return fetcher.SaveState(feedUrl, state)
+}
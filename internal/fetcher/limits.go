@@ -0,0 +1,108 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// hostLimiter hands out one request slot per host and spacing, feeds on the
+// same host are spread out instead of being requested at once
+type hostLimiter struct {
+	spacing time.Duration
+	slots   map[string]time.Time
+	lock    sync.Mutex
+}
+
+func newHostLimiter(spacing time.Duration) *hostLimiter {
+	return &hostLimiter{
+		spacing: spacing,
+		slots:   make(map[string]time.Time),
+	}
+}
+
+// reserve takes the slot of the host if it is free and otherwise returns the
+// time until the next free slot without taking it
+func (limiter *hostLimiter) reserve(host string, now time.Time) time.Duration {
+	if limiter.spacing <= 0 {
+		return 0
+	}
+
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	slot, ok := limiter.slots[host]
+	if ok && slot.After(now) {
+		return slot.Sub(now)
+	}
+
+	limiter.slots[host] = now.Add(limiter.spacing)
+
+	// forget hosts whose slots have passed to keep the map small
+	if len(limiter.slots) > 1024 {
+		for key, value := range limiter.slots {
+			if !value.After(now) {
+				delete(limiter.slots, key)
+			}
+		}
+	}
+
+	return 0
+}
+
+// Reserve claims a request slot for the host of the feed url, a positive
+// duration means the host was requested recently and the fetch has to wait
+func (fetcher *Fetcher) Reserve(feedUrl string) time.Duration {
+	parsed, err := url.Parse(feedUrl)
+	if err != nil {
+		return 0
+	}
+
+	wait := fetcher.hosts.reserve(parsed.Hostname(), time.Now())
+	if wait > 0 {
+		fetcher.Stats.Delayed.Add(1)
+	}
+
+	return wait
+}
+
+// acquire blocks until one of the global fetch slots is free
+func (fetcher *Fetcher) acquire(ctx context.Context) error {
+	if fetcher.slots == nil {
+		return nil
+	}
+
+	select {
+	case fetcher.slots <- struct{}{}:
+		fetcher.Stats.InFlight.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (fetcher *Fetcher) release() {
+	if fetcher.slots == nil {
+		return
+	}
+
+	fetcher.Stats.InFlight.Add(-1)
+	<-fetcher.slots
+}
+
+// Limits describes the configured fetch limits and their current usage
+func (fetcher *Fetcher) Limits() string {
+	concurrency := "unlimited"
+	if fetcher.Options.Concurrency > 0 {
+		concurrency = fmt.Sprintf("%d of %d", fetcher.Stats.InFlight.Load(), fetcher.Options.Concurrency)
+	}
+
+	spacing := "no spacing between requests to the same host"
+	if fetcher.Options.HostSpacing > 0 {
+		spacing = fmt.Sprintf("at most one request per host every %s", fetcher.Options.HostSpacing)
+	}
+
+	return fmt.Sprintf("%s fetches running, %s, %d fetches delayed by the host limit", concurrency, spacing, fetcher.Stats.Delayed.Load())
+}
@@ -0,0 +1,75 @@
+package fetcher
+
+import (
+	"errors"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"rss-telegram/internal/storage"
+	"rss-telegram/internal/utils"
+	"slices"
+)
+
+// permanentRedirect returns the url the request was permanently moved to.
+// Only a chain of 301 and 308 responses starting at the original request
+// counts, the target of a temporary redirect is not stored.
+func permanentRedirect(resp *http.Response) *url.URL {
+	var chain []*http.Request
+	for req := resp.Request; req != nil; {
+		chain = append(chain, req)
+
+		if req.Response == nil {
+			break
+		}
+
+		req = req.Response.Request
+	}
+
+	slices.Reverse(chain)
+
+	var target *url.URL
+	for _, req := range chain[1:] {
+		status := req.Response.StatusCode
+		if status != http.StatusMovedPermanently && status != http.StatusPermanentRedirect {
+			break
+		}
+
+		target = req.URL
+	}
+
+	return target
+}
+
+// moveState stores the fetch state under the new url of a moved feed, an
+// existing state of the new url is kept because it is already fetched
+func (fetcher *Fetcher) moveState(feedUrl string, movedUrl string, state *FeedState) error {
+	_, err := fetcher.Options.Store.GetFeedState(movedUrl)
+	if errors.Is(err, storage.ErrNotFound) {
+		err = fetcher.SaveState(movedUrl, state)
+	}
+	if err != nil {
+		return err
+	}
+
+	return fetcher.DeleteState(feedUrl)
+}
+
+// handleRedirect moves the state of a permanently redirected feed and reports
+// the new canonical url
+func (fetcher *Fetcher) handleRedirect(feedUrl string, moved *url.URL, state *FeedState) {
+	movedUrl := utils.CanonicalURL(moved).String()
+	if movedUrl == feedUrl {
+		return
+	}
+
+	log.Info().Msgf("Feed %s moved permanently to %s", feedUrl, movedUrl)
+
+	err := fetcher.moveState(feedUrl, movedUrl, state)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed moving fetch state of %s", feedUrl)
+	}
+
+	if fetcher.Options.OnRedirect != nil {
+		fetcher.Options.OnRedirect(feedUrl, movedUrl)
+	}
+}
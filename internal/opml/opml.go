@@ -0,0 +1,80 @@
+package opml
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+type OPML struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    Head     `xml:"head"`
+	Body    Body     `xml:"body"`
+}
+
+type Head struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type Body struct {
+	Outlines []*Outline `xml:"outline"`
+}
+
+type Outline struct {
+	Text          string     `xml:"text,attr,omitempty"`
+	Title         string     `xml:"title,attr,omitempty"`
+	Type          string     `xml:"type,attr,omitempty"`
+	XMLURL        string     `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL       string     `xml:"htmlUrl,attr,omitempty"`
+	SearchPattern string     `xml:"searchPattern,attr,omitempty"`
+	Outlines      []*Outline `xml:"outline"`
+}
+
+func New(title string) *OPML {
+	return &OPML{
+		Version: "2.0",
+		Head: Head{
+			Title:       title,
+			DateCreated: time.Now().Format(time.RFC1123Z),
+		},
+	}
+}
+
+func Parse(data []byte) (*OPML, error) {
+	var document OPML
+	err := xml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+func (document *OPML) Marshal() ([]byte, error) {
+	data, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), data...), nil
+}
+
+// Feeds returns all outlines with a feed url, outlines nested in categories included
+func (document *OPML) Feeds() []*Outline {
+	return collectFeeds(document.Body.Outlines)
+}
+
+func collectFeeds(outlines []*Outline) []*Outline {
+	var output []*Outline
+
+	for _, outline := range outlines {
+		if outline.XMLURL != "" {
+			output = append(output, outline)
+		}
+
+		output = append(output, collectFeeds(outline.Outlines)...)
+	}
+
+	return output
+}
@@ -0,0 +1,51 @@
+package opml
+
+import "testing"
+
+func TestOPML(t *testing.T) {
+	t.Run("Test parse nested outlines", func(t *testing.T) {
+		document, err := Parse([]byte(`<?xml version="1.0"?>
+<opml version="1.0">
+  <head><title>Reader export</title></head>
+  <body>
+    <outline text="News">
+      <outline text="A" type="rss" xmlUrl="https://a.example.com/feed"/>
+      <outline text="B" type="rss" xmlUrl="https://b.example.com/rss.xml" searchPattern="go,rust"/>
+    </outline>
+    <outline text="C" type="rss" xmlUrl="https://c.example.com/atom.xml"/>
+  </body>
+</opml>`))
+		if err != nil {
+			t.Fatalf("Could not parse document: %v", err)
+		}
+
+		feeds := document.Feeds()
+		if len(feeds) != 3 {
+			t.Fatalf("Feed count is incorrect, got: %d, want: %d.", len(feeds), 3)
+		}
+
+		if feeds[1].SearchPattern != "go,rust" {
+			t.Errorf("Search pattern is incorrect, got: %s, want: %s.", feeds[1].SearchPattern, "go,rust")
+		}
+	})
+
+	t.Run("Test export roundtrip", func(t *testing.T) {
+		document := New("Test")
+		document.Body.Outlines = append(document.Body.Outlines, &Outline{Text: "A", XMLURL: "https://a.example.com/feed?a=1&b=2", SearchPattern: "<news>"})
+
+		data, err := document.Marshal()
+		if err != nil {
+			t.Fatalf("Could not marshal document: %v", err)
+		}
+
+		parsed, err := Parse(data)
+		if err != nil {
+			t.Fatalf("Could not parse exported document: %v", err)
+		}
+
+		feeds := parsed.Feeds()
+		if len(feeds) != 1 || feeds[0].XMLURL != "https://a.example.com/feed?a=1&b=2" || feeds[0].SearchPattern != "<news>" {
+			t.Errorf("Exported feed is incorrect, got: %+v", feeds)
+		}
+	})
+}
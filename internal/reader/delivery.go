@@ -0,0 +1,137 @@
+package reader
+
+import (
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/subscription"
+	"slices"
+	"sync"
+	"time"
+)
+
+// sortChronologically orders the items oldest first by their published or
+// updated date, items without date keep their order after the dated items
+func sortChronologically(items []*gofeed.Item) {
+	slices.SortStableFunc(items, func(a, b *gofeed.Item) int {
+		timeA, timeB := itemTime(a), itemTime(b)
+
+		switch {
+		case timeA.IsZero() && timeB.IsZero():
+			return 0
+		case timeA.IsZero():
+			return 1
+		case timeB.IsZero():
+			return -1
+		default:
+			return timeA.Compare(timeB)
+		}
+	})
+}
+
+func itemTime(item *gofeed.Item) time.Time {
+	if item.PublishedParsed != nil {
+		return *item.PublishedParsed
+	}
+
+	if item.UpdatedParsed != nil {
+		return *item.UpdatedParsed
+	}
+
+	return time.Time{}
+}
+
+// chatLimiter counts the item messages sent to every chat in the current hour
+type chatLimiter struct {
+	limit   int
+	windows map[int64]*chatWindow
+	lock    sync.Mutex
+}
+
+type chatWindow struct {
+	start time.Time
+	sent  int
+}
+
+func newChatLimiter(limit int) *chatLimiter {
+	return &chatLimiter{
+		limit:   limit,
+		windows: make(map[int64]*chatWindow),
+	}
+}
+
+// take claims up to count messages of the hourly limit of the chat and
+// returns the number of messages that may be sent
+func (limiter *chatLimiter) take(chatId int64, count int, now time.Time) int {
+	if limiter.limit <= 0 {
+		return count
+	}
+
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	window, ok := limiter.windows[chatId]
+	if !ok || now.Sub(window.start) >= time.Hour {
+		window = &chatWindow{start: now}
+		limiter.windows[chatId] = window
+	}
+
+	count = min(count, limiter.limit-window.sent)
+	window.sent += count
+
+	// forget chats whose windows have passed to keep the map small
+	if len(limiter.windows) > 1024 {
+		for key, value := range limiter.windows {
+			if now.Sub(value.start) >= time.Hour {
+				delete(limiter.windows, key)
+			}
+		}
+	}
+
+	return count
+}
+
+// release gives back count messages taken at the given time, unless the
+// window they were taken from has passed in the meantime
+func (limiter *chatLimiter) release(chatId int64, count int, taken time.Time) {
+	if limiter.limit <= 0 {
+		return
+	}
+
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	window, ok := limiter.windows[chatId]
+	if !ok || window.start.After(taken) {
+		return
+	}
+
+	window.sent = max(window.sent-count, 0)
+}
+
+// maxItems returns the number of items a subscription receives per fetch
+func (readerHandler *ReaderHandler) maxItems(sub *subscription.Subscription) int {
+	if sub.MaxItems > 0 {
+		return sub.MaxItems
+	}
+
+	return readerHandler.Options.MaxItems
+}
+
+// overflowMessage collapses the items above the limits into one summary, it
+// is sent even if the hourly limit is reached so no item gets lost
+func overflowMessage(items []*gofeed.Item, sub *subscription.Subscription) *dispatcher.QueuedMessage {
+	overflow := make([]*subscription.BufferedItem, len(items))
+	for i, item := range items {
+		overflow[i] = &subscription.BufferedItem{Title: item.Title, Link: item.Link}
+	}
+
+	key := sub.Key()
+
+	return &dispatcher.QueuedMessage{
+		ChatId:       sub.ChatId,
+		Subscription: &key,
+		Text:         subscription.RenderItemList(fmt.Sprintf("…and %d more items of %s:", len(items), sub.URL.String()), overflow),
+		Chunked:      true,
+	}
+}
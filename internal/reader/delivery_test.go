@@ -0,0 +1,100 @@
+package reader
+
+import (
+	"github.com/mmcdole/gofeed"
+	"rss-telegram/internal/bot"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/storage"
+	"rss-telegram/internal/subscription"
+	"testing"
+	"time"
+)
+
+func TestDelivery(t *testing.T) {
+	t.Run("Test items are sorted oldest first", func(t *testing.T) {
+		now := time.Now()
+		older := now.Add(-time.Hour)
+
+		items := []*gofeed.Item{
+			{Title: "undated"},
+			{Title: "new", PublishedParsed: &now},
+			{Title: "old", UpdatedParsed: &older},
+		}
+
+		sortChronologically(items)
+
+		for i, title := range []string{"old", "new", "undated"} {
+			if items[i].Title != title {
+				t.Errorf("Expected %s at position %d, got %s", title, i, items[i].Title)
+			}
+		}
+	})
+
+	t.Run("Test hourly limit of chats", func(t *testing.T) {
+		limiter := newChatLimiter(5)
+		now := time.Now()
+
+		if count := limiter.take(1, 3, now); count != 3 {
+			t.Errorf("Expected 3 messages, got %d", count)
+		}
+
+		if count := limiter.take(1, 3, now.Add(time.Minute)); count != 2 {
+			t.Errorf("Expected the limit to leave 2 messages, got %d", count)
+		}
+
+		if count := limiter.take(2, 3, now); count != 3 {
+			t.Errorf("Expected other chats to have their own limit, got %d", count)
+		}
+
+		if count := limiter.take(1, 3, now.Add(time.Hour)); count != 3 {
+			t.Errorf("Expected the limit to reset after an hour, got %d", count)
+		}
+
+		if count := newChatLimiter(0).take(1, 100, now); count != 100 {
+			t.Errorf("Expected no limit, got %d", count)
+		}
+	})
+
+	t.Run("Test released messages count again", func(t *testing.T) {
+		limiter := newChatLimiter(5)
+		now := time.Now()
+
+		limiter.take(1, 4, now)
+		limiter.release(1, 3, now)
+
+		if count := limiter.take(1, 5, now); count != 4 {
+			t.Errorf("Expected the released messages to be available, got %d", count)
+		}
+
+		limiter.take(1, 5, now.Add(time.Hour))
+		limiter.release(1, 5, now)
+
+		if count := limiter.take(1, 5, now.Add(time.Hour)); count != 0 {
+			t.Errorf("Expected a release of a passed window to be ignored, got %d", count)
+		}
+	})
+
+	t.Run("Test failed enqueue does not use the hourly limit", func(t *testing.T) {
+		store := storage.NewMemoryStore()
+
+		readerHandler := NewReaderHandler(&ReaderHandlerOptions{
+			Store:               store,
+			BotHandler:          &bot.BotHandler{Queue: dispatcher.NewQueue(nil, &dispatcher.QueueOptions{Store: failingQueueStore{store}})},
+			MaxItems:            10,
+			ChatHourlyLimit:     2,
+			SubscriptionHandler: &subscription.SubscriptionHandler{},
+		})
+
+		sub := newTestSubscription(t, "https://example.com/feed")
+		items := []*gofeed.Item{{Title: "A"}, {Title: "B"}}
+
+		err := readerHandler.notifyNewItems(items, sub)
+		if err == nil {
+			t.Fatal("Expected the enqueue to fail")
+		}
+
+		if count := readerHandler.chatLimits.take(sub.ChatId, 2, time.Now()); count != 2 {
+			t.Errorf("Expected the limit to be given back, got %d", count)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/subscription"
+	"time"
+)
+
+// digestInterval is the time between two checks for due digests
+const digestInterval = time.Minute
+
+// bufferDigestItems collects the matching items for the next digest of the
+// subscription, the digest times are in the time zone of the chat
+func (readerHandler *ReaderHandler) bufferDigestItems(items []*gofeed.Item, sub *subscription.Subscription) error {
+	var digestItems []*subscription.BufferedItem
+	for _, item := range items {
+		if !readerHandler.shouldSendItem(item, sub) {
+			continue
+		}
+
+		digestItems = append(digestItems, &subscription.BufferedItem{Title: item.Title, Link: item.Link})
+	}
+
+	now := time.Now().In(readerHandler.chatSettings(sub.ChatId).Location())
+
+	return readerHandler.Options.SubscriptionHandler.BufferDigestItems(sub, digestItems, now)
+}
+
+// runDigests sends the due digests every minute, the due times are stored so
+// digests missed during a restart are sent on the first check
+func (readerHandler *ReaderHandler) runDigests(ctx context.Context) {
+	ticker := time.NewTicker(digestInterval)
+	defer ticker.Stop()
+
+	for {
+		readerHandler.sendDueDigests(time.Now().UTC())
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendDueDigests sends the digests due at now, only the due entries of the
+// ordered due times are read
+func (readerHandler *ReaderHandler) sendDueDigests(now time.Time) {
+	keys, err := readerHandler.Options.Store.GetDueDigests(now)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed loading due digests")
+		return
+	}
+
+	subscriptions, err := readerHandler.Options.SubscriptionHandler.GetSubscriptions(keys)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed loading subscriptions for digests")
+		return
+	}
+
+	for _, sub := range subscriptions {
+		if sub.IsPaused(now) {
+			continue
+		}
+
+		// digests due during quiet hours are held until they end or sent silently
+		chatSettings := readerHandler.chatSettings(sub.ChatId)
+		if chatSettings.Holds(now) {
+			continue
+		}
+
+		err = readerHandler.sendDigest(sub, now.In(chatSettings.Location()), chatSettings.Silences(now))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed sending digest of %s for %d", sub.URL.String(), sub.ChatId)
+		}
+	}
+}
+
+// sendDigest queues the collected items and schedules the next digest, the
+// items are collected again if they could not be queued
+func (readerHandler *ReaderHandler) sendDigest(sub *subscription.Subscription, now time.Time, silent bool) error {
+	items, err := readerHandler.Options.SubscriptionHandler.TakeDigestItems(sub)
+	if err != nil {
+		return err
+	}
+
+	if len(items) > 0 {
+		log.Info().Msgf("Sending digest of %d items of %s to %d", len(items), sub.URL.String(), sub.ChatId)
+
+		key := sub.Key()
+
+		err = readerHandler.Options.BotHandler.Queue.Enqueue([]*dispatcher.QueuedMessage{{
+			ChatId:              sub.ChatId,
+			Subscription:        &key,
+			Text:                subscription.RenderItemList(fmt.Sprintf("%s of %s, %d items:", digestTitle(sub), sub.URL.String(), len(items)), items),
+			Chunked:             true,
+			DisableNotification: silent,
+		}})
+		if err != nil {
+			return errors.Join(err, readerHandler.Options.SubscriptionHandler.BufferDigestItems(sub, items, now))
+		}
+	}
+
+	if !sub.IsDigest() {
+		return readerHandler.Options.Store.DeleteDigestDue(sub.Key())
+	}
+
+	return readerHandler.Options.SubscriptionHandler.ScheduleDigest(sub, now)
+}
+
+func digestTitle(sub *subscription.Subscription) string {
+	if !sub.IsDigest() {
+		return "Collected items"
+	}
+
+	return sub.Delivery.String()
+}
@@ -0,0 +1,151 @@
+package reader
+
+import (
+	"encoding/json"
+	"github.com/mmcdole/gofeed"
+	"rss-telegram/internal/bot"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/settings"
+	"rss-telegram/internal/storage"
+	"rss-telegram/internal/subscription"
+	"testing"
+	"time"
+)
+
+func TestDigest(t *testing.T) {
+	t.Run("Test next digest times", func(t *testing.T) {
+		// a wednesday
+		now := time.Date(2024, 1, 3, 9, 30, 0, 0, time.UTC)
+
+		tests := []struct {
+			delivery   subscription.DeliveryMode
+			digestTime string
+			expected   time.Time
+		}{
+			{subscription.DeliverHourly, "", time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)},
+			{subscription.DeliverDaily, "", time.Date(2024, 1, 4, 8, 0, 0, 0, time.UTC)},
+			{subscription.DeliverDaily, "18:15", time.Date(2024, 1, 3, 18, 15, 0, 0, time.UTC)},
+			{subscription.DeliverWeekly, "07:00", time.Date(2024, 1, 8, 7, 0, 0, 0, time.UTC)},
+		}
+
+		for _, test := range tests {
+			sub := &subscription.Subscription{Delivery: test.delivery, DigestTime: test.digestTime}
+			if next := sub.NextDigest(now); !next.Equal(test.expected) {
+				t.Errorf("Expected %s digest at %s, got %s", test.delivery, test.expected, next)
+			}
+		}
+	})
+
+	t.Run("Test collected items are sent when the digest is due", func(t *testing.T) {
+		store := storage.NewMemoryStore()
+
+		subscriptionHandler := subscription.NewSubscriptionHandler(&subscription.SubscriptionHandlerOptions{Store: store})
+		queue := dispatcher.NewQueue(nil, &dispatcher.QueueOptions{Store: store})
+
+		sub := newTestSubscription(t, "https://example.com/feed")
+		sub.Delivery = subscription.DeliverHourly
+
+		_, err := subscriptionHandler.AddSubscription(sub.ChatId, sub)
+		if err != nil {
+			t.Fatalf("Could not add subscription: %v", err)
+		}
+
+		readerHandler := NewReaderHandler(&ReaderHandlerOptions{
+			Store:               store,
+			BotHandler:          &bot.BotHandler{Queue: queue},
+			SubscriptionHandler: subscriptionHandler,
+		})
+
+		err = readerHandler.bufferDigestItems([]*gofeed.Item{{Title: "A", Link: "https://example.com/a"}, {Title: "B", Link: "https://example.com/b"}}, sub)
+		if err != nil {
+			t.Fatalf("Could not buffer items: %v", err)
+		}
+
+		due, err := store.GetDigestDue(sub.Key())
+		if err != nil {
+			t.Fatalf("Expected a scheduled digest: %v", err)
+		}
+
+		readerHandler.sendDueDigests(due.Add(-time.Second))
+		if queue.Len() != 0 {
+			t.Errorf("Expected no digest before it is due, got %d messages", queue.Len())
+		}
+
+		readerHandler.sendDueDigests(due)
+		if queue.Len() != 1 {
+			t.Errorf("Expected one digest message, got %d", queue.Len())
+		}
+
+		next, _ := store.GetDigestDue(sub.Key())
+		if next.Sub(due) != time.Hour {
+			t.Errorf("Expected the next digest an hour later, got %s", next.Sub(due))
+		}
+
+		readerHandler.sendDueDigests(next)
+		if queue.Len() != 1 {
+			t.Errorf("Expected empty digests not to be sent, got %d messages", queue.Len())
+		}
+	})
+	t.Run("Test items are held during quiet hours", func(t *testing.T) {
+		store := storage.NewMemoryStore()
+
+		subscriptionHandler := subscription.NewSubscriptionHandler(&subscription.SubscriptionHandlerOptions{Store: store})
+		settingsHandler := settings.NewSettingsHandler(&settings.SettingsHandlerOptions{Store: store})
+		queue := dispatcher.NewQueue(nil, &dispatcher.QueueOptions{Store: store})
+
+		sub := newTestSubscription(t, "https://example.com/feed")
+
+		_, err := subscriptionHandler.AddSubscription(sub.ChatId, sub)
+		if err != nil {
+			t.Fatalf("Could not add subscription: %v", err)
+		}
+
+		err = settingsHandler.SaveChatSettings(sub.ChatId, &settings.ChatSettings{TimeZone: "America/New_York", QuietStart: "22:00", QuietEnd: "07:00"})
+		if err != nil {
+			t.Fatalf("Could not save settings: %v", err)
+		}
+
+		readerHandler := NewReaderHandler(&ReaderHandlerOptions{
+			Store:               store,
+			BotHandler:          &bot.BotHandler{Queue: queue},
+			SubscriptionHandler: subscriptionHandler,
+			SettingsHandler:     settingsHandler,
+		})
+
+		err = readerHandler.bufferDigestItems([]*gofeed.Item{{Title: "A", Link: "https://example.com/a"}}, sub)
+		if err != nil {
+			t.Fatalf("Could not buffer items: %v", err)
+		}
+
+		newYork, _ := time.LoadLocation("America/New_York")
+		tomorrow := time.Now().In(newYork).AddDate(0, 0, 1)
+
+		readerHandler.sendDueDigests(time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 3, 0, 0, 0, newYork))
+		if queue.Len() != 0 {
+			t.Errorf("Expected the items to be held during quiet hours, got %d messages", queue.Len())
+		}
+
+		err = settingsHandler.SaveChatSettings(sub.ChatId, &settings.ChatSettings{TimeZone: "America/New_York", QuietStart: "22:00", QuietEnd: "07:00", QuietMode: settings.QuietSilent})
+		if err != nil {
+			t.Fatalf("Could not save settings: %v", err)
+		}
+
+		readerHandler.sendDueDigests(time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 3, 0, 0, 0, newYork))
+
+		entries, err := store.PeekQueue(storage.DeliveryQueue, "", 10)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("Expected one message sent silently, got %d (%v)", len(entries), err)
+		}
+
+		var message dispatcher.QueuedMessage
+		_ = json.Unmarshal(entries[0].Data, &message)
+
+		if !message.DisableNotification {
+			t.Errorf("Expected the message to be sent without notification")
+		}
+
+		if _, err = store.GetDigestDue(sub.Key()); err != storage.ErrNotFound {
+			t.Errorf("Expected no further digest of an instant subscription, got %v", err)
+		}
+	})
+}
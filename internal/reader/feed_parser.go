@@ -1,88 +1,291 @@
 package reader
 
 import (
+	"errors"
 	"fmt"
-	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 	"github.com/mmcdole/gofeed"
 	"github.com/rs/zerolog/log"
+	"net/url"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/storage"
 	"rss-telegram/internal/subscription"
 	"rss-telegram/internal/utils"
 	"slices"
 	"strings"
+	"time"
 )
 
-func (readerHandler *ReaderHandler) handleFeed(subscriptionTicker *SubscriptionTicker, feed *gofeed.Feed) error {
-	for _, sub := range subscriptionTicker.Subscriptions {
-		newItems, err := readerHandler.getNewItems(feed, sub)
+// maxDescriptionLength is the number of characters of an item description
+// that are sent, telegram rejects messages above 4096 characters
+const maxDescriptionLength = 3000
+
+// handleFeed processes the items once per item identity used by the subscriptions of the feed
+func (readerHandler *ReaderHandler) handleFeed(feedUrl string, subscriptions []*subscription.Subscription, feed *gofeed.Feed) error {
+	identities := make(map[subscription.ItemIdentity][]*subscription.Subscription)
+	for _, sub := range subscriptions {
+		identities[sub.Identity] = append(identities[sub.Identity], sub)
+	}
+
+	for identity, identitySubscriptions := range identities {
+		err := readerHandler.handleItems(itemNamespace(feedUrl, identity), identity, identitySubscriptions, feed)
 		if err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+func (readerHandler *ReaderHandler) handleItems(namespace string, identity subscription.ItemIdentity, subscriptions []*subscription.Subscription, feed *gofeed.Feed) error {
+	ids := make([]string, len(feed.Items))
+	for i, item := range feed.Items {
+		ids[i] = itemId(item, identity)
+	}
+
+	previous, err := readerHandler.Options.Store.LastSequence(namespace)
+	if err != nil {
+		return err
+	}
 
-		err = readerHandler.addGuids(newItems, sub)
+	sequences, err := readerHandler.Options.Store.AddItems(namespace, ids, time.Now())
+	if err != nil {
+		return err
+	}
+
+	last, err := readerHandler.Options.Store.LastSequence(namespace)
+	if err != nil {
+		return err
+	}
+
+	// a subscription switching to an identity nobody used before would see
+	// every item as new, the first items of the identity only set the cursors
+	baseline := previous == 0
+
+	for _, sub := range subscriptions {
+		newItems, firstFetch, err := readerHandler.getNewItems(feed, sequences, last, sub, baseline)
 		if err != nil {
 			return err
 		}
 
-		firstFetch, err := readerHandler.isFirstFetch(sub)
+		if firstFetch {
+			continue
+		}
+
+		if sub.IsPaused(time.Now()) {
+			err = readerHandler.bufferMissedItems(newItems, sub)
+		} else {
+			if sub.SnoozeExpired(time.Now()) {
+				readerHandler.sendCatchUp(sub)
+			}
+
+			// items arriving during the quiet hours of the chat are held like a
+			// digest that is sent once the quiet hours end
+			if sub.IsDigest() || readerHandler.chatSettings(sub.ChatId).Holds(time.Now()) {
+				err = readerHandler.bufferDigestItems(newItems, sub)
+			} else {
+				err = readerHandler.notifyNewItems(newItems, sub)
+			}
+
+			readerHandler.notifyUpdatedItems(feed, newItems, sub)
+		}
+
+		// the cursor only moves once the items are queued or buffered, items
+		// that could not be stored are new again on the next fetch
 		if err != nil {
-			return err
+			log.Warn().Err(err).Msgf("Failed queueing new items of %s for %d", sub.URL.String(), sub.ChatId)
+			continue
 		}
 
-		if !firstFetch {
-			readerHandler.notifyNewItems(newItems, sub)
+		if len(newItems) > 0 {
+			err = readerHandler.Options.Store.SetCursor(sub.Key(), last)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
-	return nil
+	// items that are still part of the feed must not be seen as new again
+	return readerHandler.Options.Store.PruneItems(namespace, readerHandler.Options.ItemRetention, time.Now().Add(-readerHandler.Options.ItemMaxAge), ids)
 }
 
-func (readerHandler *ReaderHandler) getNewItems(feed *gofeed.Feed, subscription *subscription.Subscription) ([]*gofeed.Item, error) {
-	knownGuids, err := readerHandler.Options.RedisDb.SMembers(readerHandler.Context, fmt.Sprintf("guids:%d:%s", subscription.ChatId, subscription.Id)).Result()
+// getNewItems returns the items seen after the cursor of the subscription,
+// the cursor is moved by the caller once the items are queued. Nothing is
+// returned on the first fetch of a subscription or if only the cursor should be set.
+func (readerHandler *ReaderHandler) getNewItems(feed *gofeed.Feed, sequences []int64, last int64, sub *subscription.Subscription, baseline bool) ([]*gofeed.Item, bool, error) {
+	cursor, err := readerHandler.Options.Store.GetCursor(sub.Key())
+	if errors.Is(err, storage.ErrNotFound) {
+		return readerHandler.migrateCursor(feed, last, sub)
+	}
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	if baseline {
+		return nil, false, readerHandler.Options.Store.SetCursor(sub.Key(), last)
 	}
 
 	var output []*gofeed.Item
-	for _, item := range feed.Items {
-		if slices.Contains(knownGuids, item.GUID) {
+	var added []int64
+	for i, item := range feed.Items {
+		if sequences[i] <= cursor || slices.Contains(added, sequences[i]) {
 			continue
 		}
 
+		added = append(added, sequences[i])
 		output = append(output, item)
 	}
 
-	return output, nil
+	return output, false, nil
 }
 
-func (readerHandler *ReaderHandler) addGuids(items []*gofeed.Item, subscription *subscription.Subscription) error {
-	guids := make([]string, len(items))
-	for i, item := range items {
-		guids[i] = item.GUID
+// migrateCursor creates the cursor of a subscription, subscriptions of older
+// versions receive the items missing in their own guids once
+func (readerHandler *ReaderHandler) migrateCursor(feed *gofeed.Feed, last int64, sub *subscription.Subscription) ([]*gofeed.Item, bool, error) {
+	firstFetch, err := readerHandler.Options.Store.MarkFetched(sub.Key())
+	if err != nil {
+		return nil, false, err
+	}
+
+	var output []*gofeed.Item
+
+	if !firstFetch {
+		knownGuids, err := readerHandler.Options.Store.GetGuids(sub.Key())
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, item := range feed.Items {
+			if slices.Contains(knownGuids, item.GUID) {
+				continue
+			}
+
+			knownGuids = append(knownGuids, item.GUID)
+			output = append(output, item)
+		}
+
+		log.Info().Msgf("Migrating subscription %s of %d to the shared items of its feed", sub.URL.String(), sub.ChatId)
+	}
+
+	err = readerHandler.Options.Store.SetCursor(sub.Key(), last)
+	if err != nil {
+		return nil, false, err
 	}
 
-	err := readerHandler.Options.RedisDb.SAdd(readerHandler.Context, fmt.Sprintf("guids:%d:%s", subscription.ChatId, subscription.Id), guids).Err()
+	err = readerHandler.Options.Store.DeleteGuids(sub.Key())
 	if err != nil {
+		return nil, false, err
+	}
+
+	return output, firstFetch, nil
+}
+
+// notifyNewItems queues the matching items oldest first, items above the
+// limit of the subscription or the hourly limit of the chat are summarized
+func (readerHandler *ReaderHandler) notifyNewItems(items []*gofeed.Item, sub *subscription.Subscription) error {
+	var matching []*gofeed.Item
+	for _, item := range items {
+		if readerHandler.shouldSendItem(item, sub) {
+			matching = append(matching, item)
+		}
+	}
+
+	if len(matching) == 0 {
+		return nil
+	}
+
+	sortChronologically(matching)
+
+	now := time.Now()
+	count := readerHandler.chatLimits.take(sub.ChatId, min(len(matching), readerHandler.maxItems(sub)), now)
+	silent := readerHandler.chatSettings(sub.ChatId).Silences(now)
+	key := sub.Key()
+
+	var messages []*dispatcher.QueuedMessage
+	for _, item := range matching[:count] {
+		log.Trace().Msg(itemAsMessage(item))
+
+		message := &dispatcher.QueuedMessage{
+			ChatId:              sub.ChatId,
+			Subscription:        &key,
+			Text:                itemAsMessage(item),
+			ParseMode:           models.ParseModeHTML,
+			DisableNotification: silent,
+		}
+
+		if sub.Updates != subscription.UpdatesIgnore {
+			message.ItemId = itemId(item, sub.Identity)
+			message.Fingerprint = itemFingerprint(item)
+		}
+
+		messages = append(messages, message)
+	}
+
+	if count < len(matching) {
+		log.Info().Msgf("Summarizing %d items of %s for %d", len(matching)-count, sub.URL.String(), sub.ChatId)
+
+		overflow := overflowMessage(matching[count:], sub)
+		overflow.DisableNotification = silent
+
+		messages = append(messages, overflow)
+	}
+
+	err := readerHandler.Options.BotHandler.Queue.Enqueue(messages)
+	if err != nil {
+		// the items are fetched again, so they must not count against the chat
+		readerHandler.chatLimits.release(sub.ChatId, count, now)
 		return err
 	}
 
 	return nil
 }
 
-func (readerHandler *ReaderHandler) notifyNewItems(items []*gofeed.Item, subscription *subscription.Subscription) {
+func (readerHandler *ReaderHandler) bufferMissedItems(items []*gofeed.Item, sub *subscription.Subscription) error {
+	var missedItems []*subscription.BufferedItem
 	for _, item := range items {
-		if !readerHandler.shouldSendItem(item, subscription) {
+		if !readerHandler.shouldSendItem(item, sub) {
 			continue
 		}
 
-		log.Trace().Msg(itemAsMessage(item))
+		missedItems = append(missedItems, &subscription.BufferedItem{Title: item.Title, Link: item.Link})
+	}
+
+	return readerHandler.Options.SubscriptionHandler.BufferMissedItems(sub, missedItems)
+}
+
+// sendCatchUp queues the items missed during an ended snooze and resumes the
+// subscription, the missed items are only dropped once they are queued
+func (readerHandler *ReaderHandler) sendCatchUp(sub *subscription.Subscription) {
+	items, err := readerHandler.Options.SubscriptionHandler.PeekMissedItems(sub)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed reading the missed items of %s for %d", sub.URL.String(), sub.ChatId)
+		return
+	}
 
-		_, _ = readerHandler.Options.BotHandler.Bot.SendMessage(readerHandler.Options.BotHandler.Options.Context, &bot.SendMessageParams{
-			ChatID:    subscription.ChatId,
-			Text:      itemAsMessage(item),
-			ParseMode: models.ParseModeHTML,
-		})
+	if len(items) > 0 {
+		key := sub.Key()
+
+		err = readerHandler.Options.BotHandler.Queue.Enqueue([]*dispatcher.QueuedMessage{{
+			ChatId:              sub.ChatId,
+			Subscription:        &key,
+			Text:                subscription.RenderItemList(fmt.Sprintf("Snooze of %s ended, you missed %d items:", sub.URL.String(), len(items)), items),
+			Chunked:             true,
+			DisableNotification: readerHandler.chatSettings(sub.ChatId).Silences(time.Now()),
+		}})
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed queueing the missed items of %s for %d", sub.URL.String(), sub.ChatId)
+			return
+		}
+
+		err = readerHandler.Options.SubscriptionHandler.DropMissedItems(sub, len(items))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed dropping the queued missed items of %s for %d", sub.URL.String(), sub.ChatId)
+		}
+	}
 
+	err = readerHandler.Options.SubscriptionHandler.ResumeSubscription(sub.ChatId, sub)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed resuming snoozed subscription %s for %d", sub.URL.String(), sub.ChatId)
 	}
 }
 
@@ -112,35 +315,23 @@ func (readerHandler *ReaderHandler) shouldSendItem(item *gofeed.Item, subscripti
 	return false
 }
 
-func (readerHandler *ReaderHandler) isFirstFetch(subscription *subscription.Subscription) (bool, error) {
-	val, err := readerHandler.Options.RedisDb.Exists(readerHandler.Context, fmt.Sprintf("post-fetch:%d:%s", subscription.ChatId, subscription.Id)).Result()
-	if err != nil {
-		return false, err
-	}
-
-	if val == 0 {
-		err = readerHandler.Options.RedisDb.Set(readerHandler.Context, fmt.Sprintf("post-fetch:%d:%s", subscription.ChatId, subscription.Id), "1", 0).Err()
-		if err != nil {
-			return false, err
-		}
-	}
-
-	return val == 0, nil
-}
-
+// itemAsMessage renders the item in the html subset of telegram, the
+// description is cut to keep the message below the length limit
 func itemAsMessage(item *gofeed.Item) string {
 	var output []string
 
 	if item.Title != "" {
-		output = append(output, fmt.Sprintf("<b>%s</b>\n", item.Title))
+		output = append(output, fmt.Sprintf("<b>%s</b>\n", utils.EscapeHTML(strings.TrimSpace(item.Title))))
 	}
 
-	if item.Description != "" {
-		output = append(output, fmt.Sprintf("%s", item.Description))
+	baseUrl, _ := url.Parse(item.Link)
+
+	if description := utils.SanitizeHTML(item.Description, baseUrl, maxDescriptionLength); description != "" {
+		output = append(output, description)
 	}
 
 	if item.Link != "" {
-		output = append(output, fmt.Sprintf("\n%s", item.Link))
+		output = append(output, fmt.Sprintf("\n%s", utils.EscapeHTML(item.Link)))
 	}
 
 	return strings.Join(output, "\n")
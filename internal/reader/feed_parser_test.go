@@ -1,8 +1,16 @@
 package reader
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/mmcdole/gofeed"
+	"net/http"
+	"net/http/httptest"
+	"rss-telegram/internal/bot"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/fetcher"
+	"rss-telegram/internal/storage"
 	"rss-telegram/internal/subscription"
 	"testing"
 	"time"
@@ -12,7 +20,7 @@ func TestFeedParser(t *testing.T) {
 	t.Logf("Instantiating mock reader handler")
 
 	readerHandler := NewReaderHandler(&ReaderHandlerOptions{
-		RedisDb:             nil,
+		Store:               nil,
 		BotHandler:          nil,
 		SubscriptionHandler: &subscription.SubscriptionHandler{},
 	})
@@ -112,6 +120,243 @@ func TestFeedParser(t *testing.T) {
 	})
 }
 
+func TestNewItems(t *testing.T) {
+	store := storage.NewMemoryStore()
+
+	readerHandler := NewReaderHandler(&ReaderHandlerOptions{
+		Store:               store,
+		SubscriptionHandler: &subscription.SubscriptionHandler{},
+	})
+
+	feedUrl := "https://example.com/feed"
+	first := newTestSubscription(t, feedUrl)
+	second := newTestSubscription(t, feedUrl)
+
+	fetch := func(sub *subscription.Subscription, guids ...string) ([]*gofeed.Item, bool) {
+		feed := &gofeed.Feed{}
+		for _, guid := range guids {
+			feed.Items = append(feed.Items, &gofeed.Item{GUID: guid})
+		}
+
+		sequences, _ := store.AddItems(feedUrl, guids, time.Now())
+		last, _ := store.LastSequence(feedUrl)
+
+		items, firstFetch, err := readerHandler.getNewItems(feed, sequences, last, sub, false)
+		if err != nil {
+			t.Fatalf("Could not get new items: %v", err)
+		}
+
+		// the items count as queued
+		if len(items) > 0 {
+			_ = store.SetCursor(sub.Key(), last)
+		}
+
+		return items, firstFetch
+	}
+
+	t.Run("Test first fetch sends nothing", func(t *testing.T) {
+		items, firstFetch := fetch(first, "1", "2")
+		if !firstFetch || len(items) != 0 {
+			t.Errorf("Expected first fetch without items, got: %t, %d items", firstFetch, len(items))
+		}
+	})
+
+	t.Run("Test new items are detected once", func(t *testing.T) {
+		items, _ := fetch(first, "3", "1", "2", "3")
+		if len(items) != 1 || items[0].GUID != "3" {
+			t.Errorf("Expected item 3, got: %v", items)
+		}
+
+		items, _ = fetch(first, "3", "1", "2")
+		if len(items) != 0 {
+			t.Errorf("Expected no new items, got: %d", len(items))
+		}
+	})
+
+	t.Run("Test subscriptions share the seen items", func(t *testing.T) {
+		items, firstFetch := fetch(second, "4", "3", "1")
+		if !firstFetch || len(items) != 0 {
+			t.Errorf("Expected first fetch without items, got: %t, %d items", firstFetch, len(items))
+		}
+
+		items, _ = fetch(first, "4", "3", "1")
+		if len(items) != 1 || items[0].GUID != "4" {
+			t.Errorf("Expected item 4 for the first subscription, got: %v", items)
+		}
+	})
+
+	t.Run("Test guids of older versions are migrated", func(t *testing.T) {
+		legacy := newTestSubscription(t, feedUrl)
+
+		_, _ = store.MarkFetched(legacy.Key())
+		_ = store.AddGuids(legacy.Key(), []string{"1", "3"})
+
+		items, firstFetch := fetch(legacy, "4", "3", "1")
+		if firstFetch || len(items) != 1 || items[0].GUID != "4" {
+			t.Errorf("Expected item 4 after migration, got: %t, %v", firstFetch, items)
+		}
+
+		guids, _ := store.GetGuids(legacy.Key())
+		if len(guids) != 0 {
+			t.Errorf("Expected migrated guids to be deleted, got: %v", guids)
+		}
+	})
+
+	t.Run("Test items stay new until they are queued", func(t *testing.T) {
+		feed := &gofeed.Feed{Items: []*gofeed.Item{{GUID: "5"}}}
+
+		sequences, _ := store.AddItems(feedUrl, []string{"5"}, time.Now())
+		last, _ := store.LastSequence(feedUrl)
+
+		for i := 0; i < 2; i++ {
+			items, _, err := readerHandler.getNewItems(feed, sequences, last, first, false)
+			if err != nil || len(items) != 1 || items[0].GUID != "5" {
+				t.Errorf("Expected item 5 until the cursor is moved, got: %v (%v)", items, err)
+			}
+		}
+	})
+}
+
+func TestSnooze(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	store := storage.NewMemoryStore()
+
+	subscriptionHandler := subscription.NewSubscriptionHandler(&subscription.SubscriptionHandlerOptions{Store: store})
+	queue := dispatcher.NewQueue(nil, &dispatcher.QueueOptions{Store: store})
+
+	until := time.Now().Add(-time.Minute)
+
+	sub := newTestSubscription(t, server.URL)
+	sub.SnoozedUntil = &until
+
+	_, err := subscriptionHandler.AddSubscription(sub.ChatId, sub)
+	if err != nil {
+		t.Fatalf("Could not add subscription: %v", err)
+	}
+
+	err = subscriptionHandler.BufferMissedItems(sub, []*subscription.BufferedItem{{Title: "A", Link: "https://example.com/a"}})
+	if err != nil {
+		t.Fatalf("Could not buffer missed items: %v", err)
+	}
+
+	readerHandler := NewReaderHandler(&ReaderHandlerOptions{
+		Store:               store,
+		BotHandler:          &bot.BotHandler{Queue: queue},
+		SubscriptionHandler: subscriptionHandler,
+		Fetcher:             fetcher.NewFetcher(&fetcher.FetcherOptions{Store: store}),
+	})
+
+	t.Run("Test missed items are kept if the catch-up can not be queued", func(t *testing.T) {
+		failing := NewReaderHandler(&ReaderHandlerOptions{
+			Store:               store,
+			BotHandler:          &bot.BotHandler{Queue: dispatcher.NewQueue(nil, &dispatcher.QueueOptions{Store: failingQueueStore{store}})},
+			SubscriptionHandler: subscriptionHandler,
+		})
+
+		failing.sendCatchUp(sub)
+
+		items, _ := subscriptionHandler.PeekMissedItems(sub)
+		stored, _ := subscriptionHandler.GetSubscription(sub.Key())
+		if len(items) != 1 || stored.SnoozedUntil == nil {
+			t.Errorf("Expected the snooze and the missed items to be kept, got %d items", len(items))
+		}
+	})
+
+	t.Run("Test the catch-up is sent without new items", func(t *testing.T) {
+		// the subscription was changed after the scheduler loaded it
+		edited := *sub
+		edited.SearchPattern = "edited"
+
+		err = subscriptionHandler.UpdateSubscription(sub.ChatId, &edited)
+		if err != nil {
+			t.Fatalf("Could not update subscription: %v", err)
+		}
+
+		readerHandler.fetchFeed(sub.URL, []*subscription.Subscription{sub})
+
+		if queue.Len() != 1 {
+			t.Errorf("Expected the catch-up summary, got %d messages", queue.Len())
+		}
+
+		resumed, _ := subscriptionHandler.GetSubscription(sub.Key())
+		if resumed == nil || resumed.SnoozedUntil != nil || resumed.SearchPattern != "edited" {
+			t.Errorf("Expected the edited subscription to be resumed, got: %v", resumed)
+		}
+
+		items, _ := subscriptionHandler.PeekMissedItems(sub)
+		if len(items) != 0 {
+			t.Errorf("Expected the queued missed items to be dropped, got %d", len(items))
+		}
+	})
+}
+
+// failingQueueStore fails to queue messages
+type failingQueueStore struct {
+	storage.QueueStore
+}
+
+func (store failingQueueStore) Enqueue(queue storage.Queue, entries [][]byte) error {
+	return errors.New("queue unavailable")
+}
+
+func TestItemId(t *testing.T) {
+	withGuid := &gofeed.Item{GUID: "guid", Link: "https://example.com/a", Title: "A"}
+	withLink := &gofeed.Item{Link: "https://example.com/a", Title: "A"}
+	withoutLink := &gofeed.Item{Title: "A", Published: "Mon, 01 Jan 2024 12:00:00 GMT", Description: "Text"}
+	otherDate := &gofeed.Item{Title: "A", Published: "Tue, 02 Jan 2024 12:00:00 GMT", Description: "Text"}
+
+	if id := itemId(withGuid, subscription.IdentityAutomatic); id != "guid" {
+		t.Errorf("Expected the guid, got: %s", id)
+	}
+
+	if id := itemId(withLink, subscription.IdentityAutomatic); id != "https://example.com/a" {
+		t.Errorf("Expected the link, got: %s", id)
+	}
+
+	if itemId(withoutLink, subscription.IdentityAutomatic) == itemId(otherDate, subscription.IdentityAutomatic) {
+		t.Errorf("Expected items without guid and link to be distinguished by date")
+	}
+
+	regenerated := &gofeed.Item{GUID: "build-2", Link: "https://example.com/a", Title: "A"}
+	if itemId(withGuid, subscription.IdentityContent) != itemId(regenerated, subscription.IdentityContent) {
+		t.Errorf("Expected the content hash to ignore the guid")
+	}
+}
+
+func TestItemFingerprint(t *testing.T) {
+	item := &gofeed.Item{Title: "A", Description: "Some text", Published: "Mon, 01 Jan 2024 00:00:00 GMT"}
+
+	reformatted := &gofeed.Item{Title: " A ", Description: "Some\n  text", Published: "Tue, 02 Jan 2024 00:00:00 GMT"}
+	if itemFingerprint(item) != itemFingerprint(reformatted) {
+		t.Errorf("Expected whitespace and date changes to keep the fingerprint")
+	}
+
+	corrected := &gofeed.Item{Title: "A", Description: "Some corrected text"}
+	if itemFingerprint(item) == itemFingerprint(corrected) {
+		t.Errorf("Expected content changes to change the fingerprint")
+	}
+
+	moved := &gofeed.Item{Title: "A", Description: "Some text", Link: "https://example.com/a"}
+	if itemFingerprint(item) == itemFingerprint(moved) {
+		t.Errorf("Expected link changes to change the fingerprint")
+	}
+
+	// the content is not part of the message
+	withContent := &gofeed.Item{Title: "A", Description: "Some text", Content: "<p>The full article</p>"}
+	if itemFingerprint(item) != itemFingerprint(withContent) {
+		t.Errorf("Expected changes that are not sent to keep the fingerprint")
+	}
+
+	markup := &gofeed.Item{Title: "A", Description: "<p>Some <span class=\"x\">text</span></p>"}
+	if itemFingerprint(item) != itemFingerprint(markup) {
+		t.Errorf("Expected markup that is not sent to keep the fingerprint")
+	}
+}
+
 func getMockFeedItems() []*gofeed.Item {
 	return []*gofeed.Item{
 		{Title: "Breaking News Update", Description: "Get the latest breaking news and updates from around the world.", Link: "https://example.com/breaking-news-update"},
@@ -126,3 +371,56 @@ func getMockFeedItems() []*gofeed.Item {
 		{Title: "Weather Forecast", Description: "Breaking weather news and forecasts for your area and beyond.", Link: "https://example.com/weather-forecast"},
 	}
 }
+
+func TestUpdates(t *testing.T) {
+	store := storage.NewMemoryStore()
+
+	subscriptionHandler := subscription.NewSubscriptionHandler(&subscription.SubscriptionHandlerOptions{Store: store})
+	queue := dispatcher.NewQueue(nil, &dispatcher.QueueOptions{Store: store})
+
+	sub := newTestSubscription(t, "https://example.com/feed")
+	sub.Updates = subscription.UpdatesEdit
+
+	_, err := subscriptionHandler.AddSubscription(sub.ChatId, sub)
+	if err != nil {
+		t.Fatalf("Could not add subscription: %v", err)
+	}
+
+	readerHandler := NewReaderHandler(&ReaderHandlerOptions{
+		Store:               store,
+		BotHandler:          &bot.BotHandler{Queue: queue},
+		SubscriptionHandler: subscriptionHandler,
+	})
+
+	item := &gofeed.Item{GUID: "1", Title: "A", Link: "https://example.com/a"}
+	id := itemId(item, sub.Identity)
+
+	err = subscriptionHandler.SaveDelivery(sub, id, &subscription.Delivery{MessageId: 5, Fingerprint: itemFingerprint(item)})
+	if err != nil {
+		t.Fatalf("Could not save delivery: %v", err)
+	}
+
+	t.Run("Test updates are queued as edits", func(t *testing.T) {
+		updated := &gofeed.Item{GUID: "1", Title: "A corrected", Link: "https://example.com/a"}
+
+		readerHandler.notifyUpdatedItems(&gofeed.Feed{Items: []*gofeed.Item{updated}}, nil, sub)
+
+		entries, _ := store.PeekQueue(storage.DeliveryQueue, "", 10)
+		if len(entries) != 1 {
+			t.Fatalf("Expected the queued edit, got %d messages", len(entries))
+		}
+
+		var message dispatcher.QueuedMessage
+		_ = json.Unmarshal(entries[0].Data, &message)
+		if message.EditMessageId != 5 || message.ItemId != id || message.Fingerprint != itemFingerprint(updated) {
+			t.Errorf("Queued edit is incorrect, got: %+v", message)
+		}
+
+		// the queued update is not queued again by the next fetch
+		readerHandler.notifyUpdatedItems(&gofeed.Feed{Items: []*gofeed.Item{updated}}, nil, sub)
+
+		if queue.Len() != 1 {
+			t.Errorf("Expected the update to be queued once, got %d messages", queue.Len())
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package reader
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/fetcher"
+)
+
+// notifyHealthChange queues a notice for the subscribers once per transition,
+// the short lived degraded state is only visible in /status
+func (readerHandler *ReaderHandler) notifyHealthChange(feedUrl string, previous fetcher.Health, state *fetcher.FeedState) error {
+	log.Info().Msgf("Health of %s changed from %s to %s", feedUrl, previous, state.Health)
+
+	var text string
+
+	switch state.Health {
+	case fetcher.Healthy:
+		if previous == fetcher.Degraded {
+			return nil
+		}
+
+		text = fmt.Sprintf("Feed %s recovered and is fetched again.", feedUrl)
+	case fetcher.Failing:
+		text = fmt.Sprintf("Could not fetch feed from %s %d times in a row, please check if the fetch source is valid.\n\nLast error: %s", feedUrl, state.ConsecutiveFailures, state.LastError)
+	case fetcher.Dead:
+		text = fmt.Sprintf("Feed %s has been failing since %s and is considered dead.\n\nLast error: %s", feedUrl, state.FailingSince.Format("01-02-2006 15:04:05"), state.LastError)
+	case fetcher.Suspended:
+		text = fmt.Sprintf("Feed %s is no longer fetched because it has been dead for too long. Subscribe to it again to retry.\n\nLast error: %s", feedUrl, state.LastError)
+	default:
+		return nil
+	}
+
+	var messages []*dispatcher.QueuedMessage
+	for _, sub := range readerHandler.Scheduler.Subscriptions(feedUrl) {
+		key := sub.Key()
+
+		message := readerHandler.notice(sub.ChatId, text)
+		message.Subscription = &key
+
+		messages = append(messages, message)
+	}
+
+	return readerHandler.Options.BotHandler.Queue.Enqueue(messages)
+}
@@ -0,0 +1,47 @@
+package reader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/mmcdole/gofeed"
+	"rss-telegram/internal/subscription"
+	"strings"
+)
+
+// itemId returns the id an item is recognized by. Items without guid fall
+// back to their link and then to a hash of their title, date and content.
+func itemId(item *gofeed.Item, identity subscription.ItemIdentity) string {
+	if identity == subscription.IdentityContent {
+		return "content:" + hashFields(item.Title, item.Link, item.Description, item.Content)
+	}
+
+	if item.GUID != "" {
+		return item.GUID
+	}
+
+	if link := strings.TrimSpace(item.Link); link != "" {
+		return link
+	}
+
+	return "hash:" + hashFields(item.Title, item.Published, item.Description, item.Content)
+}
+
+// itemNamespace separates the seen items of the identities of a feed, the
+// automatic identity uses the feed url itself
+func itemNamespace(feedUrl string, identity subscription.ItemIdentity) string {
+	if identity == subscription.IdentityAutomatic {
+		return feedUrl
+	}
+
+	return feedUrl + "#" + string(identity)
+}
+
+func hashFields(fields ...string) string {
+	hash := sha256.New()
+	for _, field := range fields {
+		hash.Write([]byte(strings.TrimSpace(field)))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
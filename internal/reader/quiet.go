@@ -0,0 +1,34 @@
+package reader
+
+import (
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/settings"
+	"time"
+)
+
+// chatSettings returns the settings of the chat, the defaults are used if
+// they can not be read
+func (readerHandler *ReaderHandler) chatSettings(chatId int64) *settings.ChatSettings {
+	if readerHandler.Options.SettingsHandler == nil {
+		return &settings.ChatSettings{}
+	}
+
+	chatSettings, err := readerHandler.Options.SettingsHandler.GetChatSettings(chatId)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed reading settings of %d", chatId)
+		return &settings.ChatSettings{}
+	}
+
+	return chatSettings
+}
+
+// notice returns a queued message informing the chat about its feeds, notices
+// can not be held and are only silenced during quiet hours
+func (readerHandler *ReaderHandler) notice(chatId int64, text string) *dispatcher.QueuedMessage {
+	return &dispatcher.QueuedMessage{
+		ChatId:              chatId,
+		Text:                text,
+		DisableNotification: readerHandler.chatSettings(chatId).IsQuiet(time.Now()),
+	}
+}
@@ -2,46 +2,97 @@ package reader
 
 import (
 	"context"
-	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
+	"errors"
 	"github.com/rs/zerolog/log"
+	"net/url"
 	"rss-telegram/internal/bot"
+	"rss-telegram/internal/fetcher"
+	"rss-telegram/internal/settings"
+	"rss-telegram/internal/storage"
 	"rss-telegram/internal/subscription"
+	"rss-telegram/internal/utils"
 	"time"
 )
 
 type ReaderHandlerOptions struct {
-	RedisDb             *redis.Client
+	Store               storage.Store
 	BotHandler          *bot.BotHandler
 	SubscriptionHandler *subscription.SubscriptionHandler
+	SettingsHandler     *settings.SettingsHandler
+	Fetcher             *fetcher.Fetcher
 	Interval            time.Duration
-	WaitTimeout         time.Duration
+	Workers             int
+	// ItemRetention is the number of seen items kept per feed, ItemMaxAge
+	// the time after which seen items are forgotten
+	ItemRetention int
+	ItemMaxAge    time.Duration
+	// MaxItems is the number of items sent per fetch unless a subscription sets
+	// its own, ChatHourlyLimit the number of items sent to a chat per hour
+	MaxItems        int
+	ChatHourlyLimit int
 }
 
 type ReaderHandler struct {
 	Options *ReaderHandlerOptions
 
-	TickerIds map[string]uuid.UUID
-	Tickers   map[uuid.UUID]*SubscriptionTicker
+	Scheduler *Scheduler
+
+	chatLimits *chatLimiter
 
 	Context context.Context
 }
 
 func NewReaderHandler(options *ReaderHandlerOptions) *ReaderHandler {
+	if options.ItemRetention <= 0 {
+		options.ItemRetention = 1000
+	}
+
+	if options.ItemMaxAge <= 0 {
+		options.ItemMaxAge = 30 * 24 * time.Hour
+	}
+
+	if options.MaxItems <= 0 {
+		options.MaxItems = 10
+	}
+
 	readerHandler := &ReaderHandler{
-		Options:   options,
-		TickerIds: make(map[string]uuid.UUID),
-		Tickers:   make(map[uuid.UUID]*SubscriptionTicker),
-		Context:   context.Background(),
+		Options:    options,
+		Context:    context.Background(),
+		chatLimits: newChatLimiter(options.ChatHourlyLimit),
+	}
+
+	schedulerOptions := &SchedulerOptions{
+		Interval: options.Interval,
+		Workers:  options.Workers,
+		Handle:   readerHandler.fetchFeed,
+	}
+
+	if options.Fetcher != nil {
+		schedulerOptions.Reserve = func(feedUrl *url.URL) time.Duration {
+			return options.Fetcher.Reserve(feedUrl.String())
+		}
 	}
 
+	readerHandler.Scheduler = NewScheduler(schedulerOptions)
+
 	eventListener := &subscription.ReaderEventListener{
 		AddSubscription:    readerHandler.AddSubscription,
 		RemoveSubscription: readerHandler.RemoveSubscription,
+		UpdateSubscription: readerHandler.UpdateSubscription,
 	}
 
 	readerHandler.Options.SubscriptionHandler.ReaderEventListener = eventListener
 
+	if readerHandler.Options.Fetcher != nil {
+		readerHandler.Options.Fetcher.Options.OnHealthChange = readerHandler.notifyHealthChange
+		readerHandler.Options.Fetcher.Options.OnRedirect = readerHandler.moveFeed
+	}
+
+	if readerHandler.Options.BotHandler != nil {
+		readerHandler.Options.BotHandler.Queue.Options.Accept = readerHandler.acceptMessage
+		readerHandler.Options.BotHandler.Queue.Options.OnSent = readerHandler.recordDelivery
+	}
+
 	return readerHandler
 }
 
@@ -52,81 +103,98 @@ func (readerHandler *ReaderHandler) AddSubscriptions() error {
 	}
 
 	for _, sub := range subscriptions {
-		readerHandler.AddSubscription(sub)
+		readerHandler.Scheduler.Add(sub)
 	}
 
 	return nil
 }
 
+// AddSubscription adds a new subscription, a suspended feed is fetched again
+// because someone subscribed to it
 func (readerHandler *ReaderHandler) AddSubscription(subscription *subscription.Subscription) {
 	log.Debug().Msgf("Adding subscription %s by %d to reader handler", subscription.URL.String(), subscription.ChatId)
 
-	_, ticker := readerHandler.findExistingTicker(subscription)
-
-	if ticker == nil {
-		ticker = readerHandler.NewSubscriptionTicker(subscription)
-
-		id := uuid.New()
-
-		readerHandler.TickerIds[subscription.URL.String()] = id
-		readerHandler.Tickers[id] = ticker
-		readerHandler.RunSubscriptionTicker(ticker)
-	} else {
-		ticker.Subscriptions = append(ticker.Subscriptions, subscription)
+	err := readerHandler.Options.Fetcher.Unsuspend(utils.CanonicalURL(subscription.URL).String())
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed unsuspending %s", subscription.URL.String())
 	}
 
+	readerHandler.Scheduler.Add(subscription)
 }
 
-func (readerHandler *ReaderHandler) RemoveSubscription(subscription *subscription.Subscription) {
-	log.Debug().Msgf("Removing subscription %s by %d from reader handler", subscription.URL.String(), subscription.ChatId)
+func (readerHandler *ReaderHandler) RemoveSubscription(sub *subscription.Subscription) {
+	log.Debug().Msgf("Removing subscription %s by %d from reader handler", sub.URL.String(), sub.ChatId)
 
-	id, ticker := readerHandler.findExistingTicker(subscription)
-
-	if ticker == nil {
+	if !readerHandler.Scheduler.Remove(sub) {
 		return
 	}
 
-	if len(ticker.Subscriptions) > 1 {
-		for i, sub := range ticker.Subscriptions {
-			if sub.Id != subscription.Id {
-				continue
-			}
-
-			ticker.Subscriptions[i] = ticker.Subscriptions[len(ticker.Subscriptions)-1]
-			ticker.Subscriptions = ticker.Subscriptions[:len(ticker.Subscriptions)-1]
+	feedUrl := utils.CanonicalURL(sub.URL).String()
 
-			log.Info().Msgf("Subscription ticker %s is used by %d other subscriptions", sub.URL.String(), len(ticker.Subscriptions))
+	err := readerHandler.Options.Fetcher.DeleteState(feedUrl)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed deleting fetch state of %s", sub.URL.String())
+	}
 
-			break
+	for _, identity := range subscription.ItemIdentities {
+		err = readerHandler.Options.Store.DeleteItems(itemNamespace(feedUrl, identity))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed deleting seen items of %s", feedUrl)
 		}
-	} else if len(ticker.Subscriptions) == 1 {
-		log.Info().Msgf("Closing subscription ticker %s because no subscriptions are active", ticker.URL.String())
+	}
+}
 
-		close(ticker.Quit)
+func (readerHandler *ReaderHandler) UpdateSubscription(subscription *subscription.Subscription) {
+	log.Debug().Msgf("Updating subscription %s by %d in reader handler", subscription.URL.String(), subscription.ChatId)
 
-		delete(readerHandler.Tickers, id)
-		delete(readerHandler.TickerIds, subscription.URL.String())
-	}
+	readerHandler.Scheduler.Update(subscription)
 }
 
-func (readerHandler *ReaderHandler) findExistingTicker(subscription *subscription.Subscription) (uuid.UUID, *SubscriptionTicker) {
-	id, ok := readerHandler.TickerIds[subscription.URL.String()]
-	if !ok {
-		return uuid.UUID{}, nil
+func (readerHandler *ReaderHandler) RunSubscriptions() {
+	if readerHandler.Options.Fetcher != nil {
+		log.Info().Msgf("Fetch limits: %s", readerHandler.Options.Fetcher.Limits())
 	}
 
-	ticker, ok := readerHandler.Tickers[id]
-	if !ok {
-		return uuid.UUID{}, nil
+	if readerHandler.Options.BotHandler != nil {
+		log.Info().Msgf("%d messages are waiting in the delivery queue", readerHandler.Options.BotHandler.Queue.Len())
+
+		readerHandler.Options.BotHandler.Queue.Run(readerHandler.Options.BotHandler.Options.Context)
+
+		go readerHandler.runDigests(readerHandler.Options.BotHandler.Options.Context)
 	}
 
-	return id, ticker
+	readerHandler.Scheduler.Run(readerHandler.Context)
 }
 
-func (readerHandler *ReaderHandler) RunSubscriptions() {
-	log.Debug().Msgf("Starting %d tickers", len(readerHandler.Tickers))
+func (readerHandler *ReaderHandler) fetchFeed(feedUrl *url.URL, subscriptions []*subscription.Subscription) {
+	// snoozes end on time even if the feed was not modified or is backed off
+	for _, sub := range subscriptions {
+		if sub.SnoozeExpired(time.Now()) {
+			readerHandler.sendCatchUp(sub)
+		}
+	}
+
+	log.Trace().Msgf("Requesting %s's feed", feedUrl.String())
+
+	feed, err := readerHandler.Options.Fetcher.Fetch(readerHandler.Context, feedUrl.String())
 
-	for _, ticker := range readerHandler.Tickers {
-		readerHandler.RunSubscriptionTicker(ticker)
+	if errors.Is(err, fetcher.ErrNotModified) {
+		log.Trace().Msgf("%s's feed was not modified", feedUrl.String())
+	} else if errors.Is(err, fetcher.ErrBackoff) || errors.Is(err, fetcher.ErrSuspended) {
+		log.Trace().Msgf("Skipping %s's feed: %s", feedUrl.String(), err)
+	} else if err != nil {
+		log.Warn().Err(err).Msgf("Error in parsing subscription %s", feedUrl.String())
+	} else {
+		log.Trace().Msgf("Handling %s's feed", feedUrl.String())
+
+		// the subscriptions may have changed or moved to another url during the request
+		subscriptions = readerHandler.Scheduler.Subscriptions(feedUrl.String())
+
+		err = readerHandler.handleFeed(feedUrl.String(), subscriptions, feed)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Error in handling subscription %s", feedUrl.String())
+		}
 	}
+
+	log.Trace().Msgf("Finished fetching %s's feed", feedUrl.String())
 }
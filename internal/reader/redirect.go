@@ -0,0 +1,77 @@
+package reader
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/url"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/subscription"
+	"slices"
+)
+
+// moveFeed points the subscriptions of a permanently redirected feed to its
+// new url. The subscriptions keep their ids, so their seen guids are kept as
+// well. Chats that are already subscribed to the new url lose the old
+// subscription instead.
+func (readerHandler *ReaderHandler) moveFeed(feedUrl string, movedUrl string) {
+	parsedUrl, err := url.Parse(movedUrl)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Ignoring invalid redirect of %s to %s", feedUrl, movedUrl)
+		return
+	}
+
+	var movedChats []int64
+	for _, sub := range readerHandler.Scheduler.Subscriptions(movedUrl) {
+		movedChats = append(movedChats, sub.ChatId)
+	}
+
+	// the cursors stay valid if the items keep their sequence numbers,
+	// otherwise the subscriptions continue at the end of the existing feed
+	itemsMoved := make(map[subscription.ItemIdentity]bool)
+	for _, identity := range subscription.ItemIdentities {
+		itemsMoved[identity], err = readerHandler.Options.Store.MoveItems(itemNamespace(feedUrl, identity), itemNamespace(movedUrl, identity))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed moving seen items of %s", feedUrl)
+		}
+	}
+
+	var notices []*dispatcher.QueuedMessage
+	for _, sub := range readerHandler.Scheduler.Subscriptions(feedUrl) {
+		if slices.Contains(movedChats, sub.ChatId) {
+			log.Info().Msgf("Chat %d is already subscribed to %s, removing subscription of %s", sub.ChatId, movedUrl, feedUrl)
+
+			readerHandler.Options.SubscriptionHandler.DeleteSubscription(sub.ChatId, sub)
+		} else {
+			readerHandler.Scheduler.Remove(sub)
+
+			if !itemsMoved[sub.Identity] {
+				last, err := readerHandler.Options.Store.LastSequence(itemNamespace(movedUrl, sub.Identity))
+				if err == nil {
+					err = readerHandler.Options.Store.SetCursor(sub.Key(), last)
+				}
+				if err != nil {
+					log.Warn().Err(err).Msgf("Failed moving cursor of %s of %d", feedUrl, sub.ChatId)
+				}
+			}
+
+			updated := *sub
+			updated.URL = parsedUrl
+
+			err = readerHandler.Options.SubscriptionHandler.UpdateSubscription(sub.ChatId, &updated)
+			if err != nil {
+				log.Warn().Err(err).Msgf("Failed moving subscription %s of %d to %s", feedUrl, sub.ChatId, movedUrl)
+				continue
+			}
+
+			movedChats = append(movedChats, sub.ChatId)
+		}
+
+		// the notice is not bound to the subscription, it may have been removed
+		notices = append(notices, readerHandler.notice(sub.ChatId, fmt.Sprintf("Feed %s moved permanently to %s, your subscription was updated.", feedUrl, movedUrl)))
+	}
+
+	err = readerHandler.Options.BotHandler.Queue.Enqueue(notices)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed queueing the move of %s to %s", feedUrl, movedUrl)
+	}
+}
@@ -0,0 +1,364 @@
+package reader
+
+import (
+	"container/heap"
+	"context"
+	"github.com/rs/zerolog/log"
+	"math/rand/v2"
+	"net/url"
+	"rss-telegram/internal/subscription"
+	"rss-telegram/internal/utils"
+	"slices"
+	"sync"
+	"time"
+)
+
+type SchedulerOptions struct {
+	Interval time.Duration
+	Workers  int
+	// Handle is called by the workers for every due feed
+	Handle func(feedUrl *url.URL, subscriptions []*subscription.Subscription)
+	// Reserve is asked before a due feed is handed to a worker, a positive
+	// duration delays the feed by that time
+	Reserve func(feedUrl *url.URL) time.Duration
+}
+
+// ScheduledFeed is shared by all subscriptions of a feed url
+type ScheduledFeed struct {
+	URL           *url.URL
+	Subscriptions []*subscription.Subscription
+	Interval      time.Duration
+	NextFetch     time.Time
+	InRequest     bool
+
+	index int
+}
+
+// Scheduler fetches every feed once per interval with a bounded number of
+// workers, the feeds are ordered by their next fetch time
+type Scheduler struct {
+	Options *SchedulerOptions
+
+	feeds map[string]*ScheduledFeed
+	queue feedQueue
+	lock  sync.Mutex
+
+	wake chan struct{}
+	jobs chan *ScheduledFeed
+	once sync.Once
+}
+
+func NewScheduler(options *SchedulerOptions) *Scheduler {
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+
+	if options.Interval <= 0 {
+		options.Interval = time.Minute
+	}
+
+	return &Scheduler{
+		Options: options,
+		feeds:   make(map[string]*ScheduledFeed),
+		wake:    make(chan struct{}, 1),
+		jobs:    make(chan *ScheduledFeed),
+	}
+}
+
+// Run starts the scheduler loop and the workers once, they stop with the context
+func (scheduler *Scheduler) Run(ctx context.Context) {
+	scheduler.once.Do(func() {
+		log.Debug().Msgf("Starting scheduler with %d feeds and %d workers", scheduler.Len(), scheduler.Options.Workers)
+
+		for i := 0; i < scheduler.Options.Workers; i++ {
+			go scheduler.work(ctx)
+		}
+
+		go scheduler.loop(ctx)
+	})
+}
+
+func (scheduler *Scheduler) Len() int {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	return len(scheduler.feeds)
+}
+
+// Add schedules the subscription, new feeds start after a random part of
+// their interval to avoid fetching every feed at once. Subscriptions share
+// the feed of their canonical url.
+func (scheduler *Scheduler) Add(sub *subscription.Subscription) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	feedUrl := utils.CanonicalURL(sub.URL)
+
+	feed, ok := scheduler.feeds[feedUrl.String()]
+	if ok {
+		feed.Subscriptions = slices.DeleteFunc(slices.Clone(feed.Subscriptions), func(s *subscription.Subscription) bool {
+			return s.Id == sub.Id
+		})
+		feed.Subscriptions = append(feed.Subscriptions, sub)
+		scheduler.adjustInterval(feed)
+		return
+	}
+
+	interval := scheduler.subscriptionInterval(sub)
+
+	feed = &ScheduledFeed{
+		URL:           feedUrl,
+		Subscriptions: []*subscription.Subscription{sub},
+		Interval:      interval,
+		NextFetch:     time.Now().Add(rand.N(interval)),
+	}
+
+	log.Info().Msgf("Scheduling feed %s by %d every %s", feedUrl.String(), sub.ChatId, interval)
+
+	scheduler.feeds[feedUrl.String()] = feed
+	heap.Push(&scheduler.queue, feed)
+	scheduler.notify()
+}
+
+// Remove unschedules the subscription and reports whether the feed has no subscriptions left
+func (scheduler *Scheduler) Remove(sub *subscription.Subscription) bool {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	feedUrl := utils.CanonicalURL(sub.URL).String()
+
+	feed, ok := scheduler.feeds[feedUrl]
+	if !ok {
+		return false
+	}
+
+	feed.Subscriptions = slices.DeleteFunc(slices.Clone(feed.Subscriptions), func(s *subscription.Subscription) bool {
+		return s.Id == sub.Id
+	})
+
+	if len(feed.Subscriptions) > 0 {
+		log.Info().Msgf("Feed %s is used by %d other subscriptions", feed.URL.String(), len(feed.Subscriptions))
+
+		scheduler.adjustInterval(feed)
+		return false
+	}
+
+	log.Info().Msgf("Unscheduling feed %s because no subscriptions are active", feed.URL.String())
+
+	delete(scheduler.feeds, feedUrl)
+
+	if feed.index >= 0 {
+		heap.Remove(&scheduler.queue, feed.index)
+	}
+
+	return true
+}
+
+// Update replaces the subscription with the same id
+func (scheduler *Scheduler) Update(sub *subscription.Subscription) {
+	scheduler.lock.Lock()
+
+	feed, ok := scheduler.feeds[utils.CanonicalURL(sub.URL).String()]
+	if !ok {
+		scheduler.lock.Unlock()
+		scheduler.Add(sub)
+		return
+	}
+
+	defer scheduler.lock.Unlock()
+
+	subscriptions := slices.Clone(feed.Subscriptions)
+
+	i := slices.IndexFunc(subscriptions, func(s *subscription.Subscription) bool {
+		return s.Id == sub.Id
+	})
+
+	if i >= 0 {
+		subscriptions[i] = sub
+	} else {
+		subscriptions = append(subscriptions, sub)
+	}
+
+	feed.Subscriptions = subscriptions
+	scheduler.adjustInterval(feed)
+}
+
+// Subscriptions returns the subscriptions of a canonical feed url, the slice is never
+// modified after it was handed out
+func (scheduler *Scheduler) Subscriptions(feedUrl string) []*subscription.Subscription {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	feed, ok := scheduler.feeds[feedUrl]
+	if !ok {
+		return nil
+	}
+
+	return feed.Subscriptions
+}
+
+func (scheduler *Scheduler) subscriptionInterval(sub *subscription.Subscription) time.Duration {
+	if sub.Interval > 0 {
+		return sub.Interval
+	}
+
+	return scheduler.Options.Interval
+}
+
+// adjustInterval lets the feed run at the shortest interval requested by its subscriptions
+func (scheduler *Scheduler) adjustInterval(feed *ScheduledFeed) {
+	interval := scheduler.subscriptionInterval(feed.Subscriptions[0])
+	for _, sub := range feed.Subscriptions[1:] {
+		interval = min(interval, scheduler.subscriptionInterval(sub))
+	}
+
+	if interval == feed.Interval {
+		return
+	}
+
+	log.Info().Msgf("Changing interval of feed %s from %s to %s", feed.URL.String(), feed.Interval, interval)
+
+	if interval < feed.Interval && feed.index >= 0 {
+		next := time.Now().Add(interval)
+		if next.Before(feed.NextFetch) {
+			feed.NextFetch = next
+		}
+
+		heap.Fix(&scheduler.queue, feed.index)
+		scheduler.notify()
+	}
+
+	feed.Interval = interval
+}
+
+func (scheduler *Scheduler) notify() {
+	select {
+	case scheduler.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (scheduler *Scheduler) loop(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		scheduler.lock.Lock()
+
+		wait := time.Hour
+		var due *ScheduledFeed
+
+		if len(scheduler.queue) > 0 {
+			wait = time.Until(scheduler.queue[0].NextFetch)
+
+			if wait <= 0 && scheduler.Options.Reserve != nil {
+				feed := scheduler.queue[0]
+
+				delay := scheduler.Options.Reserve(feed.URL)
+				if delay > 0 {
+					log.Debug().Msgf("Delaying feed %s by %s because its host was requested recently", feed.URL.String(), delay)
+
+					feed.NextFetch = time.Now().Add(delay)
+					heap.Fix(&scheduler.queue, 0)
+					scheduler.lock.Unlock()
+					continue
+				}
+			}
+
+			if wait <= 0 {
+				due = heap.Pop(&scheduler.queue).(*ScheduledFeed)
+				due.InRequest = true
+			}
+		}
+
+		scheduler.lock.Unlock()
+
+		if due != nil {
+			select {
+			case scheduler.jobs <- due:
+			case <-ctx.Done():
+				return
+			}
+
+			continue
+		}
+
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-scheduler.wake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (scheduler *Scheduler) work(ctx context.Context) {
+	for {
+		select {
+		case feed := <-scheduler.jobs:
+			scheduler.lock.Lock()
+			subscriptions := feed.Subscriptions
+			scheduler.lock.Unlock()
+
+			start := time.Now()
+
+			scheduler.Options.Handle(feed.URL, subscriptions)
+
+			scheduler.reschedule(feed, start)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (scheduler *Scheduler) reschedule(feed *ScheduledFeed, start time.Time) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	feed.InRequest = false
+
+	if scheduler.feeds[feed.URL.String()] != feed {
+		return
+	}
+
+	feed.NextFetch = start.Add(feed.Interval)
+	heap.Push(&scheduler.queue, feed)
+	scheduler.notify()
+}
+
+type feedQueue []*ScheduledFeed
+
+func (queue feedQueue) Len() int {
+	return len(queue)
+}
+
+func (queue feedQueue) Less(i, j int) bool {
+	return queue[i].NextFetch.Before(queue[j].NextFetch)
+}
+
+func (queue feedQueue) Swap(i, j int) {
+	queue[i], queue[j] = queue[j], queue[i]
+	queue[i].index = i
+	queue[j].index = j
+}
+
+func (queue *feedQueue) Push(x any) {
+	feed := x.(*ScheduledFeed)
+	feed.index = len(*queue)
+	*queue = append(*queue, feed)
+}
+
+func (queue *feedQueue) Pop() any {
+	old := *queue
+	feed := old[len(old)-1]
+	old[len(old)-1] = nil
+	feed.index = -1
+	*queue = old[:len(old)-1]
+
+	return feed
+}
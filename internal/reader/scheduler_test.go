@@ -0,0 +1,215 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"net/url"
+	"rss-telegram/internal/subscription"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestSubscription(t *testing.T, feedUrl string) *subscription.Subscription {
+	parsed, err := url.Parse(feedUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &subscription.Subscription{
+		Id:     uuid.New(),
+		ChatId: 1,
+		URL:    parsed,
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	t.Run("Test every feed is fetched without overlapping requests", func(t *testing.T) {
+		var lock sync.Mutex
+		fetches := make(map[string]int)
+		inRequest := make(map[string]bool)
+		var overlaps atomic.Int64
+
+		scheduler := NewScheduler(&SchedulerOptions{
+			Interval: 20 * time.Millisecond,
+			Workers:  4,
+			Handle: func(feedUrl *url.URL, subscriptions []*subscription.Subscription) {
+				lock.Lock()
+				if inRequest[feedUrl.String()] {
+					overlaps.Add(1)
+				}
+				inRequest[feedUrl.String()] = true
+				lock.Unlock()
+
+				time.Sleep(5 * time.Millisecond)
+
+				lock.Lock()
+				inRequest[feedUrl.String()] = false
+				fetches[feedUrl.String()]++
+				lock.Unlock()
+			},
+		})
+
+		for i := 0; i < 20; i++ {
+			scheduler.Add(newTestSubscription(t, fmt.Sprintf("https://example.com/%d.xml", i)))
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		scheduler.Run(ctx)
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		for i := 0; i < 20; i++ {
+			if fetches[fmt.Sprintf("https://example.com/%d.xml", i)] == 0 {
+				t.Errorf("Feed %d was never fetched", i)
+			}
+		}
+
+		if overlaps.Load() > 0 {
+			t.Errorf("Expected no overlapping requests, got %d", overlaps.Load())
+		}
+	})
+
+	t.Run("Test removed feeds are not fetched", func(t *testing.T) {
+		var fetches atomic.Int64
+
+		scheduler := NewScheduler(&SchedulerOptions{
+			Interval: 10 * time.Millisecond,
+			Workers:  2,
+			Handle: func(feedUrl *url.URL, subscriptions []*subscription.Subscription) {
+				fetches.Add(1)
+			},
+		})
+
+		first := newTestSubscription(t, "https://example.com/feed.xml")
+		second := newTestSubscription(t, "https://Example.com:443/feed.xml?utm_source=test")
+
+		scheduler.Add(first)
+		scheduler.Add(second)
+
+		if len(scheduler.Subscriptions("https://example.com/feed.xml")) != 2 {
+			t.Fatalf("Expected both spellings of the url to share the feed")
+		}
+
+		if scheduler.Remove(first) {
+			t.Errorf("Expected feed to be kept for the second subscription")
+		}
+
+		if !scheduler.Remove(second) {
+			t.Errorf("Expected feed to be unscheduled with the last subscription")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		scheduler.Run(ctx)
+		time.Sleep(50 * time.Millisecond)
+
+		if fetches.Load() != 0 {
+			t.Errorf("Expected no fetches, got %d", fetches.Load())
+		}
+	})
+
+	t.Run("Test concurrent changes while running", func(t *testing.T) {
+		scheduler := NewScheduler(&SchedulerOptions{
+			Interval: time.Millisecond,
+			Workers:  4,
+			Handle: func(feedUrl *url.URL, subscriptions []*subscription.Subscription) {
+				for _, sub := range subscriptions {
+					_ = sub.Interval
+				}
+			},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		scheduler.Run(ctx)
+
+		var wait sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wait.Add(1)
+
+			go func() {
+				defer wait.Done()
+
+				for j := 0; j < 50; j++ {
+					sub := newTestSubscription(t, fmt.Sprintf("https://example.com/%d.xml", j%5))
+
+					scheduler.Add(sub)
+
+					updated := *sub
+					updated.Interval = time.Duration(j+1) * time.Millisecond
+					scheduler.Update(&updated)
+
+					scheduler.Remove(&updated)
+				}
+			}()
+		}
+
+		wait.Wait()
+
+		if scheduler.Len() != 0 {
+			t.Errorf("Expected every feed to be unscheduled, got %d", scheduler.Len())
+		}
+	})
+
+	t.Run("Test feeds use the shortest interval of their subscriptions", func(t *testing.T) {
+		scheduler := NewScheduler(&SchedulerOptions{
+			Interval: 10 * time.Minute,
+			Handle:   func(feedUrl *url.URL, subscriptions []*subscription.Subscription) {},
+		})
+
+		feedUrl := "https://example.com/feed.xml"
+
+		interval := func() time.Duration {
+			scheduler.lock.Lock()
+			defer scheduler.lock.Unlock()
+
+			return scheduler.feeds[feedUrl].Interval
+		}
+
+		hourly := newTestSubscription(t, feedUrl)
+		hourly.Interval = time.Hour
+
+		scheduler.Add(hourly)
+		if interval() != time.Hour {
+			t.Errorf("Expected the interval of the subscription, got %s", interval())
+		}
+
+		fallback := newTestSubscription(t, feedUrl)
+
+		scheduler.Add(fallback)
+		if interval() != 10*time.Minute {
+			t.Errorf("Expected the shorter default interval, got %s", interval())
+		}
+
+		frequent := *hourly
+		frequent.Interval = time.Minute
+
+		scheduler.Update(&frequent)
+		if interval() != time.Minute {
+			t.Errorf("Expected the updated interval, got %s", interval())
+		}
+
+		scheduler.lock.Lock()
+		next := scheduler.feeds[feedUrl].NextFetch
+		scheduler.lock.Unlock()
+
+		if time.Until(next) > time.Minute {
+			t.Errorf("Expected the next fetch to move within the shorter interval, got %s", time.Until(next))
+		}
+
+		scheduler.Remove(&frequent)
+		if interval() != 10*time.Minute {
+			t.Errorf("Expected the default interval after the removal, got %s", interval())
+		}
+	})
+}
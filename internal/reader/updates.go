@@ -0,0 +1,145 @@
+package reader
+
+import (
+	"errors"
+	"github.com/go-telegram/bot/models"
+	"github.com/mmcdole/gofeed"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/dispatcher"
+	"rss-telegram/internal/storage"
+	"rss-telegram/internal/subscription"
+	"slices"
+	"strings"
+	"time"
+)
+
+// fingerprintPrefix marks fingerprints of the rendered message, older
+// fingerprints of the raw item fields are replaced without announcing an update
+const fingerprintPrefix = "message:"
+
+// itemFingerprint hashes the message of an item exactly as it is sent, so an
+// item is only updated if its message changes. Changes of the whitespace or of
+// the dates are no meaningful update.
+func itemFingerprint(item *gofeed.Item) string {
+	return fingerprintPrefix + hashFields(normalizeSpace(itemAsMessage(item)))
+}
+
+func normalizeSpace(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// recordDelivery remembers the message of a sent item for subscriptions that announce updates
+func (readerHandler *ReaderHandler) recordDelivery(message *dispatcher.QueuedMessage, sent *models.Message) {
+	if message.ItemId == "" || message.Subscription == nil || sent == nil {
+		return
+	}
+
+	sub, err := readerHandler.Options.SubscriptionHandler.GetSubscription(*message.Subscription)
+	if err != nil {
+		return
+	}
+
+	err = readerHandler.Options.SubscriptionHandler.SaveDelivery(sub, message.ItemId, &subscription.Delivery{
+		MessageId:   sent.ID,
+		Fingerprint: message.Fingerprint,
+	})
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed saving delivery of %s for %d", message.ItemId, sub.ChatId)
+	}
+}
+
+// acceptMessage discards queued messages of subscriptions that were removed in the meantime
+func (readerHandler *ReaderHandler) acceptMessage(message *dispatcher.QueuedMessage) bool {
+	if message.Subscription == nil {
+		return true
+	}
+
+	_, err := readerHandler.Options.SubscriptionHandler.GetSubscription(*message.Subscription)
+
+	return !errors.Is(err, storage.ErrNotFound)
+}
+
+// notifyUpdatedItems compares the delivered items of the feed with their
+// fingerprints and edits or replies to the messages of changed items. Items
+// identified by their content hash are new items when they change.
+func (readerHandler *ReaderHandler) notifyUpdatedItems(feed *gofeed.Feed, newItems []*gofeed.Item, sub *subscription.Subscription) {
+	if sub.Updates == subscription.UpdatesIgnore {
+		return
+	}
+
+	ids := make([]string, len(feed.Items))
+	for i, item := range feed.Items {
+		ids[i] = itemId(item, sub.Identity)
+	}
+
+	deliveries, err := readerHandler.Options.SubscriptionHandler.GetDeliveries(sub, ids)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed reading deliveries of %s for %d", sub.URL.String(), sub.ChatId)
+		return
+	}
+
+	for i, item := range feed.Items {
+		delivery, ok := deliveries[ids[i]]
+		if !ok || slices.Contains(newItems, item) {
+			continue
+		}
+
+		fingerprint := itemFingerprint(item)
+		if delivery.Fingerprint == fingerprint {
+			continue
+		}
+
+		if !strings.HasPrefix(delivery.Fingerprint, fingerprintPrefix) {
+			delivery.Fingerprint = fingerprint
+
+			err = readerHandler.Options.SubscriptionHandler.SaveDelivery(sub, ids[i], delivery)
+			if err != nil {
+				log.Warn().Err(err).Msgf("Failed saving delivery of %s for %d", ids[i], sub.ChatId)
+			}
+
+			continue
+		}
+
+		log.Debug().Msgf("Item %s of %s was updated for %d", ids[i], sub.URL.String(), sub.ChatId)
+
+		key := sub.Key()
+		message := &dispatcher.QueuedMessage{
+			ChatId:       sub.ChatId,
+			Subscription: &key,
+			ParseMode:    models.ParseModeHTML,
+			ItemId:       ids[i],
+			Fingerprint:  fingerprint,
+		}
+
+		switch sub.Updates {
+		case subscription.UpdatesEdit:
+			message.Text = itemAsMessage(item)
+			message.EditMessageId = delivery.MessageId
+		case subscription.UpdatesReply:
+			message.Text = "<b>Updated:</b> " + itemAsMessage(item)
+			message.ReplyTo = delivery.MessageId
+			// replies can not be held, they are only silenced during quiet hours
+			message.DisableNotification = readerHandler.chatSettings(sub.ChatId).IsQuiet(time.Now())
+		}
+
+		err = readerHandler.Options.BotHandler.Queue.Enqueue([]*dispatcher.QueuedMessage{message})
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed queueing update of %s for %d", ids[i], sub.ChatId)
+			continue
+		}
+
+		// the new fingerprint is kept once the update is queued so it is not
+		// queued again on every fetch, the message is recorded once it was sent
+		delivery.Fingerprint = fingerprint
+
+		err = readerHandler.Options.SubscriptionHandler.SaveDelivery(sub, ids[i], delivery)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed saving delivery of %s for %d", ids[i], sub.ChatId)
+		}
+	}
+
+	err = readerHandler.Options.SubscriptionHandler.PruneDeliveries(sub, ids)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed pruning deliveries of %s for %d", sub.URL.String(), sub.ChatId)
+	}
+}
@@ -0,0 +1,283 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/storage"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuietMode selects what happens to items that arrive during quiet hours
+type QuietMode string
+
+const (
+	QuietHold   QuietMode = ""
+	QuietSilent QuietMode = "silent"
+)
+
+var QuietModes = []QuietMode{QuietHold, QuietSilent}
+
+func (mode QuietMode) String() string {
+	switch mode {
+	case QuietSilent:
+		return "Send silently"
+	default:
+		return "Hold until the end"
+	}
+}
+
+// ParseQuietMode accepts the names returned by String
+func ParseQuietMode(value string) (QuietMode, error) {
+	for _, mode := range QuietModes {
+		if mode.String() == value {
+			return mode, nil
+		}
+	}
+
+	return QuietHold, fmt.Errorf("unknown quiet mode %s", value)
+}
+
+// ChatSettings apply to every subscription of a chat
+type ChatSettings struct {
+	// TimeZone is an IANA time zone name, UTC is used if it is empty
+	TimeZone string `json:"timeZone,omitempty"`
+	// QuietStart and QuietEnd are times of day like 22:00, quiet hours are
+	// disabled if they are empty
+	QuietStart string    `json:"quietStart,omitempty"`
+	QuietEnd   string    `json:"quietEnd,omitempty"`
+	QuietMode  QuietMode `json:"quietMode,omitempty"`
+
+	location *time.Location
+}
+
+type SettingsHandlerOptions struct {
+	Store storage.Store
+	// CacheTTL is the time settings are cached, settings written by another
+	// instance are seen after it. CacheSize limits the number of cached chats.
+	CacheTTL  time.Duration
+	CacheSize int
+}
+
+type SettingsHandler struct {
+	Options *SettingsHandlerOptions
+
+	cache map[int64]*cachedSettings
+	lock  sync.Mutex
+}
+
+type cachedSettings struct {
+	settings *ChatSettings
+	expires  time.Time
+}
+
+func NewSettingsHandler(options *SettingsHandlerOptions) *SettingsHandler {
+	if options.CacheTTL <= 0 {
+		options.CacheTTL = time.Minute
+	}
+
+	if options.CacheSize <= 0 {
+		options.CacheSize = 10000
+	}
+
+	return &SettingsHandler{
+		Options: options,
+		cache:   make(map[int64]*cachedSettings),
+	}
+}
+
+// GetChatSettings returns the settings of a chat, chats without settings get
+// the defaults. The returned settings must not be modified.
+func (settingsHandler *SettingsHandler) GetChatSettings(chatId int64) (*ChatSettings, error) {
+	settingsHandler.lock.Lock()
+	cached, ok := settingsHandler.cache[chatId]
+	settingsHandler.lock.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.settings, nil
+	}
+
+	settings := &ChatSettings{}
+
+	data, err := settingsHandler.Options.Store.GetChatSettings(chatId)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	if err == nil {
+		err = json.Unmarshal(data, settings)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Discarding unreadable settings of %d", chatId)
+			settings = &ChatSettings{}
+		}
+	}
+
+	settings.resolveLocation()
+	settingsHandler.store(chatId, settings)
+
+	return settings, nil
+}
+
+// SaveChatSettings replaces the settings of a chat, the passed settings must
+// not be modified afterwards
+func (settingsHandler *SettingsHandler) SaveChatSettings(chatId int64, settings *ChatSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	err = settingsHandler.Options.Store.SaveChatSettings(chatId, data)
+	if err != nil {
+		return err
+	}
+
+	settings.resolveLocation()
+	settingsHandler.store(chatId, settings)
+
+	log.Info().Msgf("Chat %d changed its settings to %s", chatId, settings)
+
+	return nil
+}
+
+// store caches the settings, expired entries are removed once the cache is
+// full and all entries if that is not enough
+func (settingsHandler *SettingsHandler) store(chatId int64, settings *ChatSettings) {
+	settingsHandler.lock.Lock()
+	defer settingsHandler.lock.Unlock()
+
+	now := time.Now()
+
+	if len(settingsHandler.cache) >= settingsHandler.Options.CacheSize {
+		for key, cached := range settingsHandler.cache {
+			if !now.Before(cached.expires) {
+				delete(settingsHandler.cache, key)
+			}
+		}
+
+		if len(settingsHandler.cache) >= settingsHandler.Options.CacheSize {
+			clear(settingsHandler.cache)
+		}
+	}
+
+	settingsHandler.cache[chatId] = &cachedSettings{settings: settings, expires: now.Add(settingsHandler.Options.CacheTTL)}
+}
+
+// ParseTimeZone accepts IANA time zone names like Europe/Berlin
+func ParseTimeZone(value string) (string, error) {
+	if value == "" || strings.EqualFold(value, "local") {
+		return "", fmt.Errorf("invalid time zone %s", value)
+	}
+
+	location, err := time.LoadLocation(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid time zone %s", value)
+	}
+
+	return location.String(), nil
+}
+
+// ParseQuietHours accepts a range of times of day like 22:00-07:00
+func ParseQuietHours(value string) (string, string, error) {
+	start, end, ok := strings.Cut(strings.ReplaceAll(value, " ", ""), "-")
+	if !ok {
+		return "", "", fmt.Errorf("invalid quiet hours %s", value)
+	}
+
+	parsedStart, err := time.Parse("15:04", start)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid quiet hours %s", value)
+	}
+
+	parsedEnd, err := time.Parse("15:04", end)
+	if err != nil || parsedEnd.Equal(parsedStart) {
+		return "", "", fmt.Errorf("invalid quiet hours %s", value)
+	}
+
+	return parsedStart.Format("15:04"), parsedEnd.Format("15:04"), nil
+}
+
+// Location returns the time zone of the chat, UTC if none or an unknown one is
+// set. The time zone of stored settings is only loaded once.
+func (settings *ChatSettings) Location() *time.Location {
+	if settings.location != nil && settings.location.String() == settings.TimeZone {
+		return settings.location
+	}
+
+	return loadLocation(settings.TimeZone)
+}
+
+func (settings *ChatSettings) resolveLocation() {
+	settings.location = loadLocation(settings.TimeZone)
+}
+
+func loadLocation(timeZone string) *time.Location {
+	if timeZone == "" {
+		return time.UTC
+	}
+
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return location
+}
+
+func (settings *ChatSettings) HasQuietHours() bool {
+	return settings.QuietStart != "" && settings.QuietEnd != ""
+}
+
+// IsQuiet reports whether now is within the quiet hours of the chat, the
+// range may cross midnight
+func (settings *ChatSettings) IsQuiet(now time.Time) bool {
+	if !settings.HasQuietHours() {
+		return false
+	}
+
+	start, err := time.Parse("15:04", settings.QuietStart)
+	if err != nil {
+		return false
+	}
+
+	end, err := time.Parse("15:04", settings.QuietEnd)
+	if err != nil {
+		return false
+	}
+
+	local := now.In(settings.Location())
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	if startMinute < endMinute {
+		return minute >= startMinute && minute < endMinute
+	}
+
+	return minute >= startMinute || minute < endMinute
+}
+
+// Holds reports whether items have to be held back at now
+func (settings *ChatSettings) Holds(now time.Time) bool {
+	return settings.QuietMode == QuietHold && settings.IsQuiet(now)
+}
+
+// Silences reports whether messages have to be sent without notification at now
+func (settings *ChatSettings) Silences(now time.Time) bool {
+	return settings.QuietMode == QuietSilent && settings.IsQuiet(now)
+}
+
+func (settings *ChatSettings) String() string {
+	timeZone := "UTC"
+	if settings.TimeZone != "" {
+		timeZone = settings.TimeZone
+	}
+
+	if !settings.HasQuietHours() {
+		return fmt.Sprintf("time zone %s, no quiet hours", timeZone)
+	}
+
+	return fmt.Sprintf("time zone %s, quiet hours %s-%s (%s)", timeZone, settings.QuietStart, settings.QuietEnd, strings.ToLower(settings.QuietMode.String()))
+}
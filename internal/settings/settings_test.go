@@ -0,0 +1,122 @@
+package settings
+
+import (
+	"rss-telegram/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestChatSettings(t *testing.T) {
+	t.Run("Test quiet hours", func(t *testing.T) {
+		berlin, err := time.LoadLocation("Europe/Berlin")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			settings *ChatSettings
+			now      time.Time
+			expected bool
+		}{
+			{&ChatSettings{}, time.Date(2024, 1, 3, 3, 0, 0, 0, time.UTC), false},
+			{&ChatSettings{QuietStart: "22:00", QuietEnd: "07:00"}, time.Date(2024, 1, 3, 3, 0, 0, 0, time.UTC), true},
+			{&ChatSettings{QuietStart: "22:00", QuietEnd: "07:00"}, time.Date(2024, 1, 3, 22, 0, 0, 0, time.UTC), true},
+			{&ChatSettings{QuietStart: "22:00", QuietEnd: "07:00"}, time.Date(2024, 1, 3, 7, 0, 0, 0, time.UTC), false},
+			{&ChatSettings{QuietStart: "22:00", QuietEnd: "07:00"}, time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC), false},
+			{&ChatSettings{QuietStart: "12:00", QuietEnd: "14:00"}, time.Date(2024, 1, 3, 13, 0, 0, 0, time.UTC), true},
+			{&ChatSettings{QuietStart: "12:00", QuietEnd: "14:00"}, time.Date(2024, 1, 3, 23, 0, 0, 0, time.UTC), false},
+			// 06:30 UTC is 07:30 in Berlin
+			{&ChatSettings{TimeZone: "Europe/Berlin", QuietStart: "22:00", QuietEnd: "07:00"}, time.Date(2024, 1, 3, 6, 30, 0, 0, time.UTC), false},
+			{&ChatSettings{TimeZone: "Europe/Berlin", QuietStart: "22:00", QuietEnd: "07:00"}, time.Date(2024, 1, 3, 5, 30, 0, 0, berlin), true},
+		}
+
+		for _, test := range tests {
+			if quiet := test.settings.IsQuiet(test.now); quiet != test.expected {
+				t.Errorf("Expected %s to be quiet at %s: %t, got %t", test.settings, test.now, test.expected, quiet)
+			}
+		}
+	})
+
+	t.Run("Test parsing settings", func(t *testing.T) {
+		start, end, err := ParseQuietHours("22:00 - 7:30")
+		if err != nil || start != "22:00" || end != "07:30" {
+			t.Errorf("Expected quiet hours 22:00-07:30, got %s-%s (%v)", start, end, err)
+		}
+
+		for _, value := range []string{"22:00", "22:00-22:00", "25:00-07:00", "-"} {
+			if _, _, err = ParseQuietHours(value); err == nil {
+				t.Errorf("Expected %s to be invalid quiet hours", value)
+			}
+		}
+
+		timeZone, err := ParseTimeZone("America/New_York")
+		if err != nil || timeZone != "America/New_York" {
+			t.Errorf("Expected time zone America/New_York, got %s (%v)", timeZone, err)
+		}
+
+		for _, value := range []string{"", "Local", "Mars/Olympus"} {
+			if _, err = ParseTimeZone(value); err == nil {
+				t.Errorf("Expected %s to be an invalid time zone", value)
+			}
+		}
+	})
+
+	t.Run("Test settings are stored", func(t *testing.T) {
+		store := storage.NewMemoryStore()
+
+		settingsHandler := NewSettingsHandler(&SettingsHandlerOptions{Store: store})
+
+		current, err := settingsHandler.GetChatSettings(1)
+		if err != nil || current.TimeZone != "" || current.HasQuietHours() || current.Location() != time.UTC {
+			t.Errorf("Expected default settings, got %s (%v)", current, err)
+		}
+
+		err = settingsHandler.SaveChatSettings(1, &ChatSettings{TimeZone: "Europe/Berlin", QuietStart: "22:00", QuietEnd: "07:00", QuietMode: QuietSilent})
+		if err != nil {
+			t.Fatalf("Could not save settings: %v", err)
+		}
+
+		current, err = NewSettingsHandler(&SettingsHandlerOptions{Store: store}).GetChatSettings(1)
+		if err != nil || current.TimeZone != "Europe/Berlin" || current.QuietMode != QuietSilent {
+			t.Errorf("Expected the stored settings, got %s (%v)", current, err)
+		}
+
+		if current.Location().String() != "Europe/Berlin" {
+			t.Errorf("Expected the time zone of the stored settings, got %s", current.Location())
+		}
+	})
+
+	t.Run("Test cached settings expire", func(t *testing.T) {
+		store := storage.NewMemoryStore()
+
+		settingsHandler := NewSettingsHandler(&SettingsHandlerOptions{Store: store, CacheTTL: 50 * time.Millisecond, CacheSize: 1})
+		otherHandler := NewSettingsHandler(&SettingsHandlerOptions{Store: store})
+
+		_, err := settingsHandler.GetChatSettings(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = otherHandler.SaveChatSettings(1, &ChatSettings{TimeZone: "Europe/Berlin"})
+		if err != nil {
+			t.Fatalf("Could not save settings: %v", err)
+		}
+
+		current, _ := settingsHandler.GetChatSettings(1)
+		if current.TimeZone != "" {
+			t.Errorf("Expected the cached settings before they expire, got %s", current)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		current, _ = settingsHandler.GetChatSettings(1)
+		if current.TimeZone != "Europe/Berlin" {
+			t.Errorf("Expected the settings of the other instance after the cache expired, got %s", current)
+		}
+
+		_, _ = settingsHandler.GetChatSettings(2)
+		if len(settingsHandler.cache) > 1 {
+			t.Errorf("Expected the cache to be limited to 1 chat, got %d", len(settingsHandler.cache))
+		}
+	})
+}
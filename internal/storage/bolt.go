@@ -0,0 +1,826 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var (
+	subscriptionsBucket = []byte("subscriptions")
+	guidsBucket         = []byte("guids")
+	postFetchBucket     = []byte("post-fetch")
+	chatContextsBucket  = []byte("chat-contexts")
+	buffersBucket       = []byte("buffers")
+	feedStatesBucket    = []byte("feed-states")
+	cursorsBucket       = []byte("cursors")
+	itemsBucket         = []byte("items")
+	itemSequencesBucket = []byte("item-sequences")
+	deliveriesBucket    = []byte("deliveries")
+	queuesBucket        = []byte("queues")
+	digestsBucket       = []byte("digests")
+	digestIndexBucket   = []byte("digest-index")
+	chatSettingsBucket  = []byte("chat-settings")
+	migrationsBucket    = []byte("migrations")
+)
+
+type BoltStoreOptions struct {
+	Path string
+}
+
+type BoltStore struct {
+	Options *BoltStoreOptions
+	Db      *bolt.DB
+}
+
+func NewBoltStore(options *BoltStoreOptions) (*BoltStore, error) {
+	db, err := bolt.Open(options.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{subscriptionsBucket, guidsBucket, postFetchBucket, chatContextsBucket, buffersBucket, feedStatesBucket, cursorsBucket, itemsBucket, itemSequencesBucket, deliveriesBucket, queuesBucket, digestsBucket, digestIndexBucket, chatSettingsBucket, migrationsBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{
+		Options: options,
+		Db:      db,
+	}, nil
+}
+
+func (boltStore *BoltStore) SaveSubscription(key SubscriptionKey, data []byte) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionsBucket).Put([]byte(key.String()), data)
+	})
+}
+
+func (boltStore *BoltStore) GetSubscription(key SubscriptionKey) ([]byte, error) {
+	var output []byte
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(subscriptionsBucket).Get([]byte(key.String()))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		output = slices.Clone(data)
+
+		return nil
+	})
+
+	return output, err
+}
+
+func (boltStore *BoltStore) DeleteSubscription(key SubscriptionKey) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(subscriptionsBucket).Delete([]byte(key.String()))
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(postFetchBucket).Delete([]byte(key.String()))
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(cursorsBucket).Delete([]byte(key.String()))
+		if err != nil {
+			return err
+		}
+
+		err = deleteDigestDue(tx, key)
+		if err != nil {
+			return err
+		}
+
+		err = deletePrefix(tx.Bucket(deliveriesBucket), deliveryPrefix(key))
+		if err != nil {
+			return err
+		}
+
+		for _, buffer := range buffers {
+			err = deletePrefix(tx.Bucket(buffersBucket), bufferPrefix(buffer, key))
+			if err != nil {
+				return err
+			}
+		}
+
+		return deletePrefix(tx.Bucket(guidsBucket), guidPrefix(key))
+	})
+}
+
+func (boltStore *BoltStore) GetSubscriptionKeys(chatId int64) ([]SubscriptionKey, error) {
+	return boltStore.getSubscriptionKeys([]byte(fmt.Sprintf("%d:", chatId)))
+}
+
+func (boltStore *BoltStore) GetAllSubscriptionKeys() ([]SubscriptionKey, error) {
+	return boltStore.getSubscriptionKeys(nil)
+}
+
+func (boltStore *BoltStore) getSubscriptionKeys(prefix []byte) ([]SubscriptionKey, error) {
+	var output []SubscriptionKey
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(subscriptionsBucket).Cursor()
+
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			key, err := parseSubscriptionKey(string(k))
+			if err != nil {
+				return err
+			}
+
+			output = append(output, key)
+		}
+
+		return nil
+	})
+
+	return output, err
+}
+
+func (boltStore *BoltStore) GetGuids(key SubscriptionKey) ([]string, error) {
+	var output []string
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		prefix := guidPrefix(key)
+		cursor := tx.Bucket(guidsBucket).Cursor()
+
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			output = append(output, string(k[len(prefix):]))
+		}
+
+		return nil
+	})
+
+	return output, err
+}
+
+func (boltStore *BoltStore) AddGuids(key SubscriptionKey, guids []string) error {
+	if len(guids) == 0 {
+		return nil
+	}
+
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(guidsBucket)
+		prefix := guidPrefix(key)
+
+		for _, guid := range guids {
+			err := bucket.Put(append(slices.Clone(prefix), guid...), []byte{})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (boltStore *BoltStore) DeleteGuids(key SubscriptionKey) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return deletePrefix(tx.Bucket(guidsBucket), guidPrefix(key))
+	})
+}
+
+func (boltStore *BoltStore) MarkFetched(key SubscriptionKey) (bool, error) {
+	firstFetch := false
+
+	err := boltStore.Db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(postFetchBucket)
+		if bucket.Get([]byte(key.String())) != nil {
+			return nil
+		}
+
+		firstFetch = true
+
+		return bucket.Put([]byte(key.String()), []byte("1"))
+	})
+
+	return firstFetch, err
+}
+
+func (boltStore *BoltStore) GetCursor(key SubscriptionKey) (int64, error) {
+	var cursor int64
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(cursorsBucket).Get([]byte(key.String()))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		cursor = int64(binary.BigEndian.Uint64(data))
+
+		return nil
+	})
+
+	return cursor, err
+}
+
+func (boltStore *BoltStore) SetCursor(key SubscriptionKey, cursor int64) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cursorsBucket).Put([]byte(key.String()), binary.BigEndian.AppendUint64(nil, uint64(cursor)))
+	})
+}
+
+func (boltStore *BoltStore) GetDigestDue(key SubscriptionKey) (time.Time, error) {
+	var due time.Time
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(digestsBucket).Get([]byte(key.String()))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		due = time.UnixMilli(int64(binary.BigEndian.Uint64(data)))
+
+		return nil
+	})
+
+	return due, err
+}
+
+func (boltStore *BoltStore) SetDigestDue(key SubscriptionKey, due time.Time) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		err := deleteDigestDue(tx, key)
+		if err != nil {
+			return err
+		}
+
+		value := binary.BigEndian.AppendUint64(nil, uint64(due.UnixMilli()))
+
+		err = tx.Bucket(digestIndexBucket).Put(append(slices.Clone(value), key.String()...), []byte{})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(digestsBucket).Put([]byte(key.String()), value)
+	})
+}
+
+func (boltStore *BoltStore) DeleteDigestDue(key SubscriptionKey) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return deleteDigestDue(tx, key)
+	})
+}
+
+func (boltStore *BoltStore) GetDueDigests(until time.Time) ([]SubscriptionKey, error) {
+	var output []SubscriptionKey
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(digestIndexBucket).Cursor()
+
+		for k, _ := cursor.First(); k != nil && len(k) > 8; k, _ = cursor.Next() {
+			if int64(binary.BigEndian.Uint64(k)) > until.UnixMilli() {
+				break
+			}
+
+			key, err := parseSubscriptionKey(string(k[8:]))
+			if err != nil {
+				return err
+			}
+
+			output = append(output, key)
+		}
+
+		return nil
+	})
+
+	return output, err
+}
+
+// deleteDigestDue removes the due time of the subscription and its entry in
+// the index, which is keyed by the due time followed by the subscription
+func deleteDigestDue(tx *bolt.Tx, key SubscriptionKey) error {
+	value := tx.Bucket(digestsBucket).Get([]byte(key.String()))
+	if value == nil {
+		return nil
+	}
+
+	err := tx.Bucket(digestIndexBucket).Delete(append(slices.Clone(value), key.String()...))
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(digestsBucket).Delete([]byte(key.String()))
+}
+
+func (boltStore *BoltStore) SaveDelivery(key SubscriptionKey, itemId string, data []byte) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deliveriesBucket).Put(append(deliveryPrefix(key), itemId...), data)
+	})
+}
+
+func (boltStore *BoltStore) GetDeliveries(key SubscriptionKey, itemIds []string) (map[string][]byte, error) {
+	output := make(map[string][]byte)
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deliveriesBucket)
+
+		for _, itemId := range itemIds {
+			if data := bucket.Get(append(deliveryPrefix(key), itemId...)); data != nil {
+				output[itemId] = slices.Clone(data)
+			}
+		}
+
+		return nil
+	})
+
+	return output, err
+}
+
+func (boltStore *BoltStore) PruneDeliveries(key SubscriptionKey, itemIds []string) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		prefix := deliveryPrefix(key)
+		cursor := tx.Bucket(deliveriesBucket).Cursor()
+
+		var pruned [][]byte
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			if !slices.Contains(itemIds, string(k[len(prefix):])) {
+				pruned = append(pruned, slices.Clone(k))
+			}
+		}
+
+		for _, k := range pruned {
+			err := tx.Bucket(deliveriesBucket).Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (boltStore *BoltStore) AppendItems(buffer Buffer, key SubscriptionKey, items [][]byte) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(buffersBucket)
+		prefix := bufferPrefix(buffer, key)
+
+		for _, item := range items {
+			sequence, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put(binary.BigEndian.AppendUint64(slices.Clone(prefix), sequence), item)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (boltStore *BoltStore) TakeItems(buffer Buffer, key SubscriptionKey) ([][]byte, error) {
+	var output [][]byte
+
+	err := boltStore.Db.Update(func(tx *bolt.Tx) error {
+		prefix := bufferPrefix(buffer, key)
+		cursor := tx.Bucket(buffersBucket).Cursor()
+
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			output = append(output, slices.Clone(v))
+		}
+
+		return deletePrefix(tx.Bucket(buffersBucket), prefix)
+	})
+
+	return output, err
+}
+
+func (boltStore *BoltStore) PeekItems(buffer Buffer, key SubscriptionKey) ([][]byte, error) {
+	var output [][]byte
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		prefix := bufferPrefix(buffer, key)
+		cursor := tx.Bucket(buffersBucket).Cursor()
+
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			output = append(output, slices.Clone(v))
+		}
+
+		return nil
+	})
+
+	return output, err
+}
+
+func (boltStore *BoltStore) DropItems(buffer Buffer, key SubscriptionKey, count int) error {
+	if count <= 0 {
+		return nil
+	}
+
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		prefix := bufferPrefix(buffer, key)
+		cursor := tx.Bucket(buffersBucket).Cursor()
+
+		var keys [][]byte
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && len(keys) < count; k, _ = cursor.Next() {
+			keys = append(keys, slices.Clone(k))
+		}
+
+		for _, k := range keys {
+			err := tx.Bucket(buffersBucket).Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (boltStore *BoltStore) SaveChatContext(chatId int64, data []byte, ttl time.Duration) error {
+	value, err := json.Marshal(newExpiringValue(data, ttl))
+	if err != nil {
+		return err
+	}
+
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(chatContextsBucket).Put([]byte(strconv.FormatInt(chatId, 10)), value)
+	})
+}
+
+func (boltStore *BoltStore) GetChatContext(chatId int64) ([]byte, error) {
+	var value expiringValue
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(chatContextsBucket).Get([]byte(strconv.FormatInt(chatId, 10)))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		return json.Unmarshal(data, &value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if value.expired() {
+		return nil, ErrNotFound
+	}
+
+	return value.Data, nil
+}
+
+func (boltStore *BoltStore) DeleteChatContext(chatId int64) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(chatContextsBucket).Delete([]byte(strconv.FormatInt(chatId, 10)))
+	})
+}
+
+func (boltStore *BoltStore) SaveChatSettings(chatId int64, data []byte) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(chatSettingsBucket).Put([]byte(strconv.FormatInt(chatId, 10)), data)
+	})
+}
+
+func (boltStore *BoltStore) GetChatSettings(chatId int64) ([]byte, error) {
+	var output []byte
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(chatSettingsBucket).Get([]byte(strconv.FormatInt(chatId, 10)))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		output = slices.Clone(data)
+
+		return nil
+	})
+
+	return output, err
+}
+
+func (boltStore *BoltStore) SaveFeedState(feedUrl string, data []byte) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(feedStatesBucket).Put([]byte(feedUrl), data)
+	})
+}
+
+func (boltStore *BoltStore) GetFeedState(feedUrl string) ([]byte, error) {
+	var output []byte
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(feedStatesBucket).Get([]byte(feedUrl))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		output = slices.Clone(data)
+
+		return nil
+	})
+
+	return output, err
+}
+
+func (boltStore *BoltStore) DeleteFeedState(feedUrl string) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(feedStatesBucket).Delete([]byte(feedUrl))
+	})
+}
+
+func (boltStore *BoltStore) AddItems(feedUrl string, ids []string, seen time.Time) ([]int64, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	output := make([]int64, len(ids))
+
+	err := boltStore.Db.Update(func(tx *bolt.Tx) error {
+		items := tx.Bucket(itemsBucket)
+		sequences := tx.Bucket(itemSequencesBucket)
+		prefix := itemPrefix(feedUrl)
+
+		last := readSequence(sequences.Get([]byte(feedUrl)))
+
+		for i, id := range ids {
+			itemKey := append(slices.Clone(prefix), id...)
+
+			if data := items.Get(itemKey); data != nil {
+				output[i] = readSequence(data)
+				continue
+			}
+
+			last = nextSequence(last, seen)
+			output[i] = last
+
+			err := items.Put(itemKey, binary.BigEndian.AppendUint64(nil, uint64(last)))
+			if err != nil {
+				return err
+			}
+		}
+
+		return sequences.Put([]byte(feedUrl), binary.BigEndian.AppendUint64(nil, uint64(last)))
+	})
+
+	return output, err
+}
+
+func (boltStore *BoltStore) LastSequence(feedUrl string) (int64, error) {
+	var last int64
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		last = readSequence(tx.Bucket(itemSequencesBucket).Get([]byte(feedUrl)))
+
+		return nil
+	})
+
+	return last, err
+}
+
+func (boltStore *BoltStore) PruneItems(feedUrl string, keep int, before time.Time, present []string) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		items := tx.Bucket(itemsBucket)
+		prefix := itemPrefix(feedUrl)
+
+		var seenItems []seenItem
+		cursor := items.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			seenItems = append(seenItems, seenItem{id: string(k[len(prefix):]), sequence: readSequence(v)})
+		}
+
+		for _, id := range expiredItems(seenItems, keep, before, present) {
+			err := items.Delete(append(slices.Clone(prefix), id...))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (boltStore *BoltStore) MoveItems(feedUrl string, movedUrl string) (bool, error) {
+	moved := false
+
+	err := boltStore.Db.Update(func(tx *bolt.Tx) error {
+		items := tx.Bucket(itemsBucket)
+		sequences := tx.Bucket(itemSequencesBucket)
+
+		if sequences.Get([]byte(movedUrl)) == nil {
+			moved = true
+
+			prefix := itemPrefix(feedUrl)
+			movedPrefix := itemPrefix(movedUrl)
+
+			var keys, values [][]byte
+			cursor := items.Cursor()
+			for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+				keys = append(keys, append(slices.Clone(movedPrefix), k[len(prefix):]...))
+				values = append(values, slices.Clone(v))
+			}
+
+			for i := range keys {
+				err := items.Put(keys[i], values[i])
+				if err != nil {
+					return err
+				}
+			}
+
+			if last := sequences.Get([]byte(feedUrl)); last != nil {
+				err := sequences.Put([]byte(movedUrl), slices.Clone(last))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return deleteItems(tx, feedUrl)
+	})
+
+	return moved, err
+}
+
+func (boltStore *BoltStore) DeleteItems(feedUrl string) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return deleteItems(tx, feedUrl)
+	})
+}
+
+func (boltStore *BoltStore) IsMigrated(name string) (bool, error) {
+	migrated := false
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		migrated = tx.Bucket(migrationsBucket).Get([]byte(name)) != nil
+
+		return nil
+	})
+
+	return migrated, err
+}
+
+func (boltStore *BoltStore) MarkMigrated(name string) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(migrationsBucket).Put([]byte(name), []byte{1})
+	})
+}
+
+func (boltStore *BoltStore) Close() error {
+	return boltStore.Db.Close()
+}
+
+// guidPrefix separates the subscription key from the guid with a zero byte,
+// which keeps items without a guid addressable
+func guidPrefix(key SubscriptionKey) []byte {
+	return append([]byte(key.String()), 0)
+}
+
+func deliveryPrefix(key SubscriptionKey) []byte {
+	return append([]byte(key.String()), 0)
+}
+
+func bufferPrefix(buffer Buffer, key SubscriptionKey) []byte {
+	return append([]byte(fmt.Sprintf("%s:%s", buffer, key)), 0)
+}
+
+func itemPrefix(feedUrl string) []byte {
+	return append([]byte(feedUrl), 0)
+}
+
+func readSequence(data []byte) int64 {
+	if len(data) != 8 {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+func deleteItems(tx *bolt.Tx, feedUrl string) error {
+	err := tx.Bucket(itemSequencesBucket).Delete([]byte(feedUrl))
+	if err != nil {
+		return err
+	}
+
+	return deletePrefix(tx.Bucket(itemsBucket), itemPrefix(feedUrl))
+}
+
+func deletePrefix(bucket *bolt.Bucket, prefix []byte) error {
+	cursor := bucket.Cursor()
+
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Seek(prefix) {
+		err := cursor.Delete()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (boltStore *BoltStore) Enqueue(queue Queue, entries [][]byte) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(queuesBucket)
+
+		for _, data := range entries {
+			sequence, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put(binary.BigEndian.AppendUint64(queuePrefix(queue), sequence), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (boltStore *BoltStore) PeekQueue(queue Queue, after string, count int) ([]*QueuedEntry, error) {
+	var output []*QueuedEntry
+
+	start := queuePrefix(queue)
+	if after != "" {
+		sequence, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		start = binary.BigEndian.AppendUint64(start, sequence+1)
+	}
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		prefix := queuePrefix(queue)
+		cursor := tx.Bucket(queuesBucket).Cursor()
+
+		for k, v := cursor.Seek(start); k != nil && bytes.HasPrefix(k, prefix) && len(output) < count; k, v = cursor.Next() {
+			output = append(output, &QueuedEntry{
+				Id:   strconv.FormatUint(binary.BigEndian.Uint64(k[len(prefix):]), 10),
+				Data: slices.Clone(v),
+			})
+		}
+
+		return nil
+	})
+
+	return output, err
+}
+
+func (boltStore *BoltStore) Dequeue(queue Queue, ids []string) error {
+	return boltStore.Db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(queuesBucket)
+
+		for _, id := range ids {
+			sequence, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			err = bucket.Delete(binary.BigEndian.AppendUint64(queuePrefix(queue), sequence))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (boltStore *BoltStore) QueueLength(queue Queue) (int64, error) {
+	var length int64
+
+	err := boltStore.Db.View(func(tx *bolt.Tx) error {
+		prefix := queuePrefix(queue)
+		cursor := tx.Bucket(queuesBucket).Cursor()
+
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			length++
+		}
+
+		return nil
+	})
+
+	return length, err
+}
+
+func queuePrefix(queue Queue) []byte {
+	return append([]byte(queue), 0)
+}
@@ -0,0 +1,583 @@
+package storage
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type MemoryStore struct {
+	subscriptions map[SubscriptionKey][]byte
+	guids         map[SubscriptionKey]map[string]struct{}
+	fetched       map[SubscriptionKey]struct{}
+	chatContexts  map[int64]expiringValue
+	chatSettings  map[int64][]byte
+	buffers       map[Buffer]map[SubscriptionKey][][]byte
+	feedStates    map[string][]byte
+	cursors       map[SubscriptionKey]int64
+	digests       []digestDue
+	deliveries    map[SubscriptionKey]map[string][]byte
+	items         map[string]map[string]int64
+	sequences     map[string]int64
+	queues        map[Queue][]*QueuedEntry
+	queueSequence int64
+	migrations    map[string]struct{}
+
+	lock sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		subscriptions: make(map[SubscriptionKey][]byte),
+		guids:         make(map[SubscriptionKey]map[string]struct{}),
+		fetched:       make(map[SubscriptionKey]struct{}),
+		chatContexts:  make(map[int64]expiringValue),
+		chatSettings:  make(map[int64][]byte),
+		buffers:       make(map[Buffer]map[SubscriptionKey][][]byte),
+		feedStates:    make(map[string][]byte),
+		cursors:       make(map[SubscriptionKey]int64),
+		deliveries:    make(map[SubscriptionKey]map[string][]byte),
+		items:         make(map[string]map[string]int64),
+		queues:        make(map[Queue][]*QueuedEntry),
+		sequences:     make(map[string]int64),
+		migrations:    make(map[string]struct{}),
+	}
+}
+
+func (memoryStore *MemoryStore) SaveSubscription(key SubscriptionKey, data []byte) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	memoryStore.subscriptions[key] = slices.Clone(data)
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) GetSubscription(key SubscriptionKey) ([]byte, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	data, ok := memoryStore.subscriptions[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return slices.Clone(data), nil
+}
+
+func (memoryStore *MemoryStore) DeleteSubscription(key SubscriptionKey) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	delete(memoryStore.subscriptions, key)
+	delete(memoryStore.guids, key)
+	delete(memoryStore.fetched, key)
+	delete(memoryStore.cursors, key)
+	memoryStore.deleteDigestDue(key)
+	delete(memoryStore.deliveries, key)
+	for _, buffer := range memoryStore.buffers {
+		delete(buffer, key)
+	}
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) GetSubscriptionKeys(chatId int64) ([]SubscriptionKey, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	var output []SubscriptionKey
+	for key := range memoryStore.subscriptions {
+		if key.ChatId == chatId {
+			output = append(output, key)
+		}
+	}
+
+	return output, nil
+}
+
+func (memoryStore *MemoryStore) GetAllSubscriptionKeys() ([]SubscriptionKey, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	output := make([]SubscriptionKey, 0, len(memoryStore.subscriptions))
+	for key := range memoryStore.subscriptions {
+		output = append(output, key)
+	}
+
+	return output, nil
+}
+
+func (memoryStore *MemoryStore) GetGuids(key SubscriptionKey) ([]string, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	output := make([]string, 0, len(memoryStore.guids[key]))
+	for guid := range memoryStore.guids[key] {
+		output = append(output, guid)
+	}
+
+	return output, nil
+}
+
+func (memoryStore *MemoryStore) AddGuids(key SubscriptionKey, guids []string) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	set, ok := memoryStore.guids[key]
+	if !ok {
+		set = make(map[string]struct{})
+		memoryStore.guids[key] = set
+	}
+
+	for _, guid := range guids {
+		set[guid] = struct{}{}
+	}
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) DeleteGuids(key SubscriptionKey) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	delete(memoryStore.guids, key)
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) MarkFetched(key SubscriptionKey) (bool, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	_, ok := memoryStore.fetched[key]
+	memoryStore.fetched[key] = struct{}{}
+
+	return !ok, nil
+}
+
+func (memoryStore *MemoryStore) GetCursor(key SubscriptionKey) (int64, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	cursor, ok := memoryStore.cursors[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	return cursor, nil
+}
+
+func (memoryStore *MemoryStore) SetCursor(key SubscriptionKey, cursor int64) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	memoryStore.cursors[key] = cursor
+
+	return nil
+}
+
+// digestDue is an entry of the due times, which are sorted by time
+type digestDue struct {
+	key SubscriptionKey
+	due time.Time
+}
+
+func (memoryStore *MemoryStore) GetDigestDue(key SubscriptionKey) (time.Time, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	i := slices.IndexFunc(memoryStore.digests, func(entry digestDue) bool { return entry.key == key })
+	if i < 0 {
+		return time.Time{}, ErrNotFound
+	}
+
+	return memoryStore.digests[i].due, nil
+}
+
+func (memoryStore *MemoryStore) SetDigestDue(key SubscriptionKey, due time.Time) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	memoryStore.deleteDigestDue(key)
+
+	i, _ := slices.BinarySearchFunc(memoryStore.digests, due, func(entry digestDue, due time.Time) int {
+		if entry.due.After(due) {
+			return 1
+		}
+
+		return -1
+	})
+	memoryStore.digests = slices.Insert(memoryStore.digests, i, digestDue{key: key, due: due})
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) DeleteDigestDue(key SubscriptionKey) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	memoryStore.deleteDigestDue(key)
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) deleteDigestDue(key SubscriptionKey) {
+	memoryStore.digests = slices.DeleteFunc(memoryStore.digests, func(entry digestDue) bool { return entry.key == key })
+}
+
+func (memoryStore *MemoryStore) GetDueDigests(until time.Time) ([]SubscriptionKey, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	var output []SubscriptionKey
+	for _, entry := range memoryStore.digests {
+		if entry.due.After(until) {
+			break
+		}
+
+		output = append(output, entry.key)
+	}
+
+	return output, nil
+}
+
+func (memoryStore *MemoryStore) SaveDelivery(key SubscriptionKey, itemId string, data []byte) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	if memoryStore.deliveries[key] == nil {
+		memoryStore.deliveries[key] = make(map[string][]byte)
+	}
+
+	memoryStore.deliveries[key][itemId] = slices.Clone(data)
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) GetDeliveries(key SubscriptionKey, itemIds []string) (map[string][]byte, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	output := make(map[string][]byte)
+	for _, itemId := range itemIds {
+		if data, ok := memoryStore.deliveries[key][itemId]; ok {
+			output[itemId] = slices.Clone(data)
+		}
+	}
+
+	return output, nil
+}
+
+func (memoryStore *MemoryStore) PruneDeliveries(key SubscriptionKey, itemIds []string) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	for itemId := range memoryStore.deliveries[key] {
+		if !slices.Contains(itemIds, itemId) {
+			delete(memoryStore.deliveries[key], itemId)
+		}
+	}
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) AppendItems(buffer Buffer, key SubscriptionKey, items [][]byte) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	if memoryStore.buffers[buffer] == nil {
+		memoryStore.buffers[buffer] = make(map[SubscriptionKey][][]byte)
+	}
+
+	for _, item := range items {
+		memoryStore.buffers[buffer][key] = append(memoryStore.buffers[buffer][key], slices.Clone(item))
+	}
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) TakeItems(buffer Buffer, key SubscriptionKey) ([][]byte, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	items := memoryStore.buffers[buffer][key]
+	delete(memoryStore.buffers[buffer], key)
+
+	return items, nil
+}
+
+func (memoryStore *MemoryStore) PeekItems(buffer Buffer, key SubscriptionKey) ([][]byte, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	items := memoryStore.buffers[buffer][key]
+
+	output := make([][]byte, len(items))
+	for i, item := range items {
+		output[i] = slices.Clone(item)
+	}
+
+	return output, nil
+}
+
+func (memoryStore *MemoryStore) DropItems(buffer Buffer, key SubscriptionKey, count int) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	items := memoryStore.buffers[buffer][key]
+	if count >= len(items) {
+		delete(memoryStore.buffers[buffer], key)
+		return nil
+	}
+
+	if count > 0 {
+		memoryStore.buffers[buffer][key] = items[count:]
+	}
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) SaveChatContext(chatId int64, data []byte, ttl time.Duration) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	memoryStore.chatContexts[chatId] = newExpiringValue(data, ttl)
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) GetChatContext(chatId int64) ([]byte, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	value, ok := memoryStore.chatContexts[chatId]
+	if !ok || value.expired() {
+		delete(memoryStore.chatContexts, chatId)
+		return nil, ErrNotFound
+	}
+
+	return slices.Clone(value.Data), nil
+}
+
+func (memoryStore *MemoryStore) DeleteChatContext(chatId int64) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	delete(memoryStore.chatContexts, chatId)
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) SaveChatSettings(chatId int64, data []byte) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	memoryStore.chatSettings[chatId] = slices.Clone(data)
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) GetChatSettings(chatId int64) ([]byte, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	data, ok := memoryStore.chatSettings[chatId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return slices.Clone(data), nil
+}
+
+func (memoryStore *MemoryStore) SaveFeedState(feedUrl string, data []byte) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	memoryStore.feedStates[feedUrl] = slices.Clone(data)
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) GetFeedState(feedUrl string) ([]byte, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	data, ok := memoryStore.feedStates[feedUrl]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return slices.Clone(data), nil
+}
+
+func (memoryStore *MemoryStore) DeleteFeedState(feedUrl string) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	delete(memoryStore.feedStates, feedUrl)
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) AddItems(feedUrl string, ids []string, seen time.Time) ([]int64, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	items, ok := memoryStore.items[feedUrl]
+	if !ok {
+		items = make(map[string]int64)
+		memoryStore.items[feedUrl] = items
+	}
+
+	output := make([]int64, len(ids))
+	for i, id := range ids {
+		sequence, ok := items[id]
+		if !ok {
+			sequence = nextSequence(memoryStore.sequences[feedUrl], seen)
+			memoryStore.sequences[feedUrl] = sequence
+			items[id] = sequence
+		}
+
+		output[i] = sequence
+	}
+
+	return output, nil
+}
+
+func (memoryStore *MemoryStore) LastSequence(feedUrl string) (int64, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	return memoryStore.sequences[feedUrl], nil
+}
+
+func (memoryStore *MemoryStore) PruneItems(feedUrl string, keep int, before time.Time, present []string) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	items := memoryStore.items[feedUrl]
+
+	seenItems := make([]seenItem, 0, len(items))
+	for id, sequence := range items {
+		seenItems = append(seenItems, seenItem{id: id, sequence: sequence})
+	}
+
+	for _, id := range expiredItems(seenItems, keep, before, present) {
+		delete(items, id)
+	}
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) MoveItems(feedUrl string, movedUrl string) (bool, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	_, exists := memoryStore.items[movedUrl]
+	if items, ok := memoryStore.items[feedUrl]; ok && !exists {
+		memoryStore.items[movedUrl] = items
+		memoryStore.sequences[movedUrl] = memoryStore.sequences[feedUrl]
+	}
+
+	delete(memoryStore.items, feedUrl)
+	delete(memoryStore.sequences, feedUrl)
+
+	return !exists, nil
+}
+
+func (memoryStore *MemoryStore) DeleteItems(feedUrl string) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	delete(memoryStore.items, feedUrl)
+	delete(memoryStore.sequences, feedUrl)
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) IsMigrated(name string) (bool, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	_, ok := memoryStore.migrations[name]
+
+	return ok, nil
+}
+
+func (memoryStore *MemoryStore) MarkMigrated(name string) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	memoryStore.migrations[name] = struct{}{}
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) Close() error {
+	return nil
+}
+
+func (memoryStore *MemoryStore) Enqueue(queue Queue, entries [][]byte) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	for _, data := range entries {
+		memoryStore.queueSequence++
+		memoryStore.queues[queue] = append(memoryStore.queues[queue], &QueuedEntry{
+			Id:   strconv.FormatInt(memoryStore.queueSequence, 10),
+			Data: slices.Clone(data),
+		})
+	}
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) PeekQueue(queue Queue, after string, count int) ([]*QueuedEntry, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	entries := memoryStore.queues[queue]
+	if after != "" {
+		sequence, err := strconv.ParseInt(after, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		start := slices.IndexFunc(entries, func(entry *QueuedEntry) bool {
+			id, _ := strconv.ParseInt(entry.Id, 10, 64)
+			return id > sequence
+		})
+		if start < 0 {
+			start = len(entries)
+		}
+
+		entries = entries[start:]
+	}
+
+	output := make([]*QueuedEntry, 0, min(count, len(entries)))
+	for _, entry := range entries[:min(count, len(entries))] {
+		output = append(output, &QueuedEntry{Id: entry.Id, Data: slices.Clone(entry.Data)})
+	}
+
+	return output, nil
+}
+
+func (memoryStore *MemoryStore) Dequeue(queue Queue, ids []string) error {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	memoryStore.queues[queue] = slices.DeleteFunc(memoryStore.queues[queue], func(entry *QueuedEntry) bool {
+		return slices.Contains(ids, entry.Id)
+	})
+
+	return nil
+}
+
+func (memoryStore *MemoryStore) QueueLength(queue Queue) (int64, error) {
+	memoryStore.lock.Lock()
+	defer memoryStore.lock.Unlock()
+
+	return int64(len(memoryStore.queues[queue])), nil
+}
@@ -0,0 +1,633 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RedisStoreOptions struct {
+	Addr     string
+	Password string
+	DB       int
+}
+
+type RedisStore struct {
+	Options *RedisStoreOptions
+	RedisDb *redis.Client
+	Context context.Context
+}
+
+func NewRedisStore(options *RedisStoreOptions) (*RedisStore, error) {
+	redisStore := &RedisStore{
+		Options: options,
+		RedisDb: redis.NewClient(&redis.Options{
+			Addr:     options.Addr,
+			Password: options.Password,
+			DB:       options.DB,
+		}),
+		Context: context.Background(),
+	}
+
+	err := redisStore.RedisDb.Ping(redisStore.Context).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = redisStore.migrateSubscriptionIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	return redisStore, nil
+}
+
+func (redisStore *RedisStore) SaveSubscription(key SubscriptionKey, data []byte) error {
+	_, err := redisStore.RedisDb.TxPipelined(redisStore.Context, func(pipe redis.Pipeliner) error {
+		pipe.Set(redisStore.Context, fmt.Sprintf("subscription:%s", key), data, 0)
+		pipe.SAdd(redisStore.Context, "subscriptions", key.String())
+		pipe.SAdd(redisStore.Context, fmt.Sprintf("subscriptions:%d", key.ChatId), key.String())
+
+		return nil
+	})
+
+	return err
+}
+
+func (redisStore *RedisStore) GetSubscription(key SubscriptionKey) ([]byte, error) {
+	val, err := redisStore.RedisDb.Get(redisStore.Context, fmt.Sprintf("subscription:%s", key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+
+	return val, err
+}
+
+func (redisStore *RedisStore) DeleteSubscription(key SubscriptionKey) error {
+	keys := []string{
+		fmt.Sprintf("subscription:%s", key),
+		fmt.Sprintf("post-fetch:%s", key),
+		fmt.Sprintf("guids:%s", key),
+		fmt.Sprintf("cursor:%s", key),
+		fmt.Sprintf("deliveries:%s", key),
+	}
+	for _, buffer := range buffers {
+		keys = append(keys, fmt.Sprintf("buffer:%s:%s", buffer, key))
+	}
+
+	_, err := redisStore.RedisDb.TxPipelined(redisStore.Context, func(pipe redis.Pipeliner) error {
+		pipe.Del(redisStore.Context, keys...)
+		pipe.ZRem(redisStore.Context, "digest-due", key.String())
+		pipe.SRem(redisStore.Context, "subscriptions", key.String())
+		pipe.SRem(redisStore.Context, fmt.Sprintf("subscriptions:%d", key.ChatId), key.String())
+
+		return nil
+	})
+
+	return err
+}
+
+func (redisStore *RedisStore) GetSubscriptionKeys(chatId int64) ([]SubscriptionKey, error) {
+	return redisStore.getSubscriptionKeys(fmt.Sprintf("subscriptions:%d", chatId))
+}
+
+func (redisStore *RedisStore) GetAllSubscriptionKeys() ([]SubscriptionKey, error) {
+	return redisStore.getSubscriptionKeys("subscriptions")
+}
+
+func (redisStore *RedisStore) getSubscriptionKeys(index string) ([]SubscriptionKey, error) {
+	members, err := redisStore.RedisDb.SMembers(redisStore.Context, index).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]SubscriptionKey, 0, len(members))
+	for _, member := range members {
+		subscriptionKey, err := parseSubscriptionKey(member)
+		if err != nil {
+			return nil, err
+		}
+
+		output = append(output, subscriptionKey)
+	}
+
+	return output, nil
+}
+
+// migrateSubscriptionIndexes builds the subscription index sets from the
+// subscription keys written before the indexes existed
+func (redisStore *RedisStore) migrateSubscriptionIndexes() error {
+	migrated, err := redisStore.IsMigrated("subscription-indexes")
+	if err != nil || migrated {
+		return err
+	}
+
+	log.Info().Msg("Building subscription indexes from existing keys")
+
+	count := 0
+	iter := redisStore.RedisDb.Scan(redisStore.Context, 0, "subscription:*", 1000).Iterator()
+	for iter.Next(redisStore.Context) {
+		key, err := parseSubscriptionKey(strings.TrimPrefix(iter.Val(), "subscription:"))
+		if err != nil {
+			log.Warn().Err(err).Msg("Skipping subscription key while building indexes")
+			continue
+		}
+
+		_, err = redisStore.RedisDb.TxPipelined(redisStore.Context, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(redisStore.Context, "subscriptions", key.String())
+			pipe.SAdd(redisStore.Context, fmt.Sprintf("subscriptions:%d", key.ChatId), key.String())
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		count++
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Indexed %d subscriptions", count)
+
+	return redisStore.MarkMigrated("subscription-indexes")
+}
+
+func (redisStore *RedisStore) GetGuids(key SubscriptionKey) ([]string, error) {
+	return redisStore.RedisDb.SMembers(redisStore.Context, fmt.Sprintf("guids:%s", key)).Result()
+}
+
+func (redisStore *RedisStore) AddGuids(key SubscriptionKey, guids []string) error {
+	if len(guids) == 0 {
+		return nil
+	}
+
+	return redisStore.RedisDb.SAdd(redisStore.Context, fmt.Sprintf("guids:%s", key), guids).Err()
+}
+
+func (redisStore *RedisStore) DeleteGuids(key SubscriptionKey) error {
+	return redisStore.RedisDb.Del(redisStore.Context, fmt.Sprintf("guids:%s", key)).Err()
+}
+
+func (redisStore *RedisStore) MarkFetched(key SubscriptionKey) (bool, error) {
+	return redisStore.RedisDb.SetNX(redisStore.Context, fmt.Sprintf("post-fetch:%s", key), "1", 0).Result()
+}
+
+func (redisStore *RedisStore) GetCursor(key SubscriptionKey) (int64, error) {
+	cursor, err := redisStore.RedisDb.Get(redisStore.Context, fmt.Sprintf("cursor:%s", key)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrNotFound
+	}
+
+	return cursor, err
+}
+
+func (redisStore *RedisStore) SetCursor(key SubscriptionKey, cursor int64) error {
+	return redisStore.RedisDb.Set(redisStore.Context, fmt.Sprintf("cursor:%s", key), cursor, 0).Err()
+}
+
+func (redisStore *RedisStore) GetDigestDue(key SubscriptionKey) (time.Time, error) {
+	due, err := redisStore.RedisDb.ZScore(redisStore.Context, "digest-due", key.String()).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.UnixMilli(int64(due)), nil
+}
+
+func (redisStore *RedisStore) SetDigestDue(key SubscriptionKey, due time.Time) error {
+	return redisStore.RedisDb.ZAdd(redisStore.Context, "digest-due", redis.Z{Score: float64(due.UnixMilli()), Member: key.String()}).Err()
+}
+
+func (redisStore *RedisStore) DeleteDigestDue(key SubscriptionKey) error {
+	return redisStore.RedisDb.ZRem(redisStore.Context, "digest-due", key.String()).Err()
+}
+
+func (redisStore *RedisStore) GetDueDigests(until time.Time) ([]SubscriptionKey, error) {
+	members, err := redisStore.RedisDb.ZRangeByScore(redisStore.Context, "digest-due", &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(until.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]SubscriptionKey, 0, len(members))
+	for _, member := range members {
+		key, err := parseSubscriptionKey(member)
+		if err != nil {
+			return nil, err
+		}
+
+		output = append(output, key)
+	}
+
+	return output, nil
+}
+
+func (redisStore *RedisStore) SaveDelivery(key SubscriptionKey, itemId string, data []byte) error {
+	return redisStore.RedisDb.HSet(redisStore.Context, fmt.Sprintf("deliveries:%s", key), itemId, data).Err()
+}
+
+func (redisStore *RedisStore) GetDeliveries(key SubscriptionKey, itemIds []string) (map[string][]byte, error) {
+	output := make(map[string][]byte)
+	if len(itemIds) == 0 {
+		return output, nil
+	}
+
+	values, err := redisStore.RedisDb.HMGet(redisStore.Context, fmt.Sprintf("deliveries:%s", key), itemIds...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		if data, ok := value.(string); ok {
+			output[itemIds[i]] = []byte(data)
+		}
+	}
+
+	return output, nil
+}
+
+func (redisStore *RedisStore) PruneDeliveries(key SubscriptionKey, itemIds []string) error {
+	delivered, err := redisStore.RedisDb.HKeys(redisStore.Context, fmt.Sprintf("deliveries:%s", key)).Result()
+	if err != nil {
+		return err
+	}
+
+	var pruned []string
+	for _, itemId := range delivered {
+		if !slices.Contains(itemIds, itemId) {
+			pruned = append(pruned, itemId)
+		}
+	}
+
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	return redisStore.RedisDb.HDel(redisStore.Context, fmt.Sprintf("deliveries:%s", key), pruned...).Err()
+}
+
+func (redisStore *RedisStore) AppendItems(buffer Buffer, key SubscriptionKey, items [][]byte) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		values[i] = item
+	}
+
+	return redisStore.RedisDb.RPush(redisStore.Context, fmt.Sprintf("buffer:%s:%s", buffer, key), values...).Err()
+}
+
+func (redisStore *RedisStore) TakeItems(buffer Buffer, key SubscriptionKey) ([][]byte, error) {
+	var items *redis.StringSliceCmd
+
+	_, err := redisStore.RedisDb.TxPipelined(redisStore.Context, func(pipe redis.Pipeliner) error {
+		items = pipe.LRange(redisStore.Context, fmt.Sprintf("buffer:%s:%s", buffer, key), 0, -1)
+		pipe.Del(redisStore.Context, fmt.Sprintf("buffer:%s:%s", buffer, key))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([][]byte, len(items.Val()))
+	for i, item := range items.Val() {
+		output[i] = []byte(item)
+	}
+
+	return output, nil
+}
+
+func (redisStore *RedisStore) PeekItems(buffer Buffer, key SubscriptionKey) ([][]byte, error) {
+	items, err := redisStore.RedisDb.LRange(redisStore.Context, fmt.Sprintf("buffer:%s:%s", buffer, key), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([][]byte, len(items))
+	for i, item := range items {
+		output[i] = []byte(item)
+	}
+
+	return output, nil
+}
+
+func (redisStore *RedisStore) DropItems(buffer Buffer, key SubscriptionKey, count int) error {
+	if count <= 0 {
+		return nil
+	}
+
+	return redisStore.RedisDb.LTrim(redisStore.Context, fmt.Sprintf("buffer:%s:%s", buffer, key), int64(count), -1).Err()
+}
+
+func (redisStore *RedisStore) SaveChatContext(chatId int64, data []byte, ttl time.Duration) error {
+	return redisStore.RedisDb.Set(redisStore.Context, fmt.Sprintf("chat-context:%d", chatId), data, ttl).Err()
+}
+
+func (redisStore *RedisStore) GetChatContext(chatId int64) ([]byte, error) {
+	val, err := redisStore.RedisDb.Get(redisStore.Context, fmt.Sprintf("chat-context:%d", chatId)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+
+	return val, err
+}
+
+func (redisStore *RedisStore) DeleteChatContext(chatId int64) error {
+	return redisStore.RedisDb.Del(redisStore.Context, fmt.Sprintf("chat-context:%d", chatId)).Err()
+}
+
+func (redisStore *RedisStore) SaveChatSettings(chatId int64, data []byte) error {
+	return redisStore.RedisDb.Set(redisStore.Context, fmt.Sprintf("chat-settings:%d", chatId), data, 0).Err()
+}
+
+func (redisStore *RedisStore) GetChatSettings(chatId int64) ([]byte, error) {
+	val, err := redisStore.RedisDb.Get(redisStore.Context, fmt.Sprintf("chat-settings:%d", chatId)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+
+	return val, err
+}
+
+func (redisStore *RedisStore) SaveFeedState(feedUrl string, data []byte) error {
+	return redisStore.RedisDb.Set(redisStore.Context, fmt.Sprintf("feed-state:%s", feedUrl), data, 0).Err()
+}
+
+func (redisStore *RedisStore) GetFeedState(feedUrl string) ([]byte, error) {
+	val, err := redisStore.RedisDb.Get(redisStore.Context, fmt.Sprintf("feed-state:%s", feedUrl)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+
+	return val, err
+}
+
+func (redisStore *RedisStore) DeleteFeedState(feedUrl string) error {
+	return redisStore.RedisDb.Del(redisStore.Context, fmt.Sprintf("feed-state:%s", feedUrl)).Err()
+}
+
+// AddItems checks all ids with one pipeline of ZSCORE, the scores of the
+// items sorted set are the sequence numbers
+func (redisStore *RedisStore) AddItems(feedUrl string, ids []string, seen time.Time) ([]int64, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	itemsKey := fmt.Sprintf("items:%s", feedUrl)
+
+	scoreCmds := make([]*redis.FloatCmd, len(ids))
+	_, err := redisStore.RedisDb.Pipelined(redisStore.Context, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			scoreCmds[i] = pipe.ZScore(redisStore.Context, itemsKey, id)
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	// missing members have a zero score, the sequence numbers start at one
+	scores := make([]float64, len(ids))
+	for i, cmd := range scoreCmds {
+		score, err := cmd.Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+
+		scores[i] = score
+	}
+
+	last, err := redisStore.LastSequence(feedUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]int64, len(ids))
+	added := make(map[string]int64)
+	var members []redis.Z
+
+	for i, id := range ids {
+		if scores[i] > 0 {
+			output[i] = int64(scores[i])
+			continue
+		}
+
+		sequence, ok := added[id]
+		if !ok {
+			last = nextSequence(last, seen)
+			sequence = last
+
+			added[id] = sequence
+			members = append(members, redis.Z{Score: float64(sequence), Member: id})
+		}
+
+		output[i] = sequence
+	}
+
+	if len(members) == 0 {
+		return output, nil
+	}
+
+	_, err = redisStore.RedisDb.TxPipelined(redisStore.Context, func(pipe redis.Pipeliner) error {
+		pipe.ZAddNX(redisStore.Context, itemsKey, members...)
+		pipe.Set(redisStore.Context, fmt.Sprintf("item-sequence:%s", feedUrl), last, 0)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+func (redisStore *RedisStore) LastSequence(feedUrl string) (int64, error) {
+	last, err := redisStore.RedisDb.Get(redisStore.Context, fmt.Sprintf("item-sequence:%s", feedUrl)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+
+	return last, err
+}
+
+// PruneItems only reads the items exceeding the count or age instead of the whole set
+func (redisStore *RedisStore) PruneItems(feedUrl string, keep int, before time.Time, present []string) error {
+	itemsKey := fmt.Sprintf("items:%s", feedUrl)
+
+	var overflow, expired *redis.StringSliceCmd
+
+	_, err := redisStore.RedisDb.Pipelined(redisStore.Context, func(pipe redis.Pipeliner) error {
+		overflow = pipe.ZRange(redisStore.Context, itemsKey, 0, int64(-keep-1))
+		expired = pipe.ZRangeByScore(redisStore.Context, itemsKey, &redis.ZRangeBy{
+			Min: "-inf",
+			Max: fmt.Sprintf("(%d", before.UnixMilli()),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var members []interface{}
+	for _, id := range append(overflow.Val(), expired.Val()...) {
+		if !slices.Contains(present, id) {
+			members = append(members, id)
+		}
+	}
+
+	if len(members) == 0 {
+		return nil
+	}
+
+	return redisStore.RedisDb.ZRem(redisStore.Context, itemsKey, members...).Err()
+}
+
+func (redisStore *RedisStore) MoveItems(feedUrl string, movedUrl string) (bool, error) {
+	exists, err := redisStore.RedisDb.Exists(redisStore.Context, fmt.Sprintf("items:%s", movedUrl)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if exists == 1 {
+		return false, redisStore.DeleteItems(feedUrl)
+	}
+
+	// RENAME fails for missing keys, so only the existing keys are moved
+	var keys []string
+	for _, prefix := range []string{"items", "item-sequence"} {
+		key := fmt.Sprintf("%s:%s", prefix, feedUrl)
+
+		exists, err = redisStore.RedisDb.Exists(redisStore.Context, key).Result()
+		if err != nil {
+			return false, err
+		}
+
+		if exists == 1 {
+			keys = append(keys, prefix)
+		}
+	}
+
+	if len(keys) == 0 {
+		return true, nil
+	}
+
+	_, err = redisStore.RedisDb.TxPipelined(redisStore.Context, func(pipe redis.Pipeliner) error {
+		for _, prefix := range keys {
+			pipe.Rename(redisStore.Context, fmt.Sprintf("%s:%s", prefix, feedUrl), fmt.Sprintf("%s:%s", prefix, movedUrl))
+		}
+
+		return nil
+	})
+
+	return true, err
+}
+
+func (redisStore *RedisStore) DeleteItems(feedUrl string) error {
+	return redisStore.RedisDb.Del(redisStore.Context, fmt.Sprintf("items:%s", feedUrl), fmt.Sprintf("item-sequence:%s", feedUrl)).Err()
+}
+
+func (redisStore *RedisStore) IsMigrated(name string) (bool, error) {
+	exists, err := redisStore.RedisDb.Exists(redisStore.Context, fmt.Sprintf("migration:%s", name)).Result()
+
+	return exists == 1, err
+}
+
+func (redisStore *RedisStore) MarkMigrated(name string) error {
+	return redisStore.RedisDb.Set(redisStore.Context, fmt.Sprintf("migration:%s", name), "1", 0).Err()
+}
+
+func (redisStore *RedisStore) Close() error {
+	return redisStore.RedisDb.Close()
+}
+
+func parseSubscriptionKey(value string) (SubscriptionKey, error) {
+	chatIdString, id, ok := strings.Cut(value, ":")
+	if !ok {
+		return SubscriptionKey{}, fmt.Errorf("invalid subscription key %s", value)
+	}
+
+	chatId, err := strconv.ParseInt(chatIdString, 10, 64)
+	if err != nil {
+		return SubscriptionKey{}, fmt.Errorf("invalid subscription key %s: %w", value, err)
+	}
+
+	return SubscriptionKey{ChatId: chatId, Id: id}, nil
+}
+
+func (redisStore *RedisStore) Enqueue(queue Queue, entries [][]byte) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	_, err := redisStore.RedisDb.TxPipelined(redisStore.Context, func(pipe redis.Pipeliner) error {
+		for _, data := range entries {
+			pipe.XAdd(redisStore.Context, &redis.XAddArgs{
+				Stream: fmt.Sprintf("queue:%s", queue),
+				Values: map[string]interface{}{"data": data},
+			})
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func (redisStore *RedisStore) PeekQueue(queue Queue, after string, count int) ([]*QueuedEntry, error) {
+	start := "-"
+	if after != "" {
+		// exclusive ranges need redis 6.2, the next possible id works before
+		milliseconds, sequence, _ := strings.Cut(after, "-")
+
+		next, err := strconv.ParseUint(sequence, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stream id %s: %w", after, err)
+		}
+
+		start = fmt.Sprintf("%s-%d", milliseconds, next+1)
+	}
+
+	messages, err := redisStore.RedisDb.XRangeN(redisStore.Context, fmt.Sprintf("queue:%s", queue), start, "+", int64(count)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]*QueuedEntry, 0, len(messages))
+	for _, message := range messages {
+		data, _ := message.Values["data"].(string)
+		output = append(output, &QueuedEntry{Id: message.ID, Data: []byte(data)})
+	}
+
+	return output, nil
+}
+
+func (redisStore *RedisStore) Dequeue(queue Queue, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return redisStore.RedisDb.XDel(redisStore.Context, fmt.Sprintf("queue:%s", queue), ids...).Err()
+}
+
+func (redisStore *RedisStore) QueueLength(queue Queue) (int64, error) {
+	return redisStore.RedisDb.XLen(redisStore.Context, fmt.Sprintf("queue:%s", queue)).Result()
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var ErrNotFound = errors.New("storage: not found")
+
+type Buffer string
+
+const (
+	MissedItems Buffer = "missed"
+	DigestItems Buffer = "digest"
+)
+
+var buffers = []Buffer{MissedItems, DigestItems}
+
+type Queue string
+
+const (
+	DeliveryQueue   Queue = "delivery"
+	DeadLetterQueue Queue = "dead-letters"
+)
+
+// QueuedEntry is an entry of a queue with the id it is removed by
+type QueuedEntry struct {
+	Id   string
+	Data []byte
+}
+
+type SubscriptionKey struct {
+	ChatId int64
+	Id     string
+}
+
+func (key SubscriptionKey) String() string {
+	return fmt.Sprintf("%d:%s", key.ChatId, key.Id)
+}
+
+// SubscriptionStore persists subscriptions together with the per subscription
+// reader state (delivery cursor, buffers and the guids and first fetch marker
+// of older versions).
+type SubscriptionStore interface {
+	SaveSubscription(key SubscriptionKey, data []byte) error
+	GetSubscription(key SubscriptionKey) ([]byte, error)
+	// DeleteSubscription removes the subscription including its cursor, deliveries, digest due time, guids, first fetch marker and buffers
+	DeleteSubscription(key SubscriptionKey) error
+	GetSubscriptionKeys(chatId int64) ([]SubscriptionKey, error)
+	GetAllSubscriptionKeys() ([]SubscriptionKey, error)
+
+	// GetGuids, AddGuids and DeleteGuids access the seen guids stored per
+	// subscription before items were shared per feed, they are only used to
+	// migrate subscriptions to a cursor
+	GetGuids(key SubscriptionKey) ([]string, error)
+	AddGuids(key SubscriptionKey, guids []string) error
+	DeleteGuids(key SubscriptionKey) error
+
+	// MarkFetched sets the first fetch marker and reports whether it was not set before
+	MarkFetched(key SubscriptionKey) (bool, error)
+
+	// GetCursor returns the sequence number of the last item delivered to the
+	// subscription or ErrNotFound if the subscription was never fetched
+	GetCursor(key SubscriptionKey) (int64, error)
+	SetCursor(key SubscriptionKey, cursor int64) error
+
+	// GetDigestDue returns the time the pending digest of the subscription is
+	// due or ErrNotFound if no digest is pending
+	GetDigestDue(key SubscriptionKey) (time.Time, error)
+	SetDigestDue(key SubscriptionKey, due time.Time) error
+	DeleteDigestDue(key SubscriptionKey) error
+	// GetDueDigests returns the subscriptions with a digest due at or before
+	// until, the earliest first. The due times are kept ordered so only the due
+	// entries are read.
+	GetDueDigests(until time.Time) ([]SubscriptionKey, error)
+
+	// SaveDelivery stores the serialized delivery of an item to the
+	// subscription, GetDeliveries returns the existing deliveries of the ids
+	SaveDelivery(key SubscriptionKey, itemId string, data []byte) error
+	GetDeliveries(key SubscriptionKey, itemIds []string) (map[string][]byte, error)
+	// PruneDeliveries removes the deliveries of all items except the given ids
+	PruneDeliveries(key SubscriptionKey, itemIds []string) error
+
+	// AppendItems adds serialized items to a buffer of the subscription, TakeItems
+	// returns all buffered items in insertion order and empties the buffer
+	AppendItems(buffer Buffer, key SubscriptionKey, items [][]byte) error
+	TakeItems(buffer Buffer, key SubscriptionKey) ([][]byte, error)
+	// PeekItems returns the buffered items without removing them, DropItems
+	// removes the first count items once they were handled
+	PeekItems(buffer Buffer, key SubscriptionKey) ([][]byte, error)
+	DropItems(buffer Buffer, key SubscriptionKey, count int) error
+}
+
+// ChatStore persists the serialized conversation state of chats, entries
+// expire after the given ttl
+type ChatStore interface {
+	SaveChatContext(chatId int64, data []byte, ttl time.Duration) error
+	GetChatContext(chatId int64) ([]byte, error)
+	DeleteChatContext(chatId int64) error
+
+	// SaveChatSettings stores the serialized settings of a chat, they do not expire
+	SaveChatSettings(chatId int64, data []byte) error
+	GetChatSettings(chatId int64) ([]byte, error)
+}
+
+// FeedStore persists the serialized fetch state of a feed url
+type FeedStore interface {
+	SaveFeedState(feedUrl string, data []byte) error
+	GetFeedState(feedUrl string) ([]byte, error)
+	DeleteFeedState(feedUrl string) error
+}
+
+// ItemStore keeps the ids of the items seen in a feed once for all of its
+// subscriptions. An item gets the next sequence number of the feed when it is
+// seen first, subscriptions compare it with their cursor. The sequence number
+// is the first seen time in unix milliseconds, raised if necessary to stay
+// increasing within the feed.
+type ItemStore interface {
+	// AddItems returns the sequence numbers of the ids in the given order,
+	// unseen ids are added with new numbers
+	AddItems(feedUrl string, ids []string, seen time.Time) ([]int64, error)
+	// LastSequence returns the highest sequence number of the feed
+	LastSequence(feedUrl string) (int64, error)
+	// PruneItems removes items first seen before the given time and items
+	// exceeding the newest keep items, the present ids are never removed
+	PruneItems(feedUrl string, keep int, before time.Time, present []string) error
+	// MoveItems renames the items of a feed and reports false if the new url
+	// already had items, the items of the old url are dropped then
+	MoveItems(feedUrl string, movedUrl string) (bool, error)
+	DeleteItems(feedUrl string) error
+}
+
+// QueueStore persists ordered queues, entries stay in the queue until they
+// are removed by id
+type QueueStore interface {
+	// Enqueue appends the entries to the end of the queue
+	Enqueue(queue Queue, entries [][]byte) error
+	// PeekQueue returns up to count entries from the start of the queue or,
+	// if after is set, the entries that were added after that id
+	PeekQueue(queue Queue, after string, count int) ([]*QueuedEntry, error)
+	Dequeue(queue Queue, ids []string) error
+	QueueLength(queue Queue) (int64, error)
+}
+
+// MigrationStore remembers the data migrations that have already run
+type MigrationStore interface {
+	IsMigrated(name string) (bool, error)
+	MarkMigrated(name string) error
+}
+
+type Store interface {
+	SubscriptionStore
+	ChatStore
+	FeedStore
+	ItemStore
+	QueueStore
+	MigrationStore
+
+	Close() error
+}
+
+type seenItem struct {
+	id       string
+	sequence int64
+}
+
+// nextSequence returns the sequence number of the next unseen item
+func nextSequence(last int64, seen time.Time) int64 {
+	return max(last+1, seen.UnixMilli())
+}
+
+// expiredItems selects the ids to prune from all items of a feed
+func expiredItems(items []seenItem, keep int, before time.Time, present []string) []string {
+	slices.SortFunc(items, func(a, b seenItem) int {
+		return cmp.Compare(b.sequence, a.sequence)
+	})
+
+	var output []string
+	for i, item := range items {
+		if (i >= keep || item.sequence < before.UnixMilli()) && !slices.Contains(present, item.id) {
+			output = append(output, item.id)
+		}
+	}
+
+	return output
+}
+
+type expiringValue struct {
+	Data      []byte    `json:"data"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func newExpiringValue(data []byte, ttl time.Duration) expiringValue {
+	value := expiringValue{Data: slices.Clone(data)}
+	if ttl > 0 {
+		value.ExpiresAt = time.Now().Add(ttl)
+	}
+
+	return value
+}
+
+func (value expiringValue) expired() bool {
+	return !value.ExpiresAt.IsZero() && time.Now().After(value.ExpiresAt)
+}
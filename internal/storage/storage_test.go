@@ -0,0 +1,471 @@
+package storage
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redisTestDB is the database the redis tests empty and use
+const redisTestDB = 15
+
+func TestStores(t *testing.T) {
+	boltStore, err := NewBoltStore(&BoltStoreOptions{Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Could not open bolt store: %v", err)
+	}
+	defer boltStore.Close()
+
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"bolt":   boltStore,
+	}
+
+	if redisStore := newTestRedisStore(t); redisStore != nil {
+		stores["redis"] = redisStore
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, store)
+		})
+	}
+}
+
+// newTestRedisStore returns a store on an emptied database of the server in
+// REDIS_TEST_ADDR, the redis tests are skipped without it
+func newTestRedisStore(t *testing.T) *RedisStore {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		return nil
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: redisTestDB})
+	defer client.Close()
+
+	err := client.FlushDB(context.Background()).Err()
+	if err != nil {
+		t.Fatalf("Could not empty redis database: %v", err)
+	}
+
+	redisStore, err := NewRedisStore(&RedisStoreOptions{Addr: addr, DB: redisTestDB})
+	if err != nil {
+		t.Fatalf("Could not open redis store: %v", err)
+	}
+	t.Cleanup(func() { _ = redisStore.Close() })
+
+	return redisStore
+}
+
+func TestRedisSubscriptionIndexes(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: redisTestDB})
+	defer client.Close()
+
+	ctx := context.Background()
+
+	err := client.FlushDB(ctx).Err()
+	if err != nil {
+		t.Fatalf("Could not empty redis database: %v", err)
+	}
+
+	// subscriptions stored before the indexes existed
+	for _, key := range []string{"1:a", "1:b", "2:c"} {
+		client.Set(ctx, "subscription:"+key, key, 0)
+	}
+
+	redisStore, err := NewRedisStore(&RedisStoreOptions{Addr: addr, DB: redisTestDB})
+	if err != nil {
+		t.Fatalf("Could not open redis store: %v", err)
+	}
+	defer redisStore.Close()
+
+	keyA := SubscriptionKey{ChatId: 1, Id: "a"}
+	keyB := SubscriptionKey{ChatId: 1, Id: "b"}
+	keyC := SubscriptionKey{ChatId: 2, Id: "c"}
+
+	keys, err := redisStore.GetSubscriptionKeys(1)
+	slices.SortFunc(keys, func(a, b SubscriptionKey) int { return strings.Compare(a.Id, b.Id) })
+	if err != nil || !slices.Equal(keys, []SubscriptionKey{keyA, keyB}) {
+		t.Errorf("Migrated keys of chat 1 are incorrect, got: %v (%v)", keys, err)
+	}
+
+	keys, _ = redisStore.GetAllSubscriptionKeys()
+	if len(keys) != 3 {
+		t.Errorf("Expected 3 migrated subscriptions, got: %v", keys)
+	}
+
+	migrated, _ := client.Exists(ctx, "migration:subscription-indexes").Result()
+	if migrated != 1 {
+		t.Errorf("Expected the migration to be marked as done")
+	}
+
+	err = redisStore.DeleteSubscription(keyA)
+	if err != nil {
+		t.Fatalf("Could not delete subscription: %v", err)
+	}
+
+	keys, _ = redisStore.GetSubscriptionKeys(1)
+	if !slices.Equal(keys, []SubscriptionKey{keyB}) {
+		t.Errorf("Expected the deleted subscription to be removed from the chat index, got: %v", keys)
+	}
+
+	keys, _ = redisStore.GetAllSubscriptionKeys()
+	if slices.Contains(keys, keyA) || !slices.Contains(keys, keyC) {
+		t.Errorf("Expected the deleted subscription to be removed from the index, got: %v", keys)
+	}
+}
+
+func testStore(t *testing.T, store Store) {
+	keyA := SubscriptionKey{ChatId: 1, Id: "a"}
+	keyB := SubscriptionKey{ChatId: 1, Id: "b"}
+	keyC := SubscriptionKey{ChatId: -10, Id: "c"}
+
+	t.Run("Test subscriptions", func(t *testing.T) {
+		for _, key := range []SubscriptionKey{keyA, keyB, keyC} {
+			err := store.SaveSubscription(key, []byte(key.Id))
+			if err != nil {
+				t.Fatalf("Could not save subscription: %v", err)
+			}
+		}
+
+		keys, err := store.GetSubscriptionKeys(1)
+		if err != nil || len(keys) != 2 {
+			t.Errorf("Chat subscription count is incorrect, got: %d (%v), want: %d.", len(keys), err, 2)
+		}
+
+		keys, err = store.GetAllSubscriptionKeys()
+		if err != nil || len(keys) != 3 {
+			t.Errorf("Subscription count is incorrect, got: %d (%v), want: %d.", len(keys), err, 3)
+		}
+
+		data, err := store.GetSubscription(keyC)
+		if err != nil || string(data) != "c" {
+			t.Errorf("Subscription data is incorrect, got: %s (%v), want: %s.", data, err, "c")
+		}
+	})
+
+	t.Run("Test guids", func(t *testing.T) {
+		err := store.AddGuids(keyA, []string{"1", "2", "", "2"})
+		if err != nil {
+			t.Fatalf("Could not add guids: %v", err)
+		}
+
+		guids, err := store.GetGuids(keyA)
+		slices.Sort(guids)
+		if err != nil || !slices.Equal(guids, []string{"", "1", "2"}) {
+			t.Errorf("Guids are incorrect, got: %v (%v)", guids, err)
+		}
+
+		guids, _ = store.GetGuids(keyB)
+		if len(guids) != 0 {
+			t.Errorf("Guids leaked into other subscription, got: %v", guids)
+		}
+	})
+
+	t.Run("Test first fetch marker", func(t *testing.T) {
+		first, _ := store.MarkFetched(keyA)
+		second, _ := store.MarkFetched(keyA)
+
+		if !first || second {
+			t.Errorf("First fetch marker is incorrect, got: %t, %t, want: true, false.", first, second)
+		}
+	})
+
+	t.Run("Test cursors", func(t *testing.T) {
+		_, err := store.GetCursor(keyA)
+		if err != ErrNotFound {
+			t.Errorf("Expected missing cursor, got: %v", err)
+		}
+
+		_ = store.SetCursor(keyA, 42)
+
+		cursor, err := store.GetCursor(keyA)
+		if err != nil || cursor != 42 {
+			t.Errorf("Cursor is incorrect, got: %d (%v), want: %d.", cursor, err, 42)
+		}
+	})
+
+	t.Run("Test digest due times", func(t *testing.T) {
+		_, err := store.GetDigestDue(keyA)
+		if err != ErrNotFound {
+			t.Errorf("Expected no digest, got: %v", err)
+		}
+
+		due := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+		_ = store.SetDigestDue(keyA, due)
+
+		stored, err := store.GetDigestDue(keyA)
+		if err != nil || !stored.Equal(due) {
+			t.Errorf("Digest due time is incorrect, got: %s (%v)", stored, err)
+		}
+
+		_ = store.DeleteDigestDue(keyA)
+		_ = store.SetDigestDue(keyB, due)
+
+		_, err = store.GetDigestDue(keyA)
+		if err != ErrNotFound {
+			t.Errorf("Expected the digest to be deleted, got: %v", err)
+		}
+
+		_ = store.SetDigestDue(keyA, due.Add(time.Hour))
+		_ = store.SetDigestDue(keyB, due.Add(-time.Hour))
+
+		keys, err := store.GetDueDigests(due)
+		if err != nil || !slices.Equal(keys, []SubscriptionKey{keyB}) {
+			t.Errorf("Due digests are incorrect, got: %v (%v), want: %v", keys, err, []SubscriptionKey{keyB})
+		}
+
+		keys, _ = store.GetDueDigests(due.Add(time.Hour))
+		if !slices.Equal(keys, []SubscriptionKey{keyB, keyA}) {
+			t.Errorf("Due digests are incorrect, got: %v, want: %v", keys, []SubscriptionKey{keyB, keyA})
+		}
+
+		_ = store.DeleteDigestDue(keyA)
+		_ = store.DeleteDigestDue(keyB)
+
+		keys, _ = store.GetDueDigests(due.Add(time.Hour))
+		if len(keys) != 0 {
+			t.Errorf("Expected no due digests after deleting them, got: %v", keys)
+		}
+	})
+
+	t.Run("Test deliveries", func(t *testing.T) {
+		_ = store.SaveDelivery(keyA, "1", []byte("a"))
+		_ = store.SaveDelivery(keyA, "2", []byte("b"))
+		_ = store.SaveDelivery(keyB, "1", []byte("c"))
+
+		deliveries, err := store.GetDeliveries(keyA, []string{"1", "3"})
+		if err != nil || len(deliveries) != 1 || string(deliveries["1"]) != "a" {
+			t.Errorf("Deliveries are incorrect, got: %q (%v)", deliveries, err)
+		}
+
+		err = store.PruneDeliveries(keyA, []string{"2"})
+		if err != nil {
+			t.Fatalf("Could not prune deliveries: %v", err)
+		}
+
+		deliveries, _ = store.GetDeliveries(keyA, []string{"1", "2"})
+		if len(deliveries) != 1 || string(deliveries["2"]) != "b" {
+			t.Errorf("Pruned deliveries are incorrect, got: %q", deliveries)
+		}
+
+		deliveries, _ = store.GetDeliveries(keyB, []string{"1"})
+		if len(deliveries) != 1 {
+			t.Errorf("Deliveries of other subscriptions were pruned, got: %q", deliveries)
+		}
+	})
+
+	t.Run("Test queues", func(t *testing.T) {
+		err := store.Enqueue(DeliveryQueue, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+		if err != nil {
+			t.Fatalf("Could not enqueue entries: %v", err)
+		}
+
+		_ = store.Enqueue(DeadLetterQueue, [][]byte{[]byte("d")})
+
+		entries, err := store.PeekQueue(DeliveryQueue, "", 2)
+		if err != nil || len(entries) != 2 || string(entries[0].Data) != "a" || string(entries[1].Data) != "b" {
+			t.Fatalf("Queue entries are incorrect, got: %v (%v)", entries, err)
+		}
+
+		err = store.Dequeue(DeliveryQueue, []string{entries[0].Id})
+		if err != nil {
+			t.Fatalf("Could not dequeue entry: %v", err)
+		}
+
+		entries, _ = store.PeekQueue(DeliveryQueue, "", 10)
+		if len(entries) != 2 || string(entries[0].Data) != "b" || string(entries[1].Data) != "c" {
+			t.Errorf("Queue entries after dequeue are incorrect, got: %v", entries)
+		}
+
+		length, _ := store.QueueLength(DeliveryQueue)
+		deadLetters, _ := store.QueueLength(DeadLetterQueue)
+		if length != 2 || deadLetters != 1 {
+			t.Errorf("Queue lengths are incorrect, got: %d, %d", length, deadLetters)
+		}
+
+		following, _ := store.PeekQueue(DeliveryQueue, entries[0].Id, 10)
+		if len(following) != 1 || string(following[0].Data) != "c" {
+			t.Errorf("Queue entries after %s are incorrect, got: %v", entries[0].Id, following)
+		}
+
+		_ = store.Dequeue(DeliveryQueue, []string{entries[0].Id})
+
+		following, _ = store.PeekQueue(DeliveryQueue, entries[0].Id, 10)
+		if len(following) != 1 || string(following[0].Data) != "c" {
+			t.Errorf("Queue entries after the removed entry %s are incorrect, got: %v", entries[0].Id, following)
+		}
+	})
+
+	t.Run("Test items", func(t *testing.T) {
+		feedUrl := "https://example.com/feed"
+		seen := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		start := seen.UnixMilli()
+
+		sequences, err := store.AddItems(feedUrl, []string{"a", "b", "a"}, seen)
+		if err != nil || !slices.Equal(sequences, []int64{start, start + 1, start}) {
+			t.Fatalf("Sequences are incorrect, got: %v (%v)", sequences, err)
+		}
+
+		sequences, _ = store.AddItems(feedUrl, []string{"c", "b", ""}, seen.Add(time.Hour))
+		later := seen.Add(time.Hour).UnixMilli()
+		if !slices.Equal(sequences, []int64{later, start + 1, later + 1}) {
+			t.Errorf("Sequences are incorrect, got: %v", sequences)
+		}
+
+		last, _ := store.LastSequence(feedUrl)
+		if last != later+1 {
+			t.Errorf("Last sequence is incorrect, got: %d, want: %d.", last, later+1)
+		}
+
+		err = store.PruneItems(feedUrl, 10, seen.Add(-time.Hour), nil)
+		if err != nil {
+			t.Fatalf("Could not prune items: %v", err)
+		}
+
+		// a exceeds the count, b is too old but kept because it is still part of the feed
+		err = store.PruneItems(feedUrl, 3, seen.Add(time.Minute), []string{"b"})
+		if err != nil {
+			t.Fatalf("Could not prune items: %v", err)
+		}
+
+		sequences, _ = store.AddItems(feedUrl, []string{"", "c", "b", "a"}, seen.Add(2*time.Hour))
+		if !slices.Equal(sequences, []int64{later + 1, later, start + 1, seen.Add(2 * time.Hour).UnixMilli()}) {
+			t.Errorf("Pruned sequences are incorrect, got: %v", sequences)
+		}
+
+		moved, err := store.MoveItems(feedUrl, "https://example.com/moved")
+		if err != nil || !moved {
+			t.Fatalf("Could not move items: %t (%v)", moved, err)
+		}
+
+		sequences, _ = store.AddItems("https://example.com/moved", []string{"c"}, seen)
+		last, _ = store.LastSequence(feedUrl)
+		if !slices.Equal(sequences, []int64{later}) || last != 0 {
+			t.Errorf("Items were not moved, got: %v, last sequence of old url: %d", sequences, last)
+		}
+
+		_, _ = store.AddItems("https://example.com/other", []string{"x"}, seen)
+		moved, _ = store.MoveItems("https://example.com/moved", "https://example.com/other")
+		if moved {
+			t.Errorf("Expected items of an existing feed to be kept")
+		}
+
+		_ = store.DeleteItems("https://example.com/other")
+		last, _ = store.LastSequence("https://example.com/other")
+		if last != 0 {
+			t.Errorf("Deleted items are still present, last sequence: %d", last)
+		}
+	})
+
+	t.Run("Test chat settings", func(t *testing.T) {
+		_, err := store.GetChatSettings(1)
+		if err != ErrNotFound {
+			t.Errorf("Expected no chat settings, got: %v", err)
+		}
+
+		_ = store.SaveChatSettings(1, []byte("a"))
+		_ = store.SaveChatSettings(1, []byte("b"))
+
+		data, err := store.GetChatSettings(1)
+		if err != nil || string(data) != "b" {
+			t.Errorf("Chat settings are incorrect, got: %s (%v)", data, err)
+		}
+	})
+
+	t.Run("Test migrations", func(t *testing.T) {
+		migrated, err := store.IsMigrated("test")
+		if err != nil || migrated {
+			t.Errorf("Expected no migration, got: %t (%v)", migrated, err)
+		}
+
+		_ = store.MarkMigrated("test")
+
+		migrated, err = store.IsMigrated("test")
+		if err != nil || !migrated {
+			t.Errorf("Expected the migration to be marked, got: %t (%v)", migrated, err)
+		}
+	})
+
+	t.Run("Test buffers", func(t *testing.T) {
+		err := store.AppendItems(MissedItems, keyA, [][]byte{[]byte("1"), []byte("2")})
+		if err != nil {
+			t.Fatalf("Could not append items: %v", err)
+		}
+
+		_ = store.AppendItems(MissedItems, keyA, [][]byte{[]byte("3")})
+		_ = store.AppendItems(MissedItems, keyB, [][]byte{[]byte("4")})
+
+		items, err := store.TakeItems(MissedItems, keyA)
+		if err != nil || len(items) != 3 || string(items[0]) != "1" || string(items[2]) != "3" {
+			t.Errorf("Buffered items are incorrect, got: %q (%v)", items, err)
+		}
+
+		items, _ = store.TakeItems(MissedItems, keyA)
+		if len(items) != 0 {
+			t.Errorf("Buffer was not emptied, got: %q", items)
+		}
+
+		_ = store.AppendItems(MissedItems, keyC, [][]byte{[]byte("6"), []byte("7")})
+
+		items, err = store.PeekItems(MissedItems, keyC)
+		if err != nil || len(items) != 2 || string(items[0]) != "6" {
+			t.Errorf("Peeked items are incorrect, got: %q (%v)", items, err)
+		}
+
+		_ = store.AppendItems(MissedItems, keyC, [][]byte{[]byte("8")})
+		_ = store.DropItems(MissedItems, keyC, len(items))
+
+		items, _ = store.PeekItems(MissedItems, keyC)
+		if len(items) != 1 || string(items[0]) != "8" {
+			t.Errorf("Expected only the items added after the peek to be kept, got: %q", items)
+		}
+
+		_ = store.DropItems(MissedItems, keyC, 5)
+
+		items, _ = store.PeekItems(MissedItems, keyC)
+		if len(items) != 0 {
+			t.Errorf("Expected all items to be dropped, got: %q", items)
+		}
+
+		_ = store.AppendItems(MissedItems, keyA, [][]byte{[]byte("5")})
+	})
+
+	t.Run("Test delete subscription", func(t *testing.T) {
+		_ = store.SetDigestDue(keyA, time.Now())
+
+		err := store.DeleteSubscription(keyA)
+		if err != nil {
+			t.Fatalf("Could not delete subscription: %v", err)
+		}
+
+		_, err = store.GetSubscription(keyA)
+		if err != ErrNotFound {
+			t.Errorf("Deleted subscription is still present: %v", err)
+		}
+
+		guids, _ := store.GetGuids(keyA)
+		items, _ := store.TakeItems(MissedItems, keyA)
+		first, _ := store.MarkFetched(keyA)
+		_, cursorErr := store.GetCursor(keyA)
+		deliveries, _ := store.GetDeliveries(keyA, []string{"2"})
+		if len(guids) != 0 || len(items) != 0 || !first || cursorErr != ErrNotFound || len(deliveries) != 0 {
+			t.Errorf("Deleted subscription state is still present, guids: %v, first fetch: %t", guids, first)
+		}
+
+		dueDigests, _ := store.GetDueDigests(time.Now())
+		if len(dueDigests) != 0 {
+			t.Errorf("Digest of the deleted subscription is still due: %v", dueDigests)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package subscription
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rss-telegram/internal/storage"
+	"time"
+)
+
+// DeliveryMode selects whether items are sent instantly or collected into a digest
+type DeliveryMode string
+
+const (
+	DeliverInstantly DeliveryMode = ""
+	DeliverHourly    DeliveryMode = "hourly"
+	DeliverDaily     DeliveryMode = "daily"
+	DeliverWeekly    DeliveryMode = "weekly"
+)
+
+var DeliveryModes = []DeliveryMode{DeliverInstantly, DeliverHourly, DeliverDaily, DeliverWeekly}
+
+// DefaultDigestTime is used by daily and weekly digests without a chosen time
+const DefaultDigestTime = "08:00"
+
+func (mode DeliveryMode) String() string {
+	switch mode {
+	case DeliverHourly:
+		return "Hourly digest"
+	case DeliverDaily:
+		return "Daily digest"
+	case DeliverWeekly:
+		return "Weekly digest"
+	default:
+		return "Instant"
+	}
+}
+
+// ParseDeliveryMode accepts the names returned by String
+func ParseDeliveryMode(value string) (DeliveryMode, error) {
+	for _, mode := range DeliveryModes {
+		if mode.String() == value {
+			return mode, nil
+		}
+	}
+
+	return DeliverInstantly, fmt.Errorf("unknown delivery mode %s", value)
+}
+
+// ParseDigestTime accepts a time of day like 08:00 or 18:30
+func ParseDigestTime(value string) (string, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return "", fmt.Errorf("invalid digest time %s", value)
+	}
+
+	return parsed.Format("15:04"), nil
+}
+
+// IsDigest reports whether the items of the subscription are collected
+func (subscription *Subscription) IsDigest() bool {
+	return subscription.Delivery != DeliverInstantly
+}
+
+// NextDigest returns the first digest time after now in the location of now.
+// Hourly digests are sent at full hours, weekly digests on mondays.
+func (subscription *Subscription) NextDigest(now time.Time) time.Time {
+	if subscription.Delivery == DeliverHourly {
+		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, now.Location())
+	}
+
+	digestTime, err := time.Parse("15:04", subscription.DigestTime)
+	if err != nil {
+		digestTime, _ = time.Parse("15:04", DefaultDigestTime)
+	}
+
+	next := time.Date(now.Year(), now.Month(), now.Day(), digestTime.Hour(), digestTime.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	if subscription.Delivery == DeliverWeekly {
+		next = next.AddDate(0, 0, (int(time.Monday)-int(next.Weekday())+7)%7)
+	}
+
+	return next
+}
+
+// BufferDigestItems collects items for the next digest of the subscription
+func (subscriptionHandler *SubscriptionHandler) BufferDigestItems(subscription *Subscription, items []*BufferedItem, now time.Time) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	data := make([][]byte, len(items))
+	for i, item := range items {
+		itemBytes, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		data[i] = itemBytes
+	}
+
+	err := subscriptionHandler.Options.Store.AppendItems(storage.DigestItems, subscription.Key(), data)
+	if err != nil {
+		return err
+	}
+
+	_, err = subscriptionHandler.Options.Store.GetDigestDue(subscription.Key())
+	if errors.Is(err, storage.ErrNotFound) {
+		return subscriptionHandler.ScheduleDigest(subscription, now)
+	}
+
+	return err
+}
+
+// TakeDigestItems removes and returns the collected items of the subscription
+func (subscriptionHandler *SubscriptionHandler) TakeDigestItems(subscription *Subscription) ([]*BufferedItem, error) {
+	data, err := subscriptionHandler.Options.Store.TakeItems(storage.DigestItems, subscription.Key())
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]*BufferedItem, 0, len(data))
+	for _, itemBytes := range data {
+		var item BufferedItem
+		err = json.Unmarshal(itemBytes, &item)
+		if err != nil {
+			return nil, err
+		}
+
+		output = append(output, &item)
+	}
+
+	return output, nil
+}
+
+// ScheduleDigest sets the time of the next digest, subscriptions that switched
+// to instant delivery get their collected items at once
+func (subscriptionHandler *SubscriptionHandler) ScheduleDigest(subscription *Subscription, now time.Time) error {
+	if !subscription.IsDigest() {
+		return subscriptionHandler.Options.Store.SetDigestDue(subscription.Key(), now)
+	}
+
+	return subscriptionHandler.Options.Store.SetDigestDue(subscription.Key(), subscription.NextDigest(now))
+}
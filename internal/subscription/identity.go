@@ -0,0 +1,35 @@
+package subscription
+
+import "fmt"
+
+// ItemIdentity selects how the items of a feed are recognized as seen
+type ItemIdentity string
+
+const (
+	// IdentityAutomatic uses the guid, the link or a hash of title, published date and content
+	IdentityAutomatic ItemIdentity = ""
+	// IdentityContent uses a hash of the content for feeds that change their guids on every build
+	IdentityContent ItemIdentity = "content"
+)
+
+var ItemIdentities = []ItemIdentity{IdentityAutomatic, IdentityContent}
+
+func (identity ItemIdentity) String() string {
+	switch identity {
+	case IdentityContent:
+		return "Content hash"
+	default:
+		return "Automatic"
+	}
+}
+
+// ParseItemIdentity accepts the names returned by String
+func ParseItemIdentity(value string) (ItemIdentity, error) {
+	for _, identity := range ItemIdentities {
+		if identity.String() == value {
+			return identity, nil
+		}
+	}
+
+	return IdentityAutomatic, fmt.Errorf("unknown item identity %s", value)
+}
@@ -0,0 +1,66 @@
+package subscription
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"rss-telegram/internal/utils"
+	"slices"
+)
+
+// MigrateCanonicalURLs rewrites subscriptions stored before urls were
+// canonicalized. If a chat is subscribed to several spellings of the same feed
+// only the oldest subscription is kept. The migration runs once per store.
+func (subscriptionHandler *SubscriptionHandler) MigrateCanonicalURLs() error {
+	migrated, err := subscriptionHandler.Options.Store.IsMigrated("canonical-urls")
+	if err != nil || migrated {
+		return err
+	}
+
+	subscriptions, err := subscriptionHandler.GetAllSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(subscriptions, func(a, b *Subscription) int {
+		return a.CreationDate.Compare(b.CreationDate)
+	})
+
+	seen := make(map[string]bool)
+	rewritten := 0
+	merged := 0
+
+	for _, sub := range subscriptions {
+		canonical := utils.CanonicalURL(sub.URL)
+
+		feedKey := fmt.Sprintf("%d:%s", sub.ChatId, canonical.String())
+		if seen[feedKey] {
+			log.Info().Msgf("Merging duplicate subscription %s of %d into %s", sub.URL.String(), sub.ChatId, canonical.String())
+
+			subscriptionHandler.DeleteSubscription(sub.ChatId, sub)
+			merged++
+			continue
+		}
+
+		seen[feedKey] = true
+
+		if canonical.String() == sub.URL.String() {
+			continue
+		}
+
+		updated := *sub
+		updated.URL = canonical
+
+		err = subscriptionHandler.UpdateSubscription(sub.ChatId, &updated)
+		if err != nil {
+			return err
+		}
+
+		rewritten++
+	}
+
+	if rewritten > 0 || merged > 0 {
+		log.Info().Msgf("Canonicalized %d subscription urls and merged %d duplicates", rewritten, merged)
+	}
+
+	return subscriptionHandler.Options.Store.MarkMigrated("canonical-urls")
+}
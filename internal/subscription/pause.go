@@ -0,0 +1,115 @@
+package subscription
+
+import (
+	"encoding/json"
+	"fmt"
+	"rss-telegram/internal/storage"
+	"time"
+)
+
+type BufferedItem struct {
+	Title string `json:"title"`
+	Link  string `json:"link"`
+}
+
+// IsPaused reports whether items of the subscription are currently held back
+func (subscription *Subscription) IsPaused(now time.Time) bool {
+	return subscription.Paused || subscription.SnoozedUntil != nil && subscription.SnoozedUntil.After(now)
+}
+
+// SnoozeExpired reports whether the subscription was snoozed and the snooze is over
+func (subscription *Subscription) SnoozeExpired(now time.Time) bool {
+	return !subscription.Paused && subscription.SnoozedUntil != nil && !subscription.SnoozedUntil.After(now)
+}
+
+func (subscriptionHandler *SubscriptionHandler) PauseSubscription(chatId int64, subscription *Subscription, until *time.Time) error {
+	return subscriptionHandler.updatePause(chatId, subscription.Key(), func(paused *Subscription) {
+		if until == nil {
+			paused.Paused = true
+			paused.SnoozedUntil = nil
+		} else {
+			paused.Paused = false
+			paused.SnoozedUntil = until
+		}
+	})
+}
+
+// ResumeSubscription clears the paused state, the missed items are kept until
+// they are dropped after they were delivered or skipped
+func (subscriptionHandler *SubscriptionHandler) ResumeSubscription(chatId int64, subscription *Subscription) error {
+	return subscriptionHandler.updatePause(chatId, subscription.Key(), func(resumed *Subscription) {
+		resumed.Paused = false
+		resumed.SnoozedUntil = nil
+	})
+}
+
+// updatePause changes the paused state of the stored subscription, the other
+// fields may have changed since the subscription was loaded and are kept
+func (subscriptionHandler *SubscriptionHandler) updatePause(chatId int64, key storage.SubscriptionKey, update func(subscription *Subscription)) error {
+	data, err := subscriptionHandler.Options.Store.GetSubscription(key)
+	if err != nil {
+		return err
+	}
+
+	var subscription Subscription
+	err = json.Unmarshal(data, &subscription)
+	if err != nil {
+		return err
+	}
+
+	update(&subscription)
+
+	return subscriptionHandler.UpdateSubscription(chatId, &subscription)
+}
+
+func (subscriptionHandler *SubscriptionHandler) BufferMissedItems(subscription *Subscription, items []*BufferedItem) error {
+	data := make([][]byte, len(items))
+	for i, item := range items {
+		itemBytes, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		data[i] = itemBytes
+	}
+
+	return subscriptionHandler.Options.Store.AppendItems(storage.MissedItems, subscription.Key(), data)
+}
+
+// PeekMissedItems returns the items missed while the subscription was paused,
+// they stay buffered until DropMissedItems removes them
+func (subscriptionHandler *SubscriptionHandler) PeekMissedItems(subscription *Subscription) ([]*BufferedItem, error) {
+	data, err := subscriptionHandler.Options.Store.PeekItems(storage.MissedItems, subscription.Key())
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]*BufferedItem, 0, len(data))
+	for _, itemBytes := range data {
+		var item BufferedItem
+		err = json.Unmarshal(itemBytes, &item)
+		if err != nil {
+			return nil, err
+		}
+
+		output = append(output, &item)
+	}
+
+	return output, nil
+}
+
+// DropMissedItems removes the first count missed items, items missed after
+// they were peeked are kept
+func (subscriptionHandler *SubscriptionHandler) DropMissedItems(subscription *Subscription, count int) error {
+	return subscriptionHandler.Options.Store.DropItems(storage.MissedItems, subscription.Key(), count)
+}
+
+func RenderItemList(header string, items []*BufferedItem) string {
+	output := header + "\n"
+
+	for _, item := range items {
+		output += fmt.Sprintf("\n• %s\n%s", item.Title, item.Link)
+	}
+
+	return output
+}
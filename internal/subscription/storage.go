@@ -2,10 +2,13 @@ package subscription
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"net/url"
+	"rss-telegram/internal/storage"
+	"strings"
 	"time"
 )
 
@@ -15,21 +18,39 @@ type Subscription struct {
 	URL           *url.URL  `json:"url"`
 	SearchPattern string    `json:"searchPattern"`
 	CreationDate  time.Time `json:"creationDate"`
+
+	// Interval overrides the default polling interval if set
+	Interval time.Duration `json:"interval,omitempty"`
+	Identity ItemIdentity  `json:"identity,omitempty"`
+	Updates  UpdateMode    `json:"updates,omitempty"`
+	// MaxItems overrides the default number of items sent per fetch if set
+	MaxItems int `json:"maxItems,omitempty"`
+	// Delivery collects the items into digests, daily and weekly digests are
+	// sent at DigestTime
+	Delivery   DeliveryMode `json:"delivery,omitempty"`
+	DigestTime string       `json:"digestTime,omitempty"`
+
+	Paused       bool       `json:"paused"`
+	SnoozedUntil *time.Time `json:"snoozedUntil,omitempty"`
 }
 
-func (subscriptionHandler *SubscriptionHandler) AddSubscription(chatId int64, subscription *Subscription) (string, error) {
-	key := fmt.Sprintf("subscription:%d:%s", chatId, subscription.Id.String())
+func (subscriptionHandler *SubscriptionHandler) AddSubscription(chatId int64, subscription *Subscription) (storage.SubscriptionKey, error) {
+	key := subscription.Key()
 
 	subscriptionBytes, err := json.Marshal(subscription)
 	if err != nil {
-		return "", err
+		return key, err
 	}
 
-	err = subscriptionHandler.Options.RedisDb.Set(subscriptionHandler.Context, key, subscriptionBytes, 0).Err()
+	err = subscriptionHandler.Options.Store.SaveSubscription(key, subscriptionBytes)
 	if err != nil {
-		return "", err
+		return key, err
 	}
 
+	subscriptionHandler.lock.Lock()
+	subscriptionHandler.subscriptionsCache[key] = subscription
+	subscriptionHandler.lock.Unlock()
+
 	if subscriptionHandler.ReaderEventListener != nil {
 		subscriptionHandler.ReaderEventListener.AddSubscription(subscription)
 	}
@@ -39,10 +60,44 @@ func (subscriptionHandler *SubscriptionHandler) AddSubscription(chatId int64, su
 	return key, err
 }
 
+// UpdateSubscription replaces a stored subscription while keeping its guids and first fetch marker
+func (subscriptionHandler *SubscriptionHandler) UpdateSubscription(chatId int64, subscription *Subscription) error {
+	key := subscription.Key()
+
+	subscriptionBytes, err := json.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+
+	err = subscriptionHandler.Options.Store.SaveSubscription(key, subscriptionBytes)
+	if err != nil {
+		return err
+	}
+
+	subscriptionHandler.lock.Lock()
+	subscriptionHandler.subscriptionsCache[key] = subscription
+	subscriptionHandler.lock.Unlock()
+
+	if subscriptionHandler.ReaderEventListener != nil {
+		subscriptionHandler.ReaderEventListener.UpdateSubscription(subscription)
+	}
+
+	log.Info().Msgf("User %d updated subscription %s", chatId, subscription.URL.String())
+
+	return nil
+}
+
 func (subscriptionHandler *SubscriptionHandler) DeleteSubscription(chatId int64, subscription *Subscription) {
-	_ = subscriptionHandler.Options.RedisDb.Del(subscriptionHandler.Context, fmt.Sprintf("subscription:%d:%s", chatId, subscription.Id.String())).Err()
-	_ = subscriptionHandler.Options.RedisDb.Del(subscriptionHandler.Context, fmt.Sprintf("post-fetch:%d:%s", chatId, subscription.Id.String())).Err()
-	_ = subscriptionHandler.Options.RedisDb.Del(subscriptionHandler.Context, fmt.Sprintf("guids:%d:%s", chatId, subscription.Id.String())).Err()
+	key := subscription.Key()
+
+	err := subscriptionHandler.Options.Store.DeleteSubscription(key)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed deleting subscription %s of %d", subscription.URL.String(), chatId)
+	}
+
+	subscriptionHandler.lock.Lock()
+	delete(subscriptionHandler.subscriptionsCache, key)
+	subscriptionHandler.lock.Unlock()
 
 	if subscriptionHandler.ReaderEventListener != nil {
 		subscriptionHandler.ReaderEventListener.RemoveSubscription(subscription)
@@ -51,45 +106,71 @@ func (subscriptionHandler *SubscriptionHandler) DeleteSubscription(chatId int64,
 	log.Info().Msgf("User %d unsubscribed from %s", chatId, subscription.URL.String())
 }
 
-func (subscriptionHandler *SubscriptionHandler) NewSubscription(url *url.URL, chatId int64, searchPattern string) *Subscription {
+func (subscriptionHandler *SubscriptionHandler) NewSubscription(url *url.URL, chatId int64, searchPattern string, interval time.Duration) *Subscription {
 	return &Subscription{
 		Id:            uuid.New(),
 		ChatId:        chatId,
 		URL:           url,
 		SearchPattern: searchPattern,
 		CreationDate:  time.Now(),
+		Interval:      interval,
 	}
 }
 
-func (subscriptionHandler *SubscriptionHandler) GetAllSubscriptions() ([]*Subscription, error) {
-	return subscriptionHandler.GetSubscriptions("*")
+func (subscriptionHandler *SubscriptionHandler) GetSubscription(key storage.SubscriptionKey) (*Subscription, error) {
+	subscriptions, err := subscriptionHandler.GetSubscriptions([]storage.SubscriptionKey{key})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(subscriptions) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return subscriptions[0], nil
 }
 
-func (subscriptionHandler *SubscriptionHandler) GetSubscriptionsFromChat(chatId int64) ([]*Subscription, error) {
-	return subscriptionHandler.GetSubscriptions(fmt.Sprintf("%d:*", chatId))
+func (subscriptionHandler *SubscriptionHandler) GetAllSubscriptions() ([]*Subscription, error) {
+	keys, err := subscriptionHandler.Options.Store.GetAllSubscriptionKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	return subscriptionHandler.GetSubscriptions(keys)
 }
 
-func (subscriptionHandler *SubscriptionHandler) GetSubscriptions(suffix string) ([]*Subscription, error) {
-	keys, err := subscriptionHandler.Options.RedisDb.Keys(subscriptionHandler.Context, fmt.Sprintf("subscription:%s", suffix)).Result()
+func (subscriptionHandler *SubscriptionHandler) GetSubscriptionsFromChat(chatId int64) ([]*Subscription, error) {
+	keys, err := subscriptionHandler.Options.Store.GetSubscriptionKeys(chatId)
 	if err != nil {
 		return nil, err
 	}
 
-	output := make([]*Subscription, len(keys))
-	for i, key := range keys {
+	return subscriptionHandler.GetSubscriptions(keys)
+}
+
+func (subscriptionHandler *SubscriptionHandler) GetSubscriptions(keys []storage.SubscriptionKey) ([]*Subscription, error) {
+	output := make([]*Subscription, 0, len(keys))
+	for _, key := range keys {
+		subscriptionHandler.lock.Lock()
 		foundItem, ok := subscriptionHandler.subscriptionsCache[key]
+		subscriptionHandler.lock.Unlock()
+
 		if ok {
-			output[i] = foundItem
+			output = append(output, foundItem)
 			continue
 		}
 
-		val, err := subscriptionHandler.Options.RedisDb.Get(subscriptionHandler.Context, key).Result()
+		val, err := subscriptionHandler.Options.Store.GetSubscription(key)
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Warn().Msgf("Subscription %s is indexed but does not exist", key)
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
 
 		var subscription Subscription
-		err = json.Unmarshal([]byte(val), &subscription)
+		err = json.Unmarshal(val, &subscription)
 		if err != nil {
 			return nil, err
 		}
@@ -98,12 +179,16 @@ func (subscriptionHandler *SubscriptionHandler) GetSubscriptions(suffix string)
 		subscriptionHandler.subscriptionsCache[key] = &subscription
 		subscriptionHandler.lock.Unlock()
 
-		output[i] = &subscription
+		output = append(output, &subscription)
 	}
 
 	return output, nil
 }
 
+func (subscription *Subscription) Key() storage.SubscriptionKey {
+	return storage.SubscriptionKey{ChatId: subscription.ChatId, Id: subscription.Id.String()}
+}
+
 func (subscription *Subscription) String() string {
 	urlString := subscription.URL.String()
 	date := subscription.CreationDate.Format("01-02-2006 15:04:05")
@@ -113,5 +198,48 @@ func (subscription *Subscription) String() string {
 		patternText = fmt.Sprintf("with pattern %s", subscription.SearchPattern)
 	}
 
-	return fmt.Sprintf("%s %s, added %s", urlString, patternText, date)
+	intervalText := ""
+	if subscription.Interval > 0 {
+		intervalText = fmt.Sprintf(", checked every %s", subscription.Interval)
+	}
+
+	maxItemsText := ""
+	if subscription.MaxItems > 0 {
+		maxItemsText = fmt.Sprintf(", at most %d items per check", subscription.MaxItems)
+	}
+
+	deliveryText := ""
+	switch subscription.Delivery {
+	case DeliverHourly:
+		deliveryText = ", hourly digest"
+	case DeliverDaily, DeliverWeekly:
+		digestTime := subscription.DigestTime
+		if digestTime == "" {
+			digestTime = DefaultDigestTime
+		}
+
+		deliveryText = fmt.Sprintf(", %s at %s", strings.ToLower(subscription.Delivery.String()), digestTime)
+	}
+
+	identityText := ""
+	if subscription.Identity != IdentityAutomatic {
+		identityText = fmt.Sprintf(", items identified by %s", strings.ToLower(subscription.Identity.String()))
+	}
+
+	updatesText := ""
+	switch subscription.Updates {
+	case UpdatesEdit:
+		updatesText = ", updated items are edited"
+	case UpdatesReply:
+		updatesText = ", updated items are sent as reply"
+	}
+
+	stateText := ""
+	if subscription.Paused {
+		stateText = ", paused"
+	} else if subscription.SnoozedUntil != nil && subscription.SnoozedUntil.After(time.Now()) {
+		stateText = fmt.Sprintf(", snoozed until %s", subscription.SnoozedUntil.Format("01-02-2006 15:04:05"))
+	}
+
+	return fmt.Sprintf("%s %s%s%s%s%s%s, added %s%s", urlString, patternText, intervalText, maxItemsText, deliveryText, identityText, updatesText, date, stateText)
 }
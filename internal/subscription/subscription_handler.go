@@ -2,18 +2,18 @@ package subscription
 
 import (
 	"context"
-	"github.com/redis/go-redis/v9"
+	"rss-telegram/internal/storage"
 	"sync"
 )
 
 type SubscriptionHandlerOptions struct {
-	RedisDb *redis.Client
+	Store storage.Store
 }
 
 type SubscriptionHandler struct {
 	Options            *SubscriptionHandlerOptions
 	Context            context.Context
-	subscriptionsCache map[string]*Subscription
+	subscriptionsCache map[storage.SubscriptionKey]*Subscription
 	lock               sync.Mutex
 
 	ReaderEventListener *ReaderEventListener
@@ -22,13 +22,14 @@ type SubscriptionHandler struct {
 type ReaderEventListener struct {
 	AddSubscription    func(subscription *Subscription)
 	RemoveSubscription func(subscription *Subscription)
+	UpdateSubscription func(subscription *Subscription)
 }
 
 func NewSubscriptionHandler(options *SubscriptionHandlerOptions) *SubscriptionHandler {
 	subscriptionHandler := &SubscriptionHandler{
 		Options:            options,
 		Context:            context.Background(),
-		subscriptionsCache: make(map[string]*Subscription),
+		subscriptionsCache: make(map[storage.SubscriptionKey]*Subscription),
 	}
 
 	return subscriptionHandler
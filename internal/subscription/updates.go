@@ -0,0 +1,83 @@
+package subscription
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// UpdateMode selects how changes of already delivered items are announced
+type UpdateMode string
+
+const (
+	// UpdatesIgnore does not announce changed items
+	UpdatesIgnore UpdateMode = ""
+	// UpdatesEdit edits the message that delivered the item
+	UpdatesEdit UpdateMode = "edit"
+	// UpdatesReply sends the changed item as reply to the delivered message
+	UpdatesReply UpdateMode = "reply"
+)
+
+var UpdateModes = []UpdateMode{UpdatesIgnore, UpdatesEdit, UpdatesReply}
+
+func (mode UpdateMode) String() string {
+	switch mode {
+	case UpdatesEdit:
+		return "Edit message"
+	case UpdatesReply:
+		return "Reply"
+	default:
+		return "Ignore"
+	}
+}
+
+// ParseUpdateMode accepts the names returned by String
+func ParseUpdateMode(value string) (UpdateMode, error) {
+	for _, mode := range UpdateModes {
+		if mode.String() == value {
+			return mode, nil
+		}
+	}
+
+	return UpdatesIgnore, fmt.Errorf("unknown update mode %s", value)
+}
+
+// Delivery remembers the message an item was sent with and the fingerprint of its content
+type Delivery struct {
+	MessageId   int    `json:"messageId"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+func (subscriptionHandler *SubscriptionHandler) SaveDelivery(subscription *Subscription, itemId string, delivery *Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	return subscriptionHandler.Options.Store.SaveDelivery(subscription.Key(), itemId, data)
+}
+
+// GetDeliveries returns the deliveries of the item ids that were sent to the subscription
+func (subscriptionHandler *SubscriptionHandler) GetDeliveries(subscription *Subscription, itemIds []string) (map[string]*Delivery, error) {
+	data, err := subscriptionHandler.Options.Store.GetDeliveries(subscription.Key(), itemIds)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(map[string]*Delivery, len(data))
+	for itemId, deliveryBytes := range data {
+		var delivery Delivery
+		err = json.Unmarshal(deliveryBytes, &delivery)
+		if err != nil {
+			return nil, err
+		}
+
+		output[itemId] = &delivery
+	}
+
+	return output, nil
+}
+
+// PruneDeliveries forgets the deliveries of items that left the feed
+func (subscriptionHandler *SubscriptionHandler) PruneDeliveries(subscription *Subscription, itemIds []string) error {
+	return subscriptionHandler.Options.Store.PruneDeliveries(subscription.Key(), itemIds)
+}
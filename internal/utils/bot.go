@@ -2,37 +2,59 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 	"strings"
 )
 
-func SendChunkedMessage(text string, ctx context.Context, b *bot.Bot, chatId int64, chunkSize int, replyMarkup models.ReplyMarkup) {
-	lines := strings.Split(text, "\n")
+// MessageSender is implemented by the bot and by the rate limited dispatcher
+type MessageSender interface {
+	SendMessage(ctx context.Context, params *bot.SendMessageParams) (*models.Message, error)
+}
+
+// SendChunkedMessage splits the text at line breaks into messages of at most
+// chunkSize bytes and returns the errors of the chunks that were not sent
+func SendChunkedMessage(text string, ctx context.Context, sender MessageSender, chatId int64, chunkSize int, replyMarkup models.ReplyMarkup) error {
+	var errs []error
+
+	for _, chunk := range SplitMessage(text, chunkSize) {
+		_, err := sender.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      chatId,
+			Text:        chunk,
+			ReplyMarkup: replyMarkup,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		replyMarkup = nil
+	}
+
+	return errors.Join(errs...)
+}
+
+// SplitMessage splits the text at line breaks into chunks of at most
+// chunkSize bytes, longer lines are cut
+func SplitMessage(text string, chunkSize int) []string {
+	var chunks []string
 	var chunk string
 
-	appendReplyMarkup := replyMarkup
+	add := func(text string) {
+		if text != "" {
+			chunks = append(chunks, text)
+		}
+	}
 
-	for _, line := range lines {
+	for _, line := range strings.Split(text, "\n") {
 		for len(line) > chunkSize {
-			part := line[:chunkSize]
-			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
-				ChatID:      chatId,
-				Text:        part,
-				ReplyMarkup: appendReplyMarkup,
-			})
+			add(line[:chunkSize])
 			line = line[chunkSize:]
-			appendReplyMarkup = nil
 		}
 
 		if len(chunk)+len(line)+1 > chunkSize {
-			_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
-				ChatID:      chatId,
-				Text:        chunk,
-				ReplyMarkup: appendReplyMarkup,
-			})
+			add(chunk)
 			chunk = ""
-			appendReplyMarkup = nil
 		}
 
 		if chunk != "" {
@@ -41,11 +63,7 @@ func SendChunkedMessage(text string, ctx context.Context, b *bot.Bot, chatId int
 		chunk += line
 	}
 
-	if chunk != "" {
-		_, _ = b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID:      chatId,
-			Text:        chunk,
-			ReplyMarkup: appendReplyMarkup,
-		})
-	}
+	add(chunk)
+
+	return chunks
 }
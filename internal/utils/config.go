@@ -6,12 +6,34 @@ func LoadConfig() error {
 	return config.LoadConfigWithOptions([]config.Value{
 		config.String("LOG_LEVEL").NotEmpty().Default("info"),
 		config.String("BOT_TOKEN").NotEmpty().Sensitive(),
+		config.Int("BOT_GLOBAL_RATE").Default(30),
+		config.Int("BOT_GROUP_RATE").Default(20),
+		config.Int("BOT_CHAT_RATE").Default(1),
+		config.Int("BOT_RETRIES").Default(3),
+		config.Int("BOT_QUEUE_ATTEMPTS").Default(5),
+		config.Int("BOT_QUEUE_INTERVAL").Default(60),
+		config.StringArray("ADMIN_CHAT_IDS").Default([]string{}),
+
+		config.String("STORAGE_BACKEND").NotEmpty().Default("redis"),
+		config.String("STORAGE_PATH").NotEmpty().Default("rss-telegram.db"),
 
 		config.String("REDIS_HOST").NotEmpty().Default("redis:6379"),
 		config.String("REDIS_PASSWORD").Default("").Sensitive(),
 		config.Int("REDIS_DB").Default(0),
 
+		config.Int("CHAT_CONTEXT_TTL").Default(86400),
+
 		config.Int("RSS_INTERVAL").Default(60),
+		config.Int("RSS_WORKERS").Default(8),
+		config.Int("RSS_HOST_SPACING").Default(2),
+		config.Int("RSS_ITEM_RETENTION").Default(1000),
+		config.Int("RSS_ITEM_MAX_AGE").Default(2592000),
+		config.Int("RSS_MAX_ITEMS").Default(10),
+		config.Int("RSS_CHAT_HOURLY_LIMIT").Default(60),
 		config.Int("RSS_429_TIMEOUT").Default(300),
+		config.Int("RSS_BACKOFF_BASE").Default(60),
+		config.Int("RSS_BACKOFF_MAX").Default(21600),
+		config.Int("RSS_DEAD_AFTER").Default(259200),
+		config.Int("RSS_SUSPEND_AFTER").Default(1209600),
 	}, &config.LoadConfigOptions{DotEnvFile: "rss-telegram.env"})
 }
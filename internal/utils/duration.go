@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration extends time.ParseDuration with the units d (days) and w (weeks)
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+
+	for unit, factor := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		number, ok := strings.CutSuffix(value, unit)
+		if !ok {
+			continue
+		}
+
+		count, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			break
+		}
+
+		return time.Duration(count * float64(factor)), nil
+	}
+
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,416 @@
+package utils
+
+import (
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// formattingTags maps feed html tags to the tags telegram supports
+var formattingTags = map[string]string{
+	"b":      "b",
+	"strong": "b",
+	"i":      "i",
+	"em":     "i",
+	"cite":   "i",
+	"u":      "u",
+	"ins":    "u",
+	"s":      "s",
+	"strike": "s",
+	"del":    "s",
+	"code":   "code",
+	"kbd":    "code",
+	"tt":     "code",
+	"samp":   "code",
+}
+
+// blockTags end the current line, the value is the number of line breaks
+var blockTags = map[string]int{
+	"p":          2,
+	"div":        1,
+	"section":    1,
+	"article":    1,
+	"header":     1,
+	"footer":     1,
+	"figure":     1,
+	"figcaption": 1,
+	"table":      1,
+	"tr":         1,
+	"dl":         1,
+	"dt":         1,
+	"dd":         1,
+	"hr":         2,
+}
+
+// skippedTags are dropped together with their content
+var skippedTags = []string{"script", "style", "noscript", "iframe", "object", "svg", "template", "head", "title", "form", "select"}
+
+var linkSchemes = []string{"http", "https", "mailto", "tg"}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+// EscapeHTML escapes text for messages sent with the html parse mode
+func EscapeHTML(text string) string {
+	return textEscaper.Replace(text)
+}
+
+// SanitizeHTML converts feed html into the html subset of telegram. Unknown
+// tags are removed, paragraphs and lists become line breaks, relative links
+// are resolved against the base url and unclosed tags are closed. The visible
+// text is cut at limit characters unless limit is 0.
+func SanitizeHTML(input string, baseUrl *url.URL, limit int) string {
+	converter := &htmlConverter{baseUrl: baseUrl, limit: limit}
+
+	return converter.convert(input)
+}
+
+// HTMLToText removes the tags of a message in the telegram html subset and
+// keeps its text as it is, the targets of links are added after their text.
+// It is used if telegram rejects the html of a message.
+func HTMLToText(input string) string {
+	converter := &htmlConverter{plain: true}
+
+	return converter.convert(input)
+}
+
+type openTag struct {
+	// source is the name of the tag in the feed, name the tag that is written
+	source  string
+	name    string
+	open    string
+	href    string
+	written bool
+}
+
+type htmlList struct {
+	ordered bool
+	count   int
+}
+
+type htmlConverter struct {
+	baseUrl *url.URL
+	limit   int
+	plain   bool
+
+	output strings.Builder
+	stack  []*openTag
+	lists  []*htmlList
+
+	breaks int
+	space  bool
+	prefix string
+
+	skipped   string
+	skipDepth int
+	length    int
+	truncated bool
+}
+
+func (converter *htmlConverter) convert(input string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(input))
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				converter.text(string(tokenizer.Raw()))
+			}
+
+			break
+		}
+
+		switch tokenType {
+		case html.TextToken:
+			if converter.skipDepth == 0 {
+				converter.text(string(tokenizer.Text()))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttributes := tokenizer.TagName()
+
+			attributes := make(map[string]string)
+			for hasAttributes {
+				var key, value []byte
+				key, value, hasAttributes = tokenizer.TagAttr()
+				attributes[strings.ToLower(string(key))] = string(value)
+			}
+
+			converter.start(string(name), attributes, tokenType == html.SelfClosingTagToken)
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+
+			converter.end(string(name))
+		}
+	}
+
+	for i := len(converter.stack) - 1; i >= 0; i-- {
+		converter.close(converter.stack[i])
+	}
+
+	return converter.output.String()
+}
+
+func (converter *htmlConverter) start(name string, attributes map[string]string, selfClosing bool) {
+	if converter.skipDepth > 0 {
+		if name == converter.skipped && !selfClosing {
+			converter.skipDepth++
+		}
+
+		return
+	}
+
+	if slices.Contains(skippedTags, name) {
+		if !selfClosing {
+			converter.skipped = name
+			converter.skipDepth = 1
+		}
+
+		return
+	}
+
+	if breaks, ok := blockTags[name]; ok {
+		converter.lineBreak(breaks)
+		return
+	}
+
+	switch name {
+	case "br":
+		converter.breaks = min(converter.breaks+1, 2)
+	case "td", "th":
+		converter.space = true
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		converter.lineBreak(2)
+
+		converter.pushUnless(converter.isCode(), name, "b", "<b>", "")
+	case "ul", "ol":
+		converter.lineBreak(1)
+		converter.lists = append(converter.lists, &htmlList{ordered: name == "ol"})
+	case "li":
+		converter.lineBreak(1)
+		converter.prefix = converter.listPrefix()
+	case "blockquote":
+		converter.lineBreak(1)
+
+		// telegram does not support nested quotes
+		converter.pushUnless(converter.isCode() || converter.isOpen("blockquote"), name, "blockquote", "<blockquote>", "")
+	case "pre":
+		converter.lineBreak(1)
+
+		converter.pushUnless(converter.isCode(), name, "pre", "<pre>", "")
+	case "a":
+		href := converter.resolveLink(attributes["href"])
+
+		converter.pushUnless(href == "" || converter.isCode() || converter.isOpen("a"), name, "a", fmt.Sprintf("<a href=\"%s\">", attributeEscaper.Replace(href)), href)
+	default:
+		tag, ok := formattingTags[name]
+		if !ok {
+			return
+		}
+
+		converter.pushUnless(converter.isCode(), name, tag, "<"+tag+">", "")
+	}
+}
+
+func (converter *htmlConverter) end(name string) {
+	if converter.skipDepth > 0 {
+		if name == converter.skipped {
+			converter.skipDepth--
+		}
+
+		return
+	}
+
+	if breaks, ok := blockTags[name]; ok {
+		converter.lineBreak(breaks)
+		return
+	}
+
+	switch name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		converter.pop(name)
+		converter.lineBreak(2)
+	case "ul", "ol":
+		if len(converter.lists) > 0 {
+			converter.lists = converter.lists[:len(converter.lists)-1]
+		}
+
+		converter.lineBreak(1)
+	case "li", "blockquote", "pre":
+		converter.pop(name)
+		converter.lineBreak(1)
+	default:
+		converter.pop(name)
+	}
+}
+
+// text writes the pending line breaks, the list prefix and the open tags
+// before the escaped text, whitespace outside of pre is collapsed
+func (converter *htmlConverter) text(text string) {
+	if converter.truncated {
+		return
+	}
+
+	trailing := false
+
+	if !converter.plain && !converter.isOpen("pre") {
+		converter.space = converter.space || strings.TrimLeftFunc(text, unicode.IsSpace) != text
+		trailing = strings.TrimRightFunc(text, unicode.IsSpace) != text
+
+		text = strings.Join(strings.Fields(text), " ")
+	} else if pre := converter.find("pre"); pre != nil && !pre.written {
+		// a line break right after <pre> is not part of the content
+		text = strings.TrimPrefix(text, "\n")
+	}
+
+	if text == "" {
+		return
+	}
+
+	if converter.output.Len() > 0 && !converter.plain {
+		if converter.breaks > 0 {
+			converter.output.WriteString(strings.Repeat("\n", converter.breaks))
+		} else if converter.space {
+			converter.output.WriteString(" ")
+		}
+	}
+
+	if converter.prefix != "" {
+		converter.output.WriteString(converter.prefix)
+	}
+
+	for _, tag := range converter.stack {
+		if !tag.written && !converter.plain && tag.name != "" {
+			converter.output.WriteString(tag.open)
+		}
+
+		tag.written = true
+	}
+
+	if converter.limit > 0 && converter.length+utf8.RuneCountInString(text) > converter.limit {
+		runes := []rune(text)
+		text = strings.TrimRightFunc(string(runes[:converter.limit-converter.length]), unicode.IsSpace) + "…"
+		converter.truncated = true
+	}
+
+	converter.length += utf8.RuneCountInString(text)
+
+	if converter.plain {
+		converter.output.WriteString(text)
+	} else {
+		converter.output.WriteString(EscapeHTML(text))
+	}
+
+	converter.breaks = 0
+	converter.space = trailing
+	converter.prefix = ""
+}
+
+func (converter *htmlConverter) lineBreak(breaks int) {
+	converter.breaks = max(converter.breaks, breaks)
+	converter.space = false
+}
+
+// pushUnless opens the tag, tags that are not allowed at this point are kept
+// without a name so that their end tag does not close an outer tag
+func (converter *htmlConverter) pushUnless(ignored bool, source string, name string, open string, href string) {
+	if ignored {
+		converter.stack = append(converter.stack, &openTag{source: source})
+		return
+	}
+
+	converter.stack = append(converter.stack, &openTag{source: source, name: name, open: open, href: href})
+}
+
+// pop closes the innermost tag opened by the source tag, tags opened inside
+// of it are closed as well
+func (converter *htmlConverter) pop(source string) {
+	for i := len(converter.stack) - 1; i >= 0; i-- {
+		if converter.stack[i].source != source {
+			continue
+		}
+
+		for j := len(converter.stack) - 1; j >= i; j-- {
+			converter.close(converter.stack[j])
+		}
+
+		converter.stack = converter.stack[:i]
+
+		return
+	}
+}
+
+func (converter *htmlConverter) close(tag *openTag) {
+	if !tag.written || tag.name == "" {
+		return
+	}
+
+	if !converter.plain {
+		converter.output.WriteString("</" + tag.name + ">")
+		return
+	}
+
+	if tag.name == "a" && !strings.HasSuffix(converter.output.String(), tag.href) {
+		converter.output.WriteString(" (" + tag.href + ")")
+	}
+}
+
+func (converter *htmlConverter) find(name string) *openTag {
+	for _, tag := range converter.stack {
+		if tag.name == name {
+			return tag
+		}
+	}
+
+	return nil
+}
+
+func (converter *htmlConverter) isOpen(name string) bool {
+	return converter.find(name) != nil
+}
+
+// isCode reports whether the text is inside of code, telegram does not allow
+// other tags within code
+func (converter *htmlConverter) isCode() bool {
+	return converter.isOpen("code") || converter.isOpen("pre")
+}
+
+func (converter *htmlConverter) listPrefix() string {
+	if len(converter.lists) == 0 {
+		return "• "
+	}
+
+	list := converter.lists[len(converter.lists)-1]
+	indent := strings.Repeat("  ", len(converter.lists)-1)
+
+	if list.ordered {
+		list.count++
+		return fmt.Sprintf("%s%d. ", indent, list.count)
+	}
+
+	return indent + "• "
+}
+
+// resolveLink returns the absolute url of a link or an empty string if the
+// link can not be opened from telegram
+func (converter *htmlConverter) resolveLink(href string) string {
+	parsed, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || href == "" || strings.HasPrefix(href, "#") {
+		return ""
+	}
+
+	if converter.baseUrl != nil {
+		parsed = converter.baseUrl.ResolveReference(parsed)
+	}
+
+	if !slices.Contains(linkSchemes, strings.ToLower(parsed.Scheme)) {
+		return ""
+	}
+
+	return parsed.String()
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"golang.org/x/net/html"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// telegramTags are the tags the sanitized html may contain
+var telegramTags = []string{"b", "i", "u", "s", "a", "code", "pre", "blockquote"}
+
+func TestSanitizeHTML(t *testing.T) {
+	t.Run("Test feed snippets", func(t *testing.T) {
+		baseUrl, _ := url.Parse("https://example.com/blog/post/")
+
+		fixtures, err := filepath.Glob("testdata/html/*.html")
+		if err != nil || len(fixtures) == 0 {
+			t.Fatalf("Could not find fixtures: %v", err)
+		}
+
+		for _, fixture := range fixtures {
+			input, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected, err := os.ReadFile(strings.TrimSuffix(fixture, ".html") + ".txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output := SanitizeHTML(string(input), baseUrl, 0)
+			if output != strings.TrimSuffix(string(expected), "\n") {
+				t.Errorf("Sanitized html of %s is incorrect, got:\n%s\nwant:\n%s", fixture, output, expected)
+			}
+
+			checkTelegramHTML(t, fixture, output)
+		}
+	})
+
+	t.Run("Test text is cut at the limit", func(t *testing.T) {
+		output := SanitizeHTML("<p>First <b>paragraph</b></p><p>Second paragraph</p>", nil, 20)
+		if output != "First <b>paragraph</b>\n\nSecond…" {
+			t.Errorf("Cut html is incorrect, got: %s", output)
+		}
+
+		output = SanitizeHTML("<b>Bold text</b> after", nil, 4)
+		if output != "<b>Bold…</b>" {
+			t.Errorf("Expected the open tags to be closed after the cut, got: %s", output)
+		}
+	})
+
+	t.Run("Test empty tags are removed", func(t *testing.T) {
+		output := SanitizeHTML("<b></b><i> </i><a href=\"https://example.com\"><img src=\"a.png\"></a>Text", nil, 0)
+		if output != "Text" {
+			t.Errorf("Expected empty tags to be removed, got: %s", output)
+		}
+	})
+}
+
+func TestHTMLToText(t *testing.T) {
+	input := "<b>Title &amp; more</b>\n\nSee <a href=\"https://example.com/a?b=1&amp;c=2\">the post</a> or <a href=\"https://example.com\">https://example.com</a>\n\n<pre>a &lt; b</pre>"
+	expected := "Title & more\n\nSee the post (https://example.com/a?b=1&c=2) or https://example.com\n\na < b"
+
+	if output := HTMLToText(input); output != expected {
+		t.Errorf("Text of html is incorrect, got: %s, want: %s", output, expected)
+	}
+}
+
+func TestEscapeHTML(t *testing.T) {
+	if output := EscapeHTML("Tom & Jerry <3 \"quotes\""); output != "Tom &amp; Jerry &lt;3 \"quotes\"" {
+		t.Errorf("Escaped text is incorrect, got: %s", output)
+	}
+}
+
+// checkTelegramHTML fails if the output contains tags telegram does not
+// support or tags that are not balanced
+func checkTelegramHTML(t *testing.T, fixture string, output string) {
+	tokenizer := html.NewTokenizer(strings.NewReader(output))
+
+	var open []string
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() != io.EOF {
+				t.Errorf("Output of %s is not valid html: %v", fixture, tokenizer.Err())
+			}
+
+			if len(open) > 0 {
+				t.Errorf("Output of %s does not close %v", fixture, open)
+			}
+
+			return
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if !slices.Contains(telegramTags, string(name)) {
+				t.Errorf("Output of %s contains unsupported tag %s", fixture, name)
+			}
+
+			open = append(open, string(name))
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if len(open) == 0 || open[len(open)-1] != string(name) {
+				t.Errorf("Output of %s closes %s out of order", fixture, name)
+				return
+			}
+
+			open = open[:len(open)-1]
+		case html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			t.Errorf("Output of %s contains self closing tag %s", fixture, name)
+		}
+	}
+}
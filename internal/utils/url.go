@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// trackingParameters are removed from feed urls, parameters starting with utm_ are removed as well
+var trackingParameters = []string{"fbclid", "gclid", "dclid", "msclkid", "yclid", "igshid", "mc_cid", "mc_eid", "_hsenc", "_hsmi"}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// CanonicalURL returns a copy of the url that is equal for all spellings of the
+// same feed: lower case scheme and host, no default port, no fragment and no
+// tracking parameters. The path and the remaining query are kept as they are,
+// only an empty path and / are the same.
+func CanonicalURL(u *url.URL) *url.URL {
+	canonical := *u
+
+	canonical.Scheme = strings.ToLower(canonical.Scheme)
+
+	host := strings.ToLower(canonical.Hostname())
+	port := canonical.Port()
+	if port != "" && port != defaultPorts[canonical.Scheme] {
+		host = canonical.Host[:len(canonical.Host)-len(port)-1]
+		canonical.Host = strings.ToLower(host) + ":" + port
+	} else if strings.Contains(host, ":") {
+		canonical.Host = "[" + host + "]"
+	} else {
+		canonical.Host = host
+	}
+
+	if canonical.Path == "/" {
+		canonical.Path = ""
+		canonical.RawPath = ""
+	}
+
+	canonical.Fragment = ""
+	canonical.RawFragment = ""
+
+	if canonical.RawQuery != "" {
+		canonical.RawQuery = stripTrackingParameters(canonical.RawQuery)
+	}
+
+	canonical.ForceQuery = false
+
+	return &canonical
+}
+
+// stripTrackingParameters removes the tracking parameters from a raw query,
+// the other parameters keep their order and encoding
+func stripTrackingParameters(rawQuery string) string {
+	var kept []string
+	for _, parameter := range strings.Split(rawQuery, "&") {
+		name, _, _ := strings.Cut(parameter, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "utm_") || slices.Contains(trackingParameters, name) {
+			continue
+		}
+
+		kept = append(kept, parameter)
+	}
+
+	return strings.Join(kept, "&")
+}
+
+// UpgradeScheme switches a http url to https if its request was redirected to
+// https on the same host
+func UpgradeScheme(u *url.URL, redirected *url.URL) *url.URL {
+	if redirected == nil || u.Scheme != "http" || u.Port() != "" || redirected.Scheme != "https" || !strings.EqualFold(u.Hostname(), redirected.Hostname()) {
+		return u
+	}
+
+	upgraded := *u
+	upgraded.Scheme = "https"
+
+	return CanonicalURL(&upgraded)
+}
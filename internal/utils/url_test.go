@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/feed":                               "https://example.com/feed",
+		"https://example.com/feed/":                              "https://example.com/feed/",
+		"HTTPS://Example.COM/feed":                               "https://example.com/feed",
+		"https://example.com:443/feed":                           "https://example.com/feed",
+		"http://example.com:80/feed":                             "http://example.com/feed",
+		"http://example.com:8080/feed":                           "http://example.com:8080/feed",
+		"https://example.com/feed?utm_source=a&utm_medium=b":     "https://example.com/feed",
+		"https://example.com/feed?page=2&fbclid=x&category=news": "https://example.com/feed?page=2&category=news",
+		"https://example.com/feed?q=a%20b&UTM_Source=x&tag=c+d":  "https://example.com/feed?q=a%20b&tag=c+d",
+		"https://example.com/feed#top":                           "https://example.com/feed",
+		"https://example.com/":                                   "https://example.com",
+		"https://example.com":                                    "https://example.com",
+		"https://example.com/Feed.xml":                           "https://example.com/Feed.xml",
+	}
+
+	for input, expected := range tests {
+		parsed, err := url.Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if output := CanonicalURL(parsed).String(); output != expected {
+			t.Errorf("Canonical url of %s is incorrect, got: %s, want: %s", input, output, expected)
+		}
+	}
+}
+
+func TestUpgradeScheme(t *testing.T) {
+	original, _ := url.Parse("http://example.com/feed")
+
+	redirected, _ := url.Parse("https://example.com/feed/")
+	if output := UpgradeScheme(original, redirected).String(); output != "https://example.com/feed" {
+		t.Errorf("Expected upgrade to https, got: %s", output)
+	}
+
+	otherHost, _ := url.Parse("https://cdn.example.org/feed")
+	if output := UpgradeScheme(original, otherHost).String(); output != "http://example.com/feed" {
+		t.Errorf("Expected no upgrade for other hosts, got: %s", output)
+	}
+}